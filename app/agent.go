@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"vpn-service/api"
+	"vpn-service/cluster"
+	"vpn-service/xray"
+)
+
+// runAgent запускает сервис в режиме ноды кластера: только Xray и API агента.
+// Конфигурацию Xray и пользователей присылает панель, БД на ноде не нужна.
+func runAgent() {
+	log.Println("Starting VPN node agent...")
+
+	token := getEnv("AGENT_TOKEN", "")
+	if token == "" {
+		log.Fatal("AGENT_TOKEN environment variable is required")
+	}
+	agentPort := getEnv("AGENT_PORT", "8081")
+
+	// Параметры, которые нода не получает от панели
+	local := &xray.Config{
+		AccessLogPath:     getEnv("LOG_PATH", "/var/log/xray/access.log"),
+		ErrorLogPath:      getEnv("XRAY_ERROR_LOG", "/var/log/xray/error.log"),
+		StatsPort:         10085,
+		APITimeoutSeconds: 3,
+		TLSCertFile:       getEnv("XRAY_TLS_CERT", "/etc/xray/tls/cert.pem"),
+		TLSKeyFile:        getEnv("XRAY_TLS_KEY", "/etc/xray/tls/key.pem"),
+	}
+
+	agent := cluster.NewAgent(token, local)
+
+	server := &http.Server{
+		Addr:         ":" + agentPort,
+		Handler:      api.LoggingMiddleware(agent.Handler()),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// API агента управляет Xray и отдает ключи пользователей, поэтому без TLS
+	// он запускается, только если оператор явно разрешил AGENT_INSECURE_HTTP
+	certFile := getEnv("AGENT_TLS_CERT", "")
+	keyFile := getEnv("AGENT_TLS_KEY", "")
+	insecure := getEnvBool("AGENT_INSECURE_HTTP", false)
+	useTLS := certFile != "" && keyFile != ""
+	if !useTLS && !insecure {
+		log.Fatal("AGENT_TLS_CERT and AGENT_TLS_KEY are required (set AGENT_INSECURE_HTTP=true to serve plain HTTP)")
+	}
+	if useTLS {
+		tlsConfig, err := cluster.ServerTLSConfig(getEnv("AGENT_TLS_CLIENT_CA", ""))
+		if err != nil {
+			log.Fatalf("Failed to configure agent TLS: %v", err)
+		}
+		if tlsConfig.ClientCAs == nil {
+			log.Printf("Warning: AGENT_TLS_CLIENT_CA is not set, agent API does not verify the panel certificate")
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			log.Printf("Agent API listening on port %s (TLS)", agentPort)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Printf("Warning: AGENT_INSECURE_HTTP is set, agent API is plain HTTP")
+			log.Printf("Agent API listening on port %s", agentPort)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start agent API: %v", err)
+		}
+	}()
+
+	log.Println("Waiting for the panel to push configuration...")
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down agent...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping agent API: %v", err)
+	}
+
+	if err := agent.Stop(); err != nil {
+		log.Printf("Error stopping Xray: %v", err)
+	}
+
+	log.Println("Agent stopped")
+}
@@ -3,10 +3,12 @@ package api
 import (
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
 )
 
 // LoggingMiddleware логирует HTTP запросы
@@ -21,7 +23,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		log.Printf("[%s] %s %s - %d (%v)",
 			r.Method,
-			r.RequestURI,
+			maskSubscriptionToken(r.RequestURI),
 			r.RemoteAddr,
 			wrapped.statusCode,
 			time.Since(start),
@@ -29,6 +31,18 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// maskSubscriptionToken скрывает токен подписки в логах: /sub/<token> -> /sub/***
+func maskSubscriptionToken(uri string) string {
+	if !strings.HasPrefix(uri, "/sub/") {
+		return uri
+	}
+	rest := uri[len("/sub/"):]
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		return "/sub/***" + rest[i:]
+	}
+	return "/sub/***"
+}
+
 // responseWriter оборачивает http.ResponseWriter для перехвата статус кода
 type responseWriter struct {
 	http.ResponseWriter
@@ -78,39 +92,62 @@ func ContentTypeMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// AuthMiddleware проверяет Bearer токен в заголовке Authorization
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Получаем токен из переменной окружения
-		expectedToken := os.Getenv("API_BEARER_TOKEN")
-		if expectedToken == "" {
-			log.Println("Warning: API_BEARER_TOKEN is not set, authentication disabled")
-			next.ServeHTTP(w, r)
-			return
-		}
+// AuthMiddleware проверяет Bearer токен в заголовке Authorization: статический
+// API_BEARER_TOKEN или access токен сессии администратора. Вызывающий сохраняется
+// в контексте запроса (services.PrincipalFromContext).
+func AuthMiddleware(authService *services.AuthService) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Без API_BEARER_TOKEN, администраторов и ключей API аутентификация отключена
+			if !authService.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		// Получаем заголовок Authorization
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			responses.SendUnauthorized(w, "Missing authorization header")
-			return
-		}
+			// Получаем заголовок Authorization
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				responses.SendUnauthorized(w, "Missing authorization header")
+				return
+			}
 
-		// Проверяем формат Bearer токена
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			responses.SendUnauthorized(w, "Invalid authorization header format. Expected: Bearer <token>")
-			return
-		}
+			// Проверяем формат Bearer токена
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				responses.SendUnauthorized(w, "Invalid authorization header format. Expected: Bearer <token>")
+				return
+			}
 
-		// Проверяем токен
-		token := parts[1]
-		if token != expectedToken {
-			responses.SendUnauthorized(w, "Invalid authentication token")
-			return
+			// Проверяем токен
+			principal, err := authService.Authenticate(parts[1])
+			if err != nil {
+				responses.SendUnauthorized(w, "Invalid authentication token")
+				return
+			}
+
+			// Токен валиден, продолжаем обработку запроса
+			next.ServeHTTP(w, r.WithContext(services.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RequireScope пропускает к handler только вызывающего со scope (ScopeAdmin дает все).
+// Без аутентификации (AuthMiddleware ее отключил) вызывающего нет и проверка не нужна.
+func RequireScope(scope string, handler http.HandlerFunc) http.Handler {
+	return RequireScopes([]string{scope}, handler)
+}
+
+// RequireScopes - RequireScope для маршрутов, которым нужны все scopes из списка
+func RequireScopes(scopes []string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := services.PrincipalFromContext(r.Context())
+		for _, scope := range scopes {
+			if principal != nil && !principal.HasScope(scope) {
+				responses.SendError(w, http.StatusForbidden, "Missing required scope: "+scope)
+				return
+			}
 		}
 
-		// Токен валиден, продолжаем обработку запроса
-		next.ServeHTTP(w, r)
+		handler(w, r)
 	})
 }
@@ -2,13 +2,25 @@ package api
 
 import (
 	"vpn-service/controllers"
+	"vpn-service/services"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // SetupRouter настраивает и возвращает настроенный маршрутизатор
-func SetupRouter(mainController *controllers.MainController, userController *controllers.UserController) *mux.Router {
+func SetupRouter(
+	authService *services.AuthService,
+	authController *controllers.AuthController,
+	apiKeyController *controllers.APIKeyController,
+	mainController *controllers.MainController,
+	userController *controllers.UserController,
+	trafficController *controllers.TrafficController,
+	planController *controllers.PlanController,
+	subscriptionController *controllers.SubscriptionController,
+	nodeController *controllers.NodeController,
+	realityController *controllers.RealityController,
+) *mux.Router {
 	router := mux.NewRouter()
 
 	// Middleware
@@ -16,24 +28,81 @@ func SetupRouter(mainController *controllers.MainController, userController *con
 	router.Use(RecoveryMiddleware)
 	router.Use(CORSMiddleware)
 
+	// Вход администратора - до аутентификации API
+	router.HandleFunc("/api/auth/login", authController.Login).Methods("POST")
+	router.HandleFunc("/api/auth/refresh", authController.Refresh).Methods("POST")
+
 	// API endpoints
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	// Применяем аутентификацию ко всем API endpoints
-	apiRouter.Use(AuthMiddleware)
+	// Применяем аутентификацию ко всем API endpoints; права проверяет RequireScope у каждого маршрута:
+	// без нужного scope - 403 "Missing required scope: <scope>". Scopes маршрутов описаны
+	// в swagger.yaml (x-required-scopes) и README - при изменении обновите и их.
+	apiRouter.Use(AuthMiddleware(authService))
+
+	// Сессия - любому аутентифицированному вызывающему
+	apiRouter.HandleFunc("/auth/logout", authController.Logout).Methods("POST")
+	apiRouter.HandleFunc("/auth/me", authController.Me).Methods("GET")
+
+	// Администраторы
+	apiRouter.Handle("/admins", RequireScope(services.ScopeAdmin, authController.CreateAdmin)).Methods("POST")
+	apiRouter.Handle("/admins", RequireScope(services.ScopeAdmin, authController.ListAdmins)).Methods("GET")
+	apiRouter.Handle("/admins/{id}", RequireScope(services.ScopeAdmin, authController.UpdateAdmin)).Methods("PATCH", "PUT")
+	apiRouter.Handle("/admins/{id}", RequireScope(services.ScopeAdmin, authController.DeleteAdmin)).Methods("DELETE")
+	apiRouter.Handle("/admins/{id}/revoke", RequireScope(services.ScopeAdmin, authController.RevokeSessions)).Methods("POST")
+
+	// Ключи API и роли
+	apiRouter.Handle("/keys", RequireScope(services.ScopeAdmin, apiKeyController.CreateAPIKey)).Methods("POST")
+	apiRouter.Handle("/keys", RequireScope(services.ScopeAdmin, apiKeyController.ListAPIKeys)).Methods("GET")
+	apiRouter.Handle("/keys/{id}", RequireScope(services.ScopeAdmin, apiKeyController.DeleteAPIKey)).Methods("DELETE")
+	apiRouter.Handle("/roles", RequireScope(services.ScopeAdmin, apiKeyController.ListRoles)).Methods("GET")
 
 	// Users - используем контроллер
-	apiRouter.HandleFunc("/users", userController.CreateUser).Methods("POST")
-	apiRouter.HandleFunc("/users", userController.ListUsers).Methods("GET")
-	apiRouter.HandleFunc("/users/{id}", userController.GetUser).Methods("GET")
-	apiRouter.HandleFunc("/users/{id}", userController.UpdateUser).Methods("PATCH", "PUT")
-	apiRouter.HandleFunc("/users/{id}", userController.DeleteUser).Methods("DELETE")
-	apiRouter.HandleFunc("/users/{id}/config", userController.GetUserConfig).Methods("GET")
-	apiRouter.HandleFunc("/users/{id}/reset-traffic", userController.ResetTraffic).Methods("POST")
+	apiRouter.Handle("/users", RequireScope(services.ScopeUsersWrite, userController.CreateUser)).Methods("POST")
+	apiRouter.Handle("/users", RequireScope(services.ScopeUsersRead, userController.ListUsers)).Methods("GET")
+	apiRouter.Handle("/users/{id}", RequireScope(services.ScopeUsersRead, userController.GetUser)).Methods("GET")
+	apiRouter.Handle("/users/{id}", RequireScope(services.ScopeUsersWrite, userController.UpdateUser)).Methods("PATCH", "PUT")
+	apiRouter.Handle("/users/{id}", RequireScope(services.ScopeUsersWrite, userController.DeleteUser)).Methods("DELETE")
+	apiRouter.Handle("/users/{id}/config", RequireScope(services.ScopeConfigRead, userController.GetUserConfig)).Methods("GET")
+	apiRouter.Handle("/users/{id}/reset-traffic", RequireScope(services.ScopeUsersWrite, userController.ResetTraffic)).Methods("POST")
+	// Новая ссылка подписки выдает конфигурации пользователя, поэтому нужен и config:read
+	apiRouter.Handle("/users/{id}/subscription/rotate",
+		RequireScopes([]string{services.ScopeUsersWrite, services.ScopeConfigRead}, userController.RotateSubscription)).Methods("POST")
+	apiRouter.Handle("/users/{id}/sessions", RequireScope(services.ScopeUsersRead, userController.GetUserSessions)).Methods("GET")
+	apiRouter.Handle("/users/{id}/traffic", RequireScope(services.ScopeUsersRead, trafficController.GetUserTraffic)).Methods("GET")
+	apiRouter.Handle("/users/{id}/traffic/periods", RequireScope(services.ScopeUsersRead, trafficController.GetUserPeriods)).Methods("GET")
+
+	// Plans
+	apiRouter.Handle("/plans", RequireScope(services.ScopeAdmin, planController.CreatePlan)).Methods("POST")
+	apiRouter.Handle("/plans", RequireScope(services.ScopeUsersRead, planController.ListPlans)).Methods("GET")
+	apiRouter.Handle("/plans/{id}", RequireScope(services.ScopeUsersRead, planController.GetPlan)).Methods("GET")
+	apiRouter.Handle("/plans/{id}", RequireScope(services.ScopeAdmin, planController.UpdatePlan)).Methods("PATCH", "PUT")
+	apiRouter.Handle("/plans/{id}", RequireScope(services.ScopeAdmin, planController.DeletePlan)).Methods("DELETE")
+
+	// Cluster nodes
+	apiRouter.Handle("/nodes", RequireScope(services.ScopeAdmin, nodeController.CreateNode)).Methods("POST")
+	apiRouter.Handle("/nodes", RequireScope(services.ScopeAdmin, nodeController.ListNodes)).Methods("GET")
+	apiRouter.Handle("/nodes/{id}", RequireScope(services.ScopeAdmin, nodeController.GetNode)).Methods("GET")
+	apiRouter.Handle("/nodes/{id}", RequireScope(services.ScopeAdmin, nodeController.UpdateNode)).Methods("PATCH", "PUT")
+	apiRouter.Handle("/nodes/{id}", RequireScope(services.ScopeAdmin, nodeController.DeleteNode)).Methods("DELETE")
+	apiRouter.Handle("/nodes/{id}/sync", RequireScope(services.ScopeAdmin, nodeController.SyncNode)).Methods("POST")
+
+	// Traffic history
+	apiRouter.Handle("/traffic", RequireScope(services.ScopeUsersRead, trafficController.GetFleetTraffic)).Methods("GET")
+
+	// Administration
+	apiRouter.Handle("/admin/reconcile", RequireScope(services.ScopeAdmin, mainController.Reconcile)).Methods("POST")
+	apiRouter.Handle("/admin/health", RequireScope(services.ScopeAdmin, mainController.HealthDetails)).Methods("GET")
+	apiRouter.Handle("/admin/reality", RequireScope(services.ScopeAdmin, realityController.GetKeys)).Methods("GET")
+	apiRouter.Handle("/admin/reality/rotate", RequireScope(services.ScopeAdmin, realityController.RotateKeys)).Methods("POST")
+	apiRouter.Handle("/admin/reality/targets", RequireScope(services.ScopeAdmin, realityController.GetTargets)).Methods("GET")
 
 	// System - используем main контроллер для системных endpoints
 	router.HandleFunc("/health", mainController.HealthCheck).Methods("GET")
 	router.HandleFunc("/stats", mainController.GetStats).Methods("GET")
 
+	// Публичная подписка - аутентификация по токену в URL
+	router.HandleFunc("/sub/{token}", subscriptionController.GetSubscription).Methods("GET")
+
 	// Prometheus metrics
 	router.Handle("/metrics", promhttp.Handler())
 
@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"vpn-service/database"
+	"vpn-service/responses"
+	"vpn-service/xray"
+
+	"github.com/gorilla/mux"
+)
+
+// maxStateBytes ограничивает размер тела запроса с состоянием ноды
+const maxStateBytes = 64 << 20
+
+// Agent - режим ноды: держит Xray и применяет состояние, присланное панелью.
+// До первой синхронизации Xray не запущен.
+type Agent struct {
+	token string
+	// local - параметры, которые нода не берет от панели: логи, порт API, файлы TLS
+	local *xray.Config
+
+	mu         sync.Mutex
+	manager    *xray.Manager
+	configHash string
+	users      map[string]NodeUser
+}
+
+// NewAgent создает агент ноды. token - общий секрет с панелью.
+func NewAgent(token string, local *xray.Config) *Agent {
+	return &Agent{
+		token: token,
+		local: local,
+		users: make(map[string]NodeUser),
+	}
+}
+
+// Handler возвращает HTTP API агента с проверкой токена
+func (a *Agent) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc(PathStatus, a.handleStatus).Methods("GET")
+	router.HandleFunc(PathState, a.handleState).Methods("PUT")
+	router.HandleFunc(PathTraffic, a.handleTraffic).Methods("GET")
+	router.HandleFunc(PathSessions, a.handleSessions).Methods("GET")
+	return a.authenticate(router)
+}
+
+// Stop останавливает Xray ноды
+func (a *Agent) Stop() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.manager == nil || !a.manager.IsRunning() {
+		return nil
+	}
+	return a.manager.Stop()
+}
+
+// Status возвращает текущее состояние агента и проверяет API Xray
+func (a *Agent) Status() *Status {
+	a.mu.Lock()
+	manager := a.manager
+	status := &Status{
+		Running:    manager != nil && manager.IsRunning(),
+		ConfigHash: a.configHash,
+		Users:      len(a.users),
+	}
+	a.mu.Unlock()
+
+	if !status.Running {
+		status.APIError = "xray is not running"
+		return status
+	}
+
+	// Проверка по сети может занять до таймаута API, поэтому вне mu
+	if err := manager.CheckAPI(); err != nil {
+		status.APIError = err.Error()
+	} else {
+		status.APIOK = true
+	}
+	return status
+}
+
+// ApplyState приводит Xray к состоянию панели через xray.Manager.Apply:
+// если изменились только пользователи, они меняются через API без перезапуска.
+func (a *Agent) ApplyState(state *State) (*SyncResult, error) {
+	if state == nil || state.Config == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+
+	desired := make(map[string]NodeUser, len(state.Users))
+	users := make([]*database.User, 0, len(state.Users))
+	for _, user := range state.Users {
+		desired[user.Username] = user
+		users = append(users, user.toUser())
+	}
+	cfg := a.nodeConfig(state.Config)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.manager == nil {
+		manager, err := xray.NewManager(cfg)
+		if err != nil {
+			return nil, err
+		}
+		a.manager = manager
+	}
+
+	reload, err := a.manager.Apply(cfg, users)
+	if err != nil {
+		return nil, err
+	}
+	a.configHash = ConfigHash(state.Config)
+	a.users = desired
+
+	return &SyncResult{
+		Path:      reload.Path,
+		Restarted: reload.Path == xray.ReloadPathStart || reload.Path == xray.ReloadPathRebuild,
+		Added:     reload.Added,
+		Removed:   reload.Removed,
+		Updated:   reload.Updated,
+		Users:     len(desired),
+	}, nil
+}
+
+// QueryUserTraffic возвращает счетчики трафика Xray ноды
+func (a *Agent) QueryUserTraffic(reset bool) (map[string]*xray.UserTraffic, error) {
+	a.mu.Lock()
+	manager := a.manager
+	a.mu.Unlock()
+
+	if manager == nil {
+		return nil, fmt.Errorf("xray is not running")
+	}
+	return manager.QueryUserTraffic(reset)
+}
+
+// QueryOnlineIPs возвращает IP адреса подключенных пользователей ноды.
+// Пустой список emails - все пользователи, загруженные в Xray.
+func (a *Agent) QueryOnlineIPs(emails []string) (map[string][]xray.OnlineIP, error) {
+	a.mu.Lock()
+	manager := a.manager
+	if len(emails) == 0 {
+		emails = make([]string, 0, len(a.users))
+		for username := range a.users {
+			emails = append(emails, username)
+		}
+	}
+	a.mu.Unlock()
+
+	if manager == nil {
+		return nil, fmt.Errorf("xray is not running")
+	}
+	return manager.QueryOnlineIPs(emails)
+}
+
+// nodeConfig накладывает локальные параметры ноды на конфигурацию панели
+func (a *Agent) nodeConfig(cfg *xray.Config) *xray.Config {
+	nodeCfg := *cfg
+	nodeCfg.AccessLogPath = a.local.AccessLogPath
+	nodeCfg.ErrorLogPath = a.local.ErrorLogPath
+	nodeCfg.StatsPort = a.local.StatsPort
+	nodeCfg.APITimeoutSeconds = a.local.APITimeoutSeconds
+	nodeCfg.TLSCertFile = a.local.TLSCertFile
+	nodeCfg.TLSKeyFile = a.local.TLSKeyFile
+	return &nodeCfg
+}
+
+func (a *Agent) handleStatus(w http.ResponseWriter, r *http.Request) {
+	responses.SendSuccess(w, a.Status())
+}
+
+func (a *Agent) handleState(w http.ResponseWriter, r *http.Request) {
+	var state State
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStateBytes)).Decode(&state); err != nil {
+		responses.SendBadRequest(w, "Invalid state body")
+		return
+	}
+
+	result, err := a.ApplyState(&state)
+	if err != nil {
+		log.Printf("Failed to apply state from panel: %v", err)
+		responses.SendInternalError(w, err.Error())
+		return
+	}
+
+	responses.SendSuccess(w, result)
+}
+
+func (a *Agent) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	reset := r.URL.Query().Get("reset") == "true"
+	traffic, err := a.QueryUserTraffic(reset)
+	if err != nil {
+		responses.SendError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	responses.SendSuccess(w, traffic)
+}
+
+func (a *Agent) handleSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := a.QueryOnlineIPs(r.URL.Query()["user"])
+	if err != nil {
+		responses.SendError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	responses.SendSuccess(w, sessions)
+}
+
+// authenticate проверяет Bearer токен панели
+func (a *Agent) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			responses.SendUnauthorized(w, "Missing or invalid authorization header")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(a.token)) != 1 {
+			responses.SendUnauthorized(w, "Invalid agent token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"vpn-service/xray"
+)
+
+// Client - клиент панели к API агента ноды
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewHTTPClient создает HTTP клиент, общий для клиентов всех агентов.
+// tlsConfig - CA агентов и сертификат панели для mTLS (nil - настройки по умолчанию).
+func NewHTTPClient(timeout time.Duration, tlsConfig *tls.Config) *http.Client {
+	httpClient := &http.Client{Timeout: timeout}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+	return httpClient
+}
+
+// NewClient создает клиент агента
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// agentResponse повторяет формат responses.Response / responses.ErrorResponse
+type agentResponse struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// Status возвращает состояние агента
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do(http.MethodGet, PathStatus, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ApplyState отправляет агенту желаемое состояние ноды
+func (c *Client) ApplyState(state *State) (*SyncResult, error) {
+	var result SyncResult
+	if err := c.do(http.MethodPut, PathState, state, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryUserTraffic возвращает счетчики трафика пользователей на ноде.
+// При reset=true счетчики на ноде обнуляются после чтения.
+func (c *Client) QueryUserTraffic(reset bool) (map[string]*xray.UserTraffic, error) {
+	path := PathTraffic
+	if reset {
+		path += "?reset=true"
+	}
+
+	traffic := make(map[string]*xray.UserTraffic)
+	if err := c.do(http.MethodGet, path, nil, &traffic); err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+// QueryOnlineIPs возвращает IP адреса подключенных пользователей ноды.
+// Пустой список emails - все пользователи ноды.
+func (c *Client) QueryOnlineIPs(emails []string) (map[string][]xray.OnlineIP, error) {
+	path := PathSessions
+	if len(emails) > 0 {
+		path += "?" + url.Values{"user": emails}.Encode()
+	}
+
+	sessions := make(map[string][]xray.OnlineIP)
+	if err := c.do(http.MethodGet, path, nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope agentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("invalid agent response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || !envelope.Success {
+		return fmt.Errorf("agent error (HTTP %d): %s", resp.StatusCode, envelope.Error)
+	}
+
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return fmt.Errorf("invalid agent response data: %w", err)
+		}
+	}
+	return nil
+}
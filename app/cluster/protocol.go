@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+// Пути API агента. Панель и агенты должны работать на одной версии сервиса,
+// так как конфигурация Xray передается как есть.
+const (
+	PathStatus   = "/agent/v1/status"
+	PathState    = "/agent/v1/state"
+	PathTraffic  = "/agent/v1/traffic"
+	PathSessions = "/agent/v1/sessions"
+)
+
+// NodeUser - пользователь в том виде, в котором панель передает его агенту.
+// Содержит только поля, нужные для inbound Xray.
+type NodeUser struct {
+	Username       string `json:"username"`
+	UUID           string `json:"uuid"`
+	Secret         string `json:"secret"`
+	TrojanPassword string `json:"trojan_password"`
+	ShortID        string `json:"short_id"`
+	Flow           string `json:"flow"`
+	Vless          bool   `json:"vless"`
+	Shadowsocks    bool   `json:"shadowsocks"`
+	Trojan         bool   `json:"trojan"`
+	VMess          bool   `json:"vmess"`
+	SpeedUp        int64  `json:"speed_up"`   // байт/с, 0 = без ограничения
+	SpeedDown      int64  `json:"speed_down"` // байт/с, 0 = без ограничения
+}
+
+// NewNodeUser формирует пользователя для агента
+func NewNodeUser(user *database.User) NodeUser {
+	return NodeUser{
+		Username:       user.Username,
+		UUID:           user.UUID,
+		Secret:         user.Secret,
+		TrojanPassword: user.TrojanPassword,
+		ShortID:        user.ShortID,
+		Flow:           user.Flow,
+		Vless:          user.VlessEnabled,
+		Shadowsocks:    user.ShadowsocksEnabled,
+		Trojan:         user.TrojanEnabled,
+		VMess:          user.VmessEnabled,
+		SpeedUp:        user.SpeedLimitUp,
+		SpeedDown:      user.SpeedLimitDown,
+	}
+}
+
+// toUser восстанавливает пользователя для xray.Manager на стороне агента.
+// Ограничения проверяет панель, поэтому пользователь всегда активен.
+func (u NodeUser) toUser() *database.User {
+	return &database.User{
+		Username:           u.Username,
+		UUID:               u.UUID,
+		Secret:             u.Secret,
+		TrojanPassword:     u.TrojanPassword,
+		ShortID:            u.ShortID,
+		Flow:               u.Flow,
+		IsActive:           true,
+		VlessEnabled:       u.Vless,
+		ShadowsocksEnabled: u.Shadowsocks,
+		TrojanEnabled:      u.Trojan,
+		VmessEnabled:       u.VMess,
+		SpeedLimitUp:       u.SpeedUp,
+		SpeedLimitDown:     u.SpeedDown,
+	}
+}
+
+// State - желаемое состояние ноды: конфигурация Xray и список пользователей
+type State struct {
+	Config *xray.Config `json:"config"`
+	Users  []NodeUser   `json:"users"`
+}
+
+// Status - текущее состояние агента
+type Status struct {
+	Running    bool   `json:"running"`
+	APIOK      bool   `json:"api_ok"` // Xray ответил по gRPC API
+	APIError   string `json:"api_error,omitempty"`
+	ConfigHash string `json:"config_hash"`
+	Users      int    `json:"users"`
+}
+
+// SyncResult - результат применения состояния на агенте
+type SyncResult struct {
+	Path      string `json:"path"` // см. xray.ReloadPath*
+	Restarted bool   `json:"restarted"`
+	Added     int    `json:"added"`
+	Removed   int    `json:"removed"`
+	Updated   int    `json:"updated"`
+	Users     int    `json:"users"`
+}
+
+// ConfigHash возвращает отпечаток конфигурации панели (см. Status)
+func ConfigHash(cfg *xray.Config) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
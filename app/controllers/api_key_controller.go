@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKeyController обрабатывает HTTP запросы управления ключами API
+type APIKeyController struct {
+	authService *services.AuthService
+}
+
+// NewAPIKeyController создает новый экземпляр APIKeyController
+func NewAPIKeyController(authService *services.AuthService) *APIKeyController {
+	return &APIKeyController{
+		authService: authService,
+	}
+}
+
+// APIKeyRequest представляет запрос на создание ключа API
+type APIKeyRequest struct {
+	Name          string   `json:"name"`
+	Role          string   `json:"role,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	ExpiresInDays *int     `json:"expires_in_days,omitempty"` // не задано = бессрочно
+}
+
+// CreateAPIKey создает ключ API. Сам ключ возвращается только в этом ответе.
+func (c *APIKeyController) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	dto := services.APIKeyDTO{Name: req.Name, Role: req.Role, Scopes: req.Scopes}
+	if req.ExpiresInDays != nil {
+		if *req.ExpiresInDays <= 0 {
+			responses.SendBadRequest(w, "expires_in_days must be positive")
+			return
+		}
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		dto.ExpiresAt = &expiresAt
+	}
+
+	key, err := c.authService.CreateAPIKey(dto)
+	if err != nil {
+		sendAPIKeyError(w, err, "Failed to create API key")
+		return
+	}
+
+	responses.SendCreated(w, key)
+}
+
+// ListAPIKeys возвращает список ключей API
+func (c *APIKeyController) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := c.authService.ListAPIKeys()
+	if err != nil {
+		responses.SendInternalError(w, "Failed to list API keys")
+		return
+	}
+
+	responses.SendSuccess(w, keys)
+}
+
+// DeleteAPIKey отзывает ключ API
+func (c *APIKeyController) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid API key ID")
+		return
+	}
+
+	if err := c.authService.DeleteAPIKey(uint(id)); err != nil {
+		sendAPIKeyError(w, err, "Failed to delete API key")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]string{
+		"message": "API key revoked successfully",
+	})
+}
+
+// ListRoles возвращает роли и их scopes
+func (c *APIKeyController) ListRoles(w http.ResponseWriter, r *http.Request) {
+	responses.SendSuccess(w, map[string]interface{}{
+		"roles":  services.Roles,
+		"scopes": services.KnownScopes,
+	})
+}
+
+func sendAPIKeyError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		responses.SendNotFound(w, "API key not found")
+	case errors.Is(err, services.ErrAPIKeyExists):
+		responses.SendBadRequest(w, "API key name already exists")
+	case errors.Is(err, services.ErrInvalidAPIKeyName):
+		responses.SendBadRequest(w, "API key name is required")
+	case errors.Is(err, services.ErrNoScopes):
+		responses.SendBadRequest(w, "Role or scopes are required")
+	case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrInvalidScope):
+		responses.SendBadRequest(w, err.Error())
+	default:
+		responses.SendInternalError(w, fallback)
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// AuthController обрабатывает вход администраторов и управление ими
+type AuthController struct {
+	authService *services.AuthService
+}
+
+// NewAuthController создает новый экземпляр AuthController
+func NewAuthController(authService *services.AuthService) *AuthController {
+	return &AuthController{
+		authService: authService,
+	}
+}
+
+// LoginRequest представляет запрос на вход администратора
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest представляет запрос на обновление токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AdminRequest представляет запрос на создание или обновление администратора
+type AdminRequest struct {
+	Username *string `json:"username,omitempty"`
+	Password *string `json:"password,omitempty"`
+	Role     *string `json:"role,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+}
+
+func (req AdminRequest) toDTO() services.AdminDTO {
+	return services.AdminDTO{
+		Username: req.Username,
+		Password: req.Password,
+		Role:     req.Role,
+		Enabled:  req.Enabled,
+	}
+}
+
+// Login проверяет логин и пароль и возвращает access и refresh токены
+func (c *AuthController) Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	tokens, err := c.authService.Login(req.Username, req.Password)
+	if err != nil {
+		sendAuthError(w, err, "Failed to log in")
+		return
+	}
+
+	responses.SendSuccess(w, tokens)
+}
+
+// Refresh выдает новые токены по refresh токену
+func (c *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		responses.SendBadRequest(w, "refresh_token is required")
+		return
+	}
+
+	tokens, err := c.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		sendAuthError(w, err, "Failed to refresh session")
+		return
+	}
+
+	responses.SendSuccess(w, tokens)
+}
+
+// Logout отзывает текущую сессию
+func (c *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := c.authService.Logout(services.PrincipalFromContext(r.Context())); err != nil {
+		sendAuthError(w, err, "Failed to log out")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]string{
+		"message": "Logged out successfully",
+	})
+}
+
+// Me возвращает текущего вызывающего
+func (c *AuthController) Me(w http.ResponseWriter, r *http.Request) {
+	principal := services.PrincipalFromContext(r.Context())
+	if principal == nil {
+		// Аутентификация отключена
+		principal = &services.Principal{Username: services.BootstrapAdmin, Role: services.RoleAdmin,
+			Scopes: []string{services.ScopeAdmin}, Bootstrap: true}
+	}
+
+	responses.SendSuccess(w, principal)
+}
+
+// CreateAdmin создает администратора
+func (c *AuthController) CreateAdmin(w http.ResponseWriter, r *http.Request) {
+	var req AdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	admin, err := c.authService.CreateAdmin(req.toDTO())
+	if err != nil {
+		sendAuthError(w, err, "Failed to create admin")
+		return
+	}
+
+	responses.SendCreated(w, admin)
+}
+
+// ListAdmins возвращает список администраторов
+func (c *AuthController) ListAdmins(w http.ResponseWriter, r *http.Request) {
+	admins, err := c.authService.ListAdmins()
+	if err != nil {
+		responses.SendInternalError(w, "Failed to list admins")
+		return
+	}
+
+	responses.SendSuccess(w, admins)
+}
+
+// UpdateAdmin обновляет администратора
+func (c *AuthController) UpdateAdmin(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	var req AdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	admin, err := c.authService.UpdateAdmin(id, req.toDTO())
+	if err != nil {
+		sendAuthError(w, err, "Failed to update admin")
+		return
+	}
+
+	responses.SendSuccess(w, admin)
+}
+
+// DeleteAdmin удаляет администратора
+func (c *AuthController) DeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.authService.DeleteAdmin(id); err != nil {
+		sendAuthError(w, err, "Failed to delete admin")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]string{
+		"message": "Admin deleted successfully",
+	})
+}
+
+// RevokeSessions отзывает все сессии администратора
+func (c *AuthController) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := c.authService.RevokeSessions(id)
+	if err != nil {
+		sendAuthError(w, err, "Failed to revoke sessions")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]int64{
+		"revoked": revoked,
+	})
+}
+
+func parseAdminID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid admin ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func sendAuthError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case services.ErrInvalidCredentials:
+		responses.SendUnauthorized(w, "Invalid username or password")
+	case services.ErrInvalidToken:
+		responses.SendUnauthorized(w, "Invalid or expired token")
+	case services.ErrNoSession:
+		responses.SendBadRequest(w, "Only admin sessions can log out")
+	case services.ErrAdminNotFound:
+		responses.SendNotFound(w, "Admin not found")
+	case services.ErrAdminExists:
+		responses.SendBadRequest(w, "Admin username already exists")
+	case services.ErrInvalidAdminName:
+		responses.SendBadRequest(w, "Admin username is required")
+	case services.ErrInvalidRole:
+		responses.SendBadRequest(w, "Unknown role")
+	case services.ErrWeakPassword:
+		responses.SendBadRequest(w, "Password must be at least "+strconv.Itoa(services.MinPasswordLength)+" characters")
+	default:
+		responses.SendInternalError(w, fallback)
+	}
+}
@@ -10,18 +10,26 @@ import (
 // MainController обрабатывает системные HTTP запросы
 type MainController struct {
 	userService *services.UserService
+	reconciler  *services.Reconciler
 }
 
 // NewMainController создает новый экземпляр MainController
-func NewMainController(userService *services.UserService) *MainController {
+func NewMainController(userService *services.UserService, reconciler *services.Reconciler) *MainController {
 	return &MainController{
 		userService: userService,
+		reconciler:  reconciler,
 	}
 }
 
-// HealthCheck проверяет состояние сервиса
+// HealthCheck проверяет состояние сервиса (публично: статусы и количество нод)
 func (c *MainController) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	status := c.userService.CheckHealth()
+	status := c.userService.CheckHealth(false)
+	responses.SendSuccess(w, status)
+}
+
+// HealthDetails возвращает состояние сервиса с последним reload Xray и состоянием нод
+func (c *MainController) HealthDetails(w http.ResponseWriter, r *http.Request) {
+	status := c.userService.CheckHealth(true)
 	responses.SendSuccess(w, status)
 }
 
@@ -35,3 +43,14 @@ func (c *MainController) GetStats(w http.ResponseWriter, r *http.Request) {
 
 	responses.SendSuccess(w, stats)
 }
+
+// Reconcile сверяет пользователей Xray с БД, исправляет расхождения и возвращает отчет
+func (c *MainController) Reconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := c.reconciler.Run()
+	if err != nil {
+		responses.SendError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to reconcile Xray users: %v", err))
+		return
+	}
+
+	responses.SendSuccess(w, report)
+}
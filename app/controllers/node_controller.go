@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// NodeController обрабатывает HTTP запросы реестра нод кластера
+type NodeController struct {
+	nodeService *services.NodeService
+}
+
+// NewNodeController создает новый экземпляр NodeController
+func NewNodeController(nodeService *services.NodeService) *NodeController {
+	return &NodeController{
+		nodeService: nodeService,
+	}
+}
+
+// NodeRequest представляет запрос на регистрацию или обновление ноды
+type NodeRequest struct {
+	Name      *string  `json:"name,omitempty"`
+	Address   *string  `json:"address,omitempty"`
+	AgentURL  *string  `json:"agent_url,omitempty"`
+	Token     *string  `json:"token,omitempty"`
+	Region    *string  `json:"region,omitempty"`
+	Capacity  *int     `json:"capacity,omitempty"`
+	Protocols []string `json:"protocols,omitempty"`
+	IsEnabled *bool    `json:"is_enabled,omitempty"`
+}
+
+func (req NodeRequest) toDTO() services.NodeDTO {
+	return services.NodeDTO{
+		Name:      req.Name,
+		Address:   req.Address,
+		AgentURL:  req.AgentURL,
+		Token:     req.Token,
+		Region:    req.Region,
+		Capacity:  req.Capacity,
+		Protocols: req.Protocols,
+		IsEnabled: req.IsEnabled,
+	}
+}
+
+// CreateNode регистрирует ноду. Токен агента возвращается только в этом ответе.
+func (c *NodeController) CreateNode(w http.ResponseWriter, r *http.Request) {
+	var req NodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	node, err := c.nodeService.CreateNode(req.toDTO())
+	if err != nil {
+		sendNodeError(w, err, "Failed to create node")
+		return
+	}
+
+	responses.SendCreated(w, node)
+}
+
+// ListNodes возвращает список нод с состоянием синхронизации
+func (c *NodeController) ListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := c.nodeService.ListNodes()
+	if err != nil {
+		responses.SendInternalError(w, "Failed to list nodes")
+		return
+	}
+
+	responses.SendSuccess(w, nodes)
+}
+
+// GetNode возвращает ноду по ID
+func (c *NodeController) GetNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseNodeID(w, r)
+	if !ok {
+		return
+	}
+
+	node, err := c.nodeService.GetNode(id)
+	if err != nil {
+		sendNodeError(w, err, "Failed to get node")
+		return
+	}
+
+	responses.SendSuccess(w, node)
+}
+
+// UpdateNode обновляет ноду
+func (c *NodeController) UpdateNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseNodeID(w, r)
+	if !ok {
+		return
+	}
+
+	var req NodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	node, err := c.nodeService.UpdateNode(id, req.toDTO())
+	if err != nil {
+		sendNodeError(w, err, "Failed to update node")
+		return
+	}
+
+	responses.SendSuccess(w, node)
+}
+
+// DeleteNode удаляет ноду
+func (c *NodeController) DeleteNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseNodeID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.nodeService.DeleteNode(id); err != nil {
+		sendNodeError(w, err, "Failed to delete node")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]string{
+		"message": "Node deleted successfully",
+	})
+}
+
+// SyncNode немедленно синхронизирует пользователей с нодой
+func (c *NodeController) SyncNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseNodeID(w, r)
+	if !ok {
+		return
+	}
+
+	node, err := c.nodeService.SyncNode(id)
+	if err != nil {
+		if errors.Is(err, services.ErrSyncNode) {
+			responses.SendError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		sendNodeError(w, err, "Failed to sync node")
+		return
+	}
+
+	responses.SendSuccess(w, node)
+}
+
+func parseNodeID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid node ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func sendNodeError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case services.ErrNodeNotFound:
+		responses.SendNotFound(w, "Node not found")
+	case services.ErrNodeNameExists:
+		responses.SendBadRequest(w, "Node name already exists")
+	case services.ErrInvalidNode:
+		responses.SendBadRequest(w, "Invalid node parameters")
+	default:
+		responses.SendInternalError(w, fallback)
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// PlanController обрабатывает HTTP запросы связанные с тарифными планами
+type PlanController struct {
+	planService *services.PlanService
+}
+
+// NewPlanController создает новый экземпляр PlanController
+func NewPlanController(planService *services.PlanService) *PlanController {
+	return &PlanController{
+		planService: planService,
+	}
+}
+
+// PlanRequest представляет запрос на создание или обновление плана
+type PlanRequest struct {
+	Name         *string  `json:"name,omitempty"`
+	DurationDays *int     `json:"duration_days,omitempty"`
+	TrafficLimit *int64   `json:"traffic_limit,omitempty"`
+	DeviceLimit  *int     `json:"device_limit,omitempty"`
+	SpeedLimit   *int64   `json:"speed_limit,omitempty"`
+	Protocols    []string `json:"protocols,omitempty"`
+	Flow         *string  `json:"flow,omitempty"`
+}
+
+func (req PlanRequest) toDTO() services.PlanDTO {
+	return services.PlanDTO{
+		Name:         req.Name,
+		DurationDays: req.DurationDays,
+		TrafficLimit: req.TrafficLimit,
+		DeviceLimit:  req.DeviceLimit,
+		SpeedLimit:   req.SpeedLimit,
+		Protocols:    req.Protocols,
+		Flow:         req.Flow,
+	}
+}
+
+// CreatePlan создает новый план
+func (c *PlanController) CreatePlan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	plan, err := c.planService.CreatePlan(req.toDTO())
+	if err != nil {
+		sendPlanError(w, err, "Failed to create plan")
+		return
+	}
+
+	responses.SendCreated(w, plan)
+}
+
+// ListPlans возвращает список планов
+func (c *PlanController) ListPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := c.planService.ListPlans()
+	if err != nil {
+		responses.SendInternalError(w, "Failed to list plans")
+		return
+	}
+
+	responses.SendSuccess(w, plans)
+}
+
+// GetPlan возвращает план по ID
+func (c *PlanController) GetPlan(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePlanID(w, r)
+	if !ok {
+		return
+	}
+
+	plan, err := c.planService.GetPlan(id)
+	if err != nil {
+		sendPlanError(w, err, "Failed to get plan")
+		return
+	}
+
+	responses.SendSuccess(w, plan)
+}
+
+// UpdatePlan обновляет план
+func (c *PlanController) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePlanID(w, r)
+	if !ok {
+		return
+	}
+
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	plan, err := c.planService.UpdatePlan(id, req.toDTO())
+	if err != nil {
+		sendPlanError(w, err, "Failed to update plan")
+		return
+	}
+
+	responses.SendSuccess(w, plan)
+}
+
+// DeletePlan удаляет план
+func (c *PlanController) DeletePlan(w http.ResponseWriter, r *http.Request) {
+	id, ok := parsePlanID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.planService.DeletePlan(id); err != nil {
+		sendPlanError(w, err, "Failed to delete plan")
+		return
+	}
+
+	responses.SendSuccess(w, map[string]string{
+		"message": "Plan deleted successfully",
+	})
+}
+
+func parsePlanID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid plan ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func sendPlanError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case services.ErrPlanNotFound:
+		responses.SendNotFound(w, "Plan not found")
+	case services.ErrInvalidPlanName:
+		responses.SendBadRequest(w, "Plan name is required")
+	case services.ErrPlanNameExists:
+		responses.SendBadRequest(w, "Plan name already exists")
+	case services.ErrInvalidPlan:
+		responses.SendBadRequest(w, "Invalid plan parameters")
+	case services.ErrPlanInUse:
+		responses.SendBadRequest(w, "Plan is assigned to users")
+	default:
+		responses.SendInternalError(w, fallback)
+	}
+}
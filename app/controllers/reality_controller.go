@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"vpn-service/responses"
+	"vpn-service/services"
+)
+
+// RealityController обрабатывает HTTP запросы управления ключами и целями Reality
+type RealityController struct {
+	realityService *services.RealityService
+	targetMonitor  *services.RealityTargetMonitor
+	// defaultOverlap - сколько принимается прежний ключ, если в запросе не указано
+	defaultOverlap time.Duration
+}
+
+// NewRealityController создает новый экземпляр RealityController
+func NewRealityController(realityService *services.RealityService, targetMonitor *services.RealityTargetMonitor,
+	defaultOverlap time.Duration) *RealityController {
+	return &RealityController{
+		realityService: realityService,
+		targetMonitor:  targetMonitor,
+		defaultOverlap: defaultOverlap,
+	}
+}
+
+// RotateKeysRequest представляет запрос на ротацию ключей Reality
+type RotateKeysRequest struct {
+	OverlapHours *int `json:"overlap_hours,omitempty"`
+}
+
+// GetKeys возвращает текущий публичный ключ Reality и состояние ротации
+func (c *RealityController) GetKeys(w http.ResponseWriter, r *http.Request) {
+	status, err := c.realityService.KeyStatus()
+	if err != nil {
+		responses.SendInternalError(w, "Failed to get reality keys")
+		return
+	}
+
+	responses.SendSuccess(w, status)
+}
+
+// RotateKeys генерирует новую пару ключей Reality. Прежний ключ принимается
+// еще overlap_hours часов, чтобы клиенты успели обновить подписки.
+func (c *RealityController) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	var req RotateKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		responses.SendBadRequest(w, "Invalid request body")
+		return
+	}
+
+	overlap := c.defaultOverlap
+	if req.OverlapHours != nil {
+		overlap = time.Duration(*req.OverlapHours) * time.Hour
+	}
+
+	status, err := c.realityService.RotateKeys(overlap)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOverlap) {
+			responses.SendBadRequest(w, "overlap_hours must be positive")
+			return
+		}
+		responses.SendInternalError(w, err.Error())
+		return
+	}
+
+	responses.SendSuccess(w, status)
+}
+
+// GetTargets возвращает пул целей Reality (dest/SNI) с результатами последней проверки
+func (c *RealityController) GetTargets(w http.ResponseWriter, r *http.Request) {
+	responses.SendSuccess(w, c.targetMonitor.Status())
+}
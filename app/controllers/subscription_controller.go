@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// SubscriptionController обрабатывает публичные запросы подписки
+type SubscriptionController struct {
+	subscriptionService *services.SubscriptionService
+}
+
+// NewSubscriptionController создает новый экземпляр SubscriptionController
+func NewSubscriptionController(subscriptionService *services.SubscriptionService) *SubscriptionController {
+	return &SubscriptionController{
+		subscriptionService: subscriptionService,
+	}
+}
+
+// GetSubscription отдает подписку по токену пользователя.
+// Формат задается ?format=base64|clash|singbox, иначе определяется по User-Agent.
+func (c *SubscriptionController) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	format, err := services.DetectSubscriptionFormat(r.URL.Query().Get("format"), r.UserAgent())
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid format (expected base64, clash or singbox)")
+		return
+	}
+
+	subscription, err := c.subscriptionService.GetSubscription(token, format)
+	if subscription != nil {
+		w.Header().Set("subscription-userinfo", subscription.UserInfo)
+		w.Header().Set("profile-update-interval", strconv.Itoa(subscription.UpdateIntervalHours))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSubscriptionNotFound):
+			responses.SendNotFound(w, "Subscription not found")
+		case errors.Is(err, services.ErrSubscriptionInactive):
+			responses.SendError(w, http.StatusForbidden, "Subscription is inactive (expired or over limit)")
+		default:
+			responses.SendInternalError(w, "Failed to generate subscription")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", subscription.Filename))
+	responses.SendText(w, http.StatusOK, subscription.ContentType, subscription.Body)
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"vpn-service/responses"
+	"vpn-service/services"
+
+	"github.com/gorilla/mux"
+)
+
+// TrafficController обрабатывает HTTP запросы истории трафика
+type TrafficController struct {
+	trafficService *services.TrafficService
+}
+
+// NewTrafficController создает новый экземпляр TrafficController
+func NewTrafficController(trafficService *services.TrafficService) *TrafficController {
+	return &TrafficController{
+		trafficService: trafficService,
+	}
+}
+
+// GetUserTraffic возвращает историю трафика пользователя
+func (c *TrafficController) GetUserTraffic(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	dto, ok := parseTrafficQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := c.trafficService.GetUserTraffic(uint(id), dto)
+	if err != nil {
+		sendTrafficError(w, err)
+		return
+	}
+
+	responses.SendSuccess(w, report)
+}
+
+// GetUserPeriods возвращает архив закрытых периодов квоты пользователя
+func (c *TrafficController) GetUserPeriods(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	periods, err := c.trafficService.GetUserPeriods(uint(id))
+	if err != nil {
+		sendTrafficError(w, err)
+		return
+	}
+
+	responses.SendSuccess(w, periods)
+}
+
+// GetFleetTraffic возвращает суммарную историю трафика всех пользователей
+func (c *TrafficController) GetFleetTraffic(w http.ResponseWriter, r *http.Request) {
+	dto, ok := parseTrafficQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := c.trafficService.GetFleetTraffic(dto)
+	if err != nil {
+		sendTrafficError(w, err)
+		return
+	}
+
+	responses.SendSuccess(w, report)
+}
+
+// parseTrafficQuery разбирает параметры from, to (RFC3339 или YYYY-MM-DD) и granularity
+func parseTrafficQuery(w http.ResponseWriter, r *http.Request) (services.TrafficQueryDTO, bool) {
+	query := r.URL.Query()
+	dto := services.TrafficQueryDTO{
+		Granularity: query.Get("granularity"),
+	}
+
+	var err error
+	if dto.From, err = parseTimeParam(query.Get("from")); err != nil {
+		responses.SendBadRequest(w, "Invalid 'from' parameter (expected RFC3339 or YYYY-MM-DD)")
+		return dto, false
+	}
+	if dto.To, err = parseTimeParam(query.Get("to")); err != nil {
+		responses.SendBadRequest(w, "Invalid 'to' parameter (expected RFC3339 or YYYY-MM-DD)")
+		return dto, false
+	}
+
+	return dto, true
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func sendTrafficError(w http.ResponseWriter, err error) {
+	switch err {
+	case services.ErrUserNotFound:
+		responses.SendNotFound(w, "User not found")
+	case services.ErrInvalidGranularity:
+		responses.SendBadRequest(w, "Invalid granularity (expected hour or day)")
+	case services.ErrInvalidTimeRange:
+		responses.SendBadRequest(w, "'from' must be before 'to'")
+	case services.ErrOutsideRetention:
+		responses.SendBadRequest(w, "Hourly traffic is not retained for this range (TRAFFIC_HOURLY_RETENTION); use granularity=day or a later 'from'")
+	default:
+		responses.SendInternalError(w, "Failed to query traffic")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+	"vpn-service/database"
 	"vpn-service/responses"
 	"vpn-service/services"
 
@@ -25,16 +26,41 @@ func NewUserController(userService *services.UserService) *UserController {
 
 // CreateUserRequest представляет запрос на создание пользователя
 type CreateUserRequest struct {
-	Username     string    `json:"username"`
-	TrafficLimit int64     `json:"traffic_limit,omitempty"`
-	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	Username         string    `json:"username"`
+	TrafficLimit     int64     `json:"traffic_limit,omitempty"`
+	TrafficLimitMode string    `json:"traffic_limit_mode,omitempty"`
+	ExpiresAt        time.Time `json:"expires_at,omitempty"`
+	QuotaPeriod      string    `json:"quota_period,omitempty"`
+	QuotaAnchor      time.Time `json:"quota_anchor,omitempty"`
+	QuotaRollover    bool      `json:"quota_rollover,omitempty"`
+	PlanID           *uint     `json:"plan_id,omitempty"`
+	// Protocols - флаги протоколов, например {"vless": true, "trojan": false}
+	Protocols map[string]bool `json:"protocols,omitempty"`
+	// Flow - none или xtls-rprx-vision, пусто = по умолчанию для транспорта
+	Flow string `json:"flow,omitempty"`
+	// MaxDevices - одновременных IP, 0 = как в плане (без плана - без ограничения)
+	MaxDevices int `json:"max_devices,omitempty"`
+	// SpeedLimitUp/SpeedLimitDown - байт/с, 0 = как в плане (без плана - без ограничения)
+	SpeedLimitUp   int64 `json:"speed_limit_up,omitempty"`
+	SpeedLimitDown int64 `json:"speed_limit_down,omitempty"`
 }
 
 // UpdateUserRequest представляет запрос на обновление пользователя
 type UpdateUserRequest struct {
-	TrafficLimit *int64     `json:"traffic_limit,omitempty"`
-	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
-	IsActive     *bool      `json:"is_active,omitempty"`
+	TrafficLimit     *int64          `json:"traffic_limit,omitempty"`
+	TrafficLimitMode *string         `json:"traffic_limit_mode,omitempty"`
+	ExpiresAt        *time.Time      `json:"expires_at,omitempty"`
+	IsActive         *bool           `json:"is_active,omitempty"`
+	QuotaPeriod      *string         `json:"quota_period,omitempty"`
+	QuotaAnchor      *time.Time      `json:"quota_anchor,omitempty"`
+	QuotaRollover    *bool           `json:"quota_rollover,omitempty"`
+	PlanID           *uint           `json:"plan_id,omitempty"`
+	RenewPlan        bool            `json:"renew_plan,omitempty"`
+	Protocols        map[string]bool `json:"protocols,omitempty"`
+	Flow             *string         `json:"flow,omitempty"`
+	MaxDevices       *int            `json:"max_devices,omitempty"`
+	SpeedLimitUp     *int64          `json:"speed_limit_up,omitempty"`
+	SpeedLimitDown   *int64          `json:"speed_limit_down,omitempty"`
 }
 
 // CreateUser создает нового пользователя
@@ -46,9 +72,19 @@ func (c *UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dto := services.CreateUserDTO{
-		Username:     req.Username,
-		TrafficLimit: req.TrafficLimit,
-		ExpiresAt:    req.ExpiresAt,
+		Username:         req.Username,
+		TrafficLimit:     req.TrafficLimit,
+		TrafficLimitMode: req.TrafficLimitMode,
+		ExpiresAt:        req.ExpiresAt,
+		QuotaPeriod:      req.QuotaPeriod,
+		QuotaAnchor:      req.QuotaAnchor,
+		QuotaRollover:    req.QuotaRollover,
+		PlanID:           req.PlanID,
+		Protocols:        req.Protocols,
+		Flow:             req.Flow,
+		MaxDevices:       req.MaxDevices,
+		SpeedLimitUp:     req.SpeedLimitUp,
+		SpeedLimitDown:   req.SpeedLimitDown,
 	}
 
 	user, err := c.userService.CreateUser(dto)
@@ -58,13 +94,27 @@ func (c *UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
 			responses.SendBadRequest(w, "Username is required")
 		case services.ErrUsernameExists:
 			responses.SendBadRequest(w, "Username already exists")
+		case services.ErrInvalidLimitMode:
+			responses.SendBadRequest(w, "Invalid traffic limit mode (expected both, download or upload)")
+		case services.ErrInvalidQuotaPeriod:
+			responses.SendBadRequest(w, "Invalid quota period (expected daily, weekly or monthly)")
+		case services.ErrPlanNotFound:
+			responses.SendBadRequest(w, "Plan not found")
+		case services.ErrInvalidProtocol:
+			responses.SendBadRequest(w, "Unknown protocol (expected vless, shadowsocks, trojan or vmess)")
+		case services.ErrInvalidFlow:
+			responses.SendBadRequest(w, "Invalid flow (expected none or xtls-rprx-vision; Vision requires tcp transport)")
+		case services.ErrInvalidMaxDevices:
+			responses.SendBadRequest(w, "max_devices must not be negative")
+		case services.ErrInvalidSpeedLimit:
+			responses.SendBadRequest(w, "speed_limit_up and speed_limit_down must not be negative")
 		default:
 			responses.SendInternalError(w, "Failed to create user")
 		}
 		return
 	}
 
-	responses.SendCreated(w, user)
+	responses.SendCreated(w, userView(r, user))
 }
 
 // ListUsers возвращает список пользователей
@@ -78,7 +128,11 @@ func (c *UserController) ListUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responses.SendSuccess(w, users)
+	views := make([]*database.User, 0, len(users))
+	for _, user := range users {
+		views = append(views, userView(r, user))
+	}
+	responses.SendSuccess(w, views)
 }
 
 // GetUser возвращает пользователя по ID
@@ -102,7 +156,7 @@ func (c *UserController) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responses.SendSuccess(w, user)
+	responses.SendSuccess(w, userView(r, user))
 }
 
 // UpdateUser обновляет данные пользователя
@@ -123,9 +177,20 @@ func (c *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dto := services.UpdateUserDTO{
-		TrafficLimit: req.TrafficLimit,
-		ExpiresAt:    req.ExpiresAt,
-		IsActive:     req.IsActive,
+		TrafficLimit:     req.TrafficLimit,
+		TrafficLimitMode: req.TrafficLimitMode,
+		ExpiresAt:        req.ExpiresAt,
+		IsActive:         req.IsActive,
+		QuotaPeriod:      req.QuotaPeriod,
+		QuotaAnchor:      req.QuotaAnchor,
+		QuotaRollover:    req.QuotaRollover,
+		PlanID:           req.PlanID,
+		RenewPlan:        req.RenewPlan,
+		Protocols:        req.Protocols,
+		Flow:             req.Flow,
+		MaxDevices:       req.MaxDevices,
+		SpeedLimitUp:     req.SpeedLimitUp,
+		SpeedLimitDown:   req.SpeedLimitDown,
 	}
 
 	user, err := c.userService.UpdateUser(uint(id), dto)
@@ -133,13 +198,31 @@ func (c *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		switch err {
 		case services.ErrUserNotFound:
 			responses.SendNotFound(w, "User not found")
+		case services.ErrInvalidLimitMode:
+			responses.SendBadRequest(w, "Invalid traffic limit mode (expected both, download or upload)")
+		case services.ErrInvalidQuotaPeriod:
+			responses.SendBadRequest(w, "Invalid quota period (expected daily, weekly or monthly)")
+		case services.ErrPlanNotFound:
+			responses.SendBadRequest(w, "Plan not found")
+		case services.ErrNoPlan:
+			responses.SendBadRequest(w, "User has no plan to renew")
+		case services.ErrPlanConflict:
+			responses.SendBadRequest(w, "plan_id and renew_plan cannot be used together")
+		case services.ErrInvalidProtocol:
+			responses.SendBadRequest(w, "Unknown protocol (expected vless, shadowsocks, trojan or vmess)")
+		case services.ErrInvalidFlow:
+			responses.SendBadRequest(w, "Invalid flow (expected none or xtls-rprx-vision; Vision requires tcp transport)")
+		case services.ErrInvalidMaxDevices:
+			responses.SendBadRequest(w, "max_devices must not be negative")
+		case services.ErrInvalidSpeedLimit:
+			responses.SendBadRequest(w, "speed_limit_up and speed_limit_down must not be negative")
 		default:
 			responses.SendInternalError(w, "Failed to update user")
 		}
 		return
 	}
 
-	responses.SendSuccess(w, user)
+	responses.SendSuccess(w, userView(r, user))
 }
 
 // DeleteUser удаляет пользователя
@@ -167,7 +250,8 @@ func (c *UserController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetUserConfig возвращает конфигурацию для подключения пользователя
+// GetUserConfig возвращает конфигурацию для подключения пользователя.
+// ?format=clash отдает YAML профиль Clash/Mihomo, ?format=singbox - профиль sing-box.
 func (c *UserController) GetUserConfig(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
@@ -178,6 +262,19 @@ func (c *UserController) GetUserConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "clash":
+		c.sendClashConfig(w, uint(id))
+		return
+	case "singbox", "sing-box":
+		c.sendSingBoxConfig(w, uint(id))
+		return
+	default:
+		responses.SendBadRequest(w, "Invalid format (expected json, clash or singbox)")
+		return
+	}
+
 	config, err := c.userService.GetUserConfig(uint(id))
 	if err != nil {
 		if err == services.ErrUserNotFound {
@@ -191,6 +288,36 @@ func (c *UserController) GetUserConfig(w http.ResponseWriter, r *http.Request) {
 	responses.SendSuccess(w, config)
 }
 
+// sendClashConfig отправляет профиль Clash/Mihomo в YAML
+func (c *UserController) sendClashConfig(w http.ResponseWriter, id uint) {
+	profile, err := c.userService.GetUserClashConfig(id)
+	if err != nil {
+		if err == services.ErrUserNotFound {
+			responses.SendNotFound(w, "User not found")
+		} else {
+			responses.SendInternalError(w, "Failed to generate Clash config")
+		}
+		return
+	}
+
+	responses.SendText(w, http.StatusOK, "text/yaml; charset=utf-8", profile)
+}
+
+// sendSingBoxConfig отправляет профиль sing-box как есть, без обертки Response
+func (c *UserController) sendSingBoxConfig(w http.ResponseWriter, id uint) {
+	profile, err := c.userService.GetUserSingBoxConfig(id)
+	if err != nil {
+		if err == services.ErrUserNotFound {
+			responses.SendNotFound(w, "User not found")
+		} else {
+			responses.SendInternalError(w, "Failed to generate sing-box config")
+		}
+		return
+	}
+
+	responses.SendText(w, http.StatusOK, "application/json", profile)
+}
+
 // ResetTraffic сбрасывает счетчик трафика пользователя
 func (c *UserController) ResetTraffic(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -211,3 +338,72 @@ func (c *UserController) ResetTraffic(w http.ResponseWriter, r *http.Request) {
 		"message": "Traffic reset successfully",
 	})
 }
+
+// RotateSubscription выпускает новый токен подписки пользователя
+func (c *UserController) RotateSubscription(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	info, err := c.userService.RotateSubscriptionToken(uint(id))
+	if err != nil {
+		if err == services.ErrUserNotFound {
+			responses.SendNotFound(w, "User not found")
+		} else {
+			responses.SendInternalError(w, "Failed to rotate subscription token")
+		}
+		return
+	}
+
+	responses.SendSuccess(w, info)
+}
+
+// GetUserSessions возвращает IP адреса, с которых пользователь сейчас подключен
+func (c *UserController) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		responses.SendBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	sessions, err := c.userService.GetUserSessions(uint(id))
+	if err != nil {
+		if err == services.ErrUserNotFound {
+			responses.SendNotFound(w, "User not found")
+		} else {
+			responses.SendInternalError(w, "Failed to get user sessions")
+		}
+		return
+	}
+
+	responses.SendSuccess(w, sessions)
+}
+
+// userView скрывает UUID пользователя от вызывающего без config:read:
+// UUID - учетные данные VLESS и VMess, по нему можно подключиться
+func userView(r *http.Request, user *database.User) *database.User {
+	principal := services.PrincipalFromContext(r.Context())
+	if principal == nil || principal.HasScope(services.ScopeConfigRead) {
+		return user
+	}
+
+	view := *user
+	view.UUID = maskedUUID(user.UUID)
+	return &view
+}
+
+// maskedUUID оставляет от UUID первую группу, чтобы пользователя можно было узнать
+func maskedUUID(uuid string) string {
+	if len(uuid) < 8 {
+		return ""
+	}
+	return uuid[:8] + "-****-****-****-************"
+}
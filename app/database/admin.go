@@ -0,0 +1,190 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Admin - администратор панели, входит по логину и паролю (POST /api/auth/login)
+type Admin struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"uniqueIndex;not null" json:"username"`
+	PasswordHash string     `gorm:"not null" json:"-"`         // bcrypt
+	Role         string     `gorm:"default:admin" json:"role"` // набор scopes, см. services.Roles
+	Enabled      bool       `json:"enabled"`                   // без default в БД: gorm не записывает false в колонку с default:true
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// AdminSession - сессия администратора. Клиент хранит refresh токен, в БД - только его хэш.
+// Отозванная сессия не принимает ни access, ни refresh токены.
+type AdminSession struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	AdminID     uint       `gorm:"index;not null" json:"admin_id"`
+	RefreshHash string     `gorm:"uniqueIndex;not null" json:"-"` // sha256 refresh токена
+	ExpiresAt   time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Active проверяет, действует ли сессия на момент now
+func (s *AdminSession) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// CreateAdmin создает администратора
+func (r *Repository) CreateAdmin(admin *Admin) error {
+	if err := r.db.Create(admin).Error; err != nil {
+		return fmt.Errorf("failed to create admin: %w", err)
+	}
+	return nil
+}
+
+// GetAdminByID возвращает администратора по ID
+func (r *Repository) GetAdminByID(id uint) (*Admin, error) {
+	var admin Admin
+	if err := r.db.First(&admin, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("admin not found")
+		}
+		return nil, fmt.Errorf("failed to get admin: %w", err)
+	}
+	return &admin, nil
+}
+
+// GetAdminByUsername возвращает администратора по имени
+func (r *Repository) GetAdminByUsername(username string) (*Admin, error) {
+	var admin Admin
+	if err := r.db.Where("username = ?", username).First(&admin).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("admin not found")
+		}
+		return nil, fmt.Errorf("failed to get admin: %w", err)
+	}
+	return &admin, nil
+}
+
+// ListAdmins возвращает список администраторов
+func (r *Repository) ListAdmins() ([]*Admin, error) {
+	var admins []*Admin
+	if err := r.db.Order("id ASC").Find(&admins).Error; err != nil {
+		return nil, fmt.Errorf("failed to list admins: %w", err)
+	}
+	return admins, nil
+}
+
+// CountAdmins возвращает количество администраторов
+func (r *Repository) CountAdmins() (int64, error) {
+	var count int64
+	if err := r.db.Model(&Admin{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count admins: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateAdmin обновляет администратора
+func (r *Repository) UpdateAdmin(admin *Admin) error {
+	if err := r.db.Save(admin).Error; err != nil {
+		return fmt.Errorf("failed to update admin: %w", err)
+	}
+	return nil
+}
+
+// DeleteAdmin удаляет администратора вместе с его сессиями
+func (r *Repository) DeleteAdmin(id uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("admin_id = ?", id).Delete(&AdminSession{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&Admin{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Errorf("admin not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete admin: %w", err)
+	}
+	return nil
+}
+
+// CreateAdminSession сохраняет новую сессию
+func (r *Repository) CreateAdminSession(session *AdminSession) error {
+	if err := r.db.Create(session).Error; err != nil {
+		return fmt.Errorf("failed to create admin session: %w", err)
+	}
+	return nil
+}
+
+// GetAdminSession возвращает сессию по ID
+func (r *Repository) GetAdminSession(id uint) (*AdminSession, error) {
+	var session AdminSession
+	if err := r.db.First(&session, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("admin session not found")
+		}
+		return nil, fmt.Errorf("failed to get admin session: %w", err)
+	}
+	return &session, nil
+}
+
+// GetAdminSessionByRefreshHash возвращает сессию по хэшу refresh токена
+func (r *Repository) GetAdminSessionByRefreshHash(hash string) (*AdminSession, error) {
+	var session AdminSession
+	if err := r.db.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("admin session not found")
+		}
+		return nil, fmt.Errorf("failed to get admin session: %w", err)
+	}
+	return &session, nil
+}
+
+// RotateAdminSessionRefresh заменяет refresh токен действующей сессии. false - сессия
+// уже отозвана или токен заменен параллельным запросом.
+func (r *Repository) RotateAdminSessionRefresh(id uint, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&AdminSession{}).
+		Where("id = ? AND refresh_hash = ? AND revoked_at IS NULL", id, oldHash).
+		Updates(map[string]interface{}{"refresh_hash": newHash, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to refresh admin session: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RevokeAdminSession отзывает сессию
+func (r *Repository) RevokeAdminSession(id uint, now time.Time) error {
+	if err := r.db.Model(&AdminSession{}).Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now).Error; err != nil {
+		return fmt.Errorf("failed to revoke admin session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAdminSessions отзывает все сессии администратора, возвращает их количество
+func (r *Repository) RevokeAdminSessions(adminID uint, now time.Time) (int64, error) {
+	result := r.db.Model(&AdminSession{}).Where("admin_id = ? AND revoked_at IS NULL", adminID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke admin sessions: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// DeleteExpiredAdminSessions удаляет сессии, истекшие к now
+func (r *Repository) DeleteExpiredAdminSessions(now time.Time) error {
+	if err := r.db.Where("expires_at <= ?", now).Delete(&AdminSession{}).Error; err != nil {
+		return fmt.Errorf("failed to delete expired admin sessions: %w", err)
+	}
+	return nil
+}
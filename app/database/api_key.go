@@ -0,0 +1,123 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey - ключ API для сервисов (например, бота биллинга). В БД хранится только хэш ключа;
+// права - scopes роли Role и дополнительные Scopes.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"uniqueIndex;not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"` // начало ключа, чтобы его можно было узнать
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"-"`
+	Role       string     `json:"role,omitempty"`
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	Enabled    bool       `gorm:"default:true" json:"enabled"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // nil = бессрочно
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Usable проверяет, принимается ли ключ на момент now
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.Enabled && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKey создает ключ API
+func (r *Repository) CreateAPIKey(key *APIKey) error {
+	if err := r.db.Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByID возвращает ключ API по ID
+func (r *Repository) GetAPIKeyByID(id uint) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.First(&key, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("api key not found")
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByName возвращает ключ API по имени
+func (r *Repository) GetAPIKeyByName(name string) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("name = ?", name).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("api key not found")
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByHash возвращает ключ API по хэшу
+func (r *Repository) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("api key not found")
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return &key, nil
+}
+
+// ListAPIKeys возвращает список ключей API
+func (r *Repository) ListAPIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := r.db.Order("id ASC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// CountAPIKeys возвращает количество ключей API
+func (r *Repository) CountAPIKeys() (int64, error) {
+	var count int64
+	if err := r.db.Model(&APIKey{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count api keys: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateAPIKey обновляет ключ API
+func (r *Repository) UpdateAPIKey(key *APIKey) error {
+	if err := r.db.Save(key).Error; err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+	return nil
+}
+
+// TouchAPIKey записывает время использования ключа
+func (r *Repository) TouchAPIKey(id uint, usedAt time.Time) error {
+	if err := r.db.Model(&APIKey{}).Where("id = ?", id).
+		UpdateColumn("last_used_at", usedAt).Error; err != nil {
+		return fmt.Errorf("failed to record api key use: %w", err)
+	}
+	return nil
+}
+
+// DeleteAPIKey удаляет ключ API
+func (r *Repository) DeleteAPIKey(id uint) error {
+	result := r.db.Delete(&APIKey{}, id)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete api key: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("api key not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"vpn-service/utils"
+
+	"gorm.io/gorm"
+)
+
+// migrate применяет автомиграцию схемы и переносит данные старых версий
+func migrate(db *gorm.DB) error {
+	migrator := db.Migrator()
+
+	// Запоминаем состояние схемы до автомиграции
+	hadUsers := migrator.HasTable(&User{})
+	hadTrafficDirections := hadUsers && migrator.HasColumn(&User{}, "TrafficDown")
+	hadFlow := hadUsers && migrator.HasColumn(&User{}, "Flow")
+	// Новые флаги протоколов включаются существующим пользователям
+	newProtocolFlags := make([]string, 0)
+	for field, column := range protocolFlagColumns {
+		if hadUsers && !migrator.HasColumn(&User{}, field) {
+			newProtocolFlags = append(newProtocolFlags, column)
+		}
+	}
+
+	if err := db.AutoMigrate(&User{}, &TrafficSample{}, &TrafficPeriod{}, &Plan{}, &Node{}, &RealityKey{}, &Admin{}, &AdminSession{}, &APIKey{}, &Setting{}); err != nil {
+		return err
+	}
+
+	if hadUsers && !hadTrafficDirections {
+		if err := migrateTrafficDirections(db); err != nil {
+			return err
+		}
+	}
+
+	if hadUsers && !hadFlow {
+		if err := migrateUserFlow(db); err != nil {
+			return err
+		}
+	}
+
+	if len(newProtocolFlags) > 0 {
+		if err := migrateProtocolFlags(db, newProtocolFlags); err != nil {
+			return err
+		}
+	}
+
+	return backfillUserSecrets(db)
+}
+
+// migrateUserFlow оставляет существующим пользователям VLESS без flow:
+// их клиенты настроены без Vision, и сервер с Vision их бы отклонил.
+// Новые пользователи получают Vision по умолчанию.
+func migrateUserFlow(db *gorm.DB) error {
+	result := db.Model(&User{}).Where("1 = 1").UpdateColumn("flow", FlowNone)
+	if result.Error != nil {
+		return fmt.Errorf("failed to migrate user flow: %w", result.Error)
+	}
+
+	log.Printf("Kept flow disabled for %d existing users", result.RowsAffected)
+	return nil
+}
+
+// protocolFlagColumns - поля флагов протоколов пользователя и их колонки
+var protocolFlagColumns = map[string]string{
+	"VlessEnabled":       "vless_enabled",
+	"ShadowsocksEnabled": "shadowsocks_enabled",
+	"TrojanEnabled":      "trojan_enabled",
+	"VmessEnabled":       "vmess_enabled",
+}
+
+// migrateProtocolFlags включает добавленные протоколы существующим пользователям,
+// как было до появления флагов
+func migrateProtocolFlags(db *gorm.DB, columns []string) error {
+	updates := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		updates[column] = true
+	}
+
+	result := db.Model(&User{}).Where("1 = 1").UpdateColumns(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to migrate protocol flags: %w", result.Error)
+	}
+
+	log.Printf("Enabled protocols %v for %d existing users", columns, result.RowsAffected)
+	return nil
+}
+
+// backfillUserSecrets генерирует секреты Shadowsocks, пароли Trojan, токены подписки
+// и shortId Reality пользователям, созданным до их появления
+func backfillUserSecrets(db *gorm.DB) error {
+	backfills := []struct {
+		column string
+		size   int
+	}{
+		{"secret", UserSecretBytes},
+		{"trojan_password", TrojanPasswordBytes},
+		{"sub_token", SubTokenBytes},
+		{"short_id", ShortIDBytes},
+	}
+
+	for _, backfill := range backfills {
+		var users []User
+		if err := db.Where(backfill.column+" = ? OR "+backfill.column+" IS NULL", "").Find(&users).Error; err != nil {
+			return fmt.Errorf("failed to load users without %s: %w", backfill.column, err)
+		}
+
+		for _, user := range users {
+			value, err := utils.GenerateSecret(backfill.size)
+			if err != nil {
+				return fmt.Errorf("failed to generate %s: %w", backfill.column, err)
+			}
+			if err := db.Model(&User{}).Where("id = ?", user.ID).
+				UpdateColumn(backfill.column, value).Error; err != nil {
+				return fmt.Errorf("failed to backfill %s for user %s: %w", backfill.column, user.Username, err)
+			}
+		}
+
+		if len(users) > 0 {
+			log.Printf("Generated %s for %d users", backfill.column, len(users))
+		}
+	}
+
+	return nil
+}
+
+// migrateTrafficDirections переносит накопленный traffic_used в раздельные счетчики.
+// Исторического разделения по направлениям нет, поэтому весь объем
+// относим к download - он доминирует в VPN трафике.
+func migrateTrafficDirections(db *gorm.DB) error {
+	result := db.Model(&User{}).
+		Where("traffic_used > 0").
+		UpdateColumn("traffic_down", gorm.Expr("traffic_used"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to migrate traffic counters: %w", result.Error)
+	}
+
+	log.Printf("Migrated traffic counters for %d users", result.RowsAffected)
+	return nil
+}
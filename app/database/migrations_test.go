@@ -0,0 +1,101 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// legacyUser - пользователь первой версии схемы
+type legacyUser struct {
+	ID           uint   `gorm:"primaryKey"`
+	Username     string `gorm:"uniqueIndex;not null"`
+	UUID         string `gorm:"uniqueIndex;not null"`
+	Secret       string
+	IsActive     bool `gorm:"default:true"`
+	ExpiresAt    time.Time
+	TrafficLimit int64 `gorm:"default:0"`
+	TrafficUsed  int64 `gorm:"default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (legacyUser) TableName() string {
+	return "users"
+}
+
+func TestMigrateTrafficDirections(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "legacy.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// Схема до раздельных счетчиков: только traffic_used
+	if err := db.AutoMigrate(&legacyUser{}); err != nil {
+		t.Fatalf("failed to prepare legacy schema: %v", err)
+	}
+	legacy := []legacyUser{
+		{Username: "alice", UUID: "uuid-alice", IsActive: true, TrafficUsed: 500},
+		{Username: "bob", UUID: "uuid-bob", IsActive: true},
+	}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatalf("failed to create legacy users: %v", err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate() error = %v", err)
+	}
+
+	tests := []struct {
+		username string
+		wantUp   int64
+		wantDown int64
+		wantUsed int64
+	}{
+		{username: "alice", wantDown: 500, wantUsed: 500},
+		{username: "bob"},
+	}
+
+	repo := NewRepository(db)
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			user, err := repo.GetUserByUsername(tt.username)
+			if err != nil {
+				t.Fatalf("GetUserByUsername() error = %v", err)
+			}
+			if user.TrafficUp != tt.wantUp || user.TrafficDown != tt.wantDown || user.TrafficUsed != tt.wantUsed {
+				t.Errorf("traffic up/down/used = %d/%d/%d, want %d/%d/%d",
+					user.TrafficUp, user.TrafficDown, user.TrafficUsed, tt.wantUp, tt.wantDown, tt.wantUsed)
+			}
+			if user.TrafficLimitMode != LimitModeBoth {
+				t.Errorf("traffic_limit_mode = %q, want %q", user.TrafficLimitMode, LimitModeBoth)
+			}
+		})
+	}
+
+	// Повторная миграция не переносит счетчики второй раз
+	if err := db.Exec("UPDATE users SET traffic_used = 800 WHERE username = 'alice'").Error; err != nil {
+		t.Fatalf("failed to update traffic: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatalf("second migrate() error = %v", err)
+	}
+	user, err := repo.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatalf("GetUserByUsername() error = %v", err)
+	}
+	if user.TrafficDown != 500 {
+		t.Errorf("traffic_down after second migrate = %d, want 500", user.TrafficDown)
+	}
+}
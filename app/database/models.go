@@ -4,18 +4,119 @@ import (
 	"time"
 )
 
+// Режимы учета трафика для лимита
+const (
+	LimitModeBoth     = "both"     // upload + download
+	LimitModeDownload = "download" // только download
+	LimitModeUpload   = "upload"   // только upload
+)
+
+// Flow VLESS пользователя
+const (
+	FlowAuto   = ""                 // xtls-rprx-vision для TCP, иначе без flow
+	FlowNone   = "none"             // без flow
+	FlowVision = "xtls-rprx-vision" // XTLS Vision
+)
+
+// UserSecretBytes - длина секрета пользователя, хватает для любого метода Shadowsocks 2022
+const UserSecretBytes = 32
+
+// SubTokenBytes - длина токена подписки (в hex вдвое длиннее)
+const SubTokenBytes = 16
+
+// TrojanPasswordBytes - длина случайной части пароля Trojan (в hex вдвое длиннее)
+const TrojanPasswordBytes = 16
+
+// ShortIDBytes - длина shortId Reality пользователя (в hex вдвое длиннее, максимум Reality - 8 байт)
+const ShortIDBytes = 8
+
 // User представляет VPN пользователя
 type User struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
-	UUID         string    `gorm:"uniqueIndex;not null" json:"uuid"`
-	Secret       string    `json:"secret"` // для будущего Shadowsocks
-	IsActive     bool      `gorm:"default:true" json:"is_active"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	TrafficLimit int64     `gorm:"default:0" json:"traffic_limit"` // 0 = unlimited
-	TrafficUsed  int64     `gorm:"default:0" json:"traffic_used"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	Username         string    `gorm:"uniqueIndex;not null" json:"username"`
+	UUID             string    `gorm:"uniqueIndex;not null" json:"uuid"`
+	Secret           string    `json:"-"` // hex, источник ключа Shadowsocks 2022
+	TrojanPassword   string    `json:"-"`
+	SubToken         string    `gorm:"uniqueIndex" json:"-"` // токен публичной подписки /sub/{token}
+	ShortID          string    `json:"short_id"`             // shortId Reality в клиентских конфигурациях
+	IsActive         bool      `gorm:"default:true" json:"is_active"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	TrafficLimit     int64     `gorm:"default:0" json:"traffic_limit"` // 0 = unlimited
+	TrafficLimitMode string    `gorm:"default:both" json:"traffic_limit_mode"`
+	TrafficUsed      int64     `gorm:"default:0" json:"traffic_used"` // upload + download
+	TrafficUp        int64     `gorm:"default:0" json:"traffic_up"`
+	TrafficDown      int64     `gorm:"default:0" json:"traffic_down"`
+	PlanID           *uint     `gorm:"index" json:"plan_id"`
+	Flow             string    `json:"flow"`                              // "", none, xtls-rprx-vision (см. xray.EffectiveFlow)
+	MaxDevices       int       `gorm:"default:0" json:"max_devices"`      // одновременных IP, 0 = unlimited
+	SpeedLimitUp     int64     `gorm:"default:0" json:"speed_limit_up"`   // байт/с, 0 = unlimited
+	SpeedLimitDown   int64     `gorm:"default:0" json:"speed_limit_down"` // байт/с, 0 = unlimited
+
+	// Разрешенные протоколы (см. ProtocolEnabled). Без default в БД:
+	// gorm не записывает false в колонку с default:true
+	VlessEnabled       bool `json:"vless_enabled"`
+	ShadowsocksEnabled bool `json:"shadowsocks_enabled"`
+	TrojanEnabled      bool `json:"trojan_enabled"`
+	VmessEnabled       bool `json:"vmess_enabled"`
+
+	// Периодический сброс квоты (см. quota.go)
+	QuotaPeriod       string    `json:"quota_period,omitempty"` // "", daily, weekly, monthly
+	QuotaAnchor       time.Time `json:"quota_anchor"`
+	QuotaRollover     bool      `gorm:"default:false" json:"quota_rollover"`
+	RolloverBytes     int64     `gorm:"default:0" json:"rollover_bytes"`
+	PeriodStart       time.Time `json:"period_start"`
+	DeactivatedReason string    `json:"deactivated_reason,omitempty"`
+	SuspendedUntil    time.Time `json:"suspended_until"` // временное отключение за превышение MaxDevices
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsValidLimitMode проверяет режим учета трафика
+func IsValidLimitMode(mode string) bool {
+	switch mode {
+	case LimitModeBoth, LimitModeDownload, LimitModeUpload:
+		return true
+	}
+	return false
+}
+
+// IsValidFlow проверяет значение flow
+func IsValidFlow(flow string) bool {
+	switch flow {
+	case FlowAuto, FlowNone, FlowVision:
+		return true
+	}
+	return false
+}
+
+// ProtocolEnabled проверяет, разрешен ли пользователю протокол
+func (u *User) ProtocolEnabled(protocol string) bool {
+	switch protocol {
+	case ProtocolVLESS:
+		return u.VlessEnabled
+	case ProtocolShadowsocks:
+		return u.ShadowsocksEnabled
+	case ProtocolTrojan:
+		return u.TrojanEnabled
+	case ProtocolVMess:
+		return u.VmessEnabled
+	}
+	return false
+}
+
+// SetProtocolEnabled включает или выключает протокол для пользователя
+func (u *User) SetProtocolEnabled(protocol string, enabled bool) {
+	switch protocol {
+	case ProtocolVLESS:
+		u.VlessEnabled = enabled
+	case ProtocolShadowsocks:
+		u.ShadowsocksEnabled = enabled
+	case ProtocolTrojan:
+		u.TrojanEnabled = enabled
+	case ProtocolVMess:
+		u.VmessEnabled = enabled
+	}
 }
 
 // IsExpired проверяет, истек ли срок действия пользователя
@@ -23,14 +124,39 @@ func (u *User) IsExpired() bool {
 	return !u.ExpiresAt.IsZero() && time.Now().After(u.ExpiresAt)
 }
 
+// CountedTraffic возвращает трафик, который учитывается в лимите
+func (u *User) CountedTraffic() int64 {
+	switch u.TrafficLimitMode {
+	case LimitModeDownload:
+		return u.TrafficDown
+	case LimitModeUpload:
+		return u.TrafficUp
+	default:
+		return u.TrafficUsed
+	}
+}
+
+// EffectiveTrafficLimit возвращает лимит текущего периода с учетом перенесенного остатка
+func (u *User) EffectiveTrafficLimit() int64 {
+	if u.TrafficLimit == 0 {
+		return 0
+	}
+	return u.TrafficLimit + u.RolloverBytes
+}
+
 // IsOverLimit проверяет, превышен ли лимит трафика
 func (u *User) IsOverLimit() bool {
-	return u.TrafficLimit > 0 && u.TrafficUsed >= u.TrafficLimit
+	return u.TrafficLimit > 0 && u.CountedTraffic() >= u.EffectiveTrafficLimit()
+}
+
+// IsSuspended проверяет, отключен ли пользователь временно
+func (u *User) IsSuspended() bool {
+	return !u.SuspendedUntil.IsZero() && time.Now().Before(u.SuspendedUntil)
 }
 
 // CanConnect проверяет, может ли пользователь подключиться
 func (u *User) CanConnect() bool {
-	return u.IsActive && !u.IsExpired() && !u.IsOverLimit()
+	return u.IsActive && !u.IsExpired() && !u.IsOverLimit() && !u.IsSuspended()
 }
 
 // RemainingTraffic возвращает остаток трафика в байтах
@@ -38,9 +164,25 @@ func (u *User) RemainingTraffic() int64 {
 	if u.TrafficLimit == 0 {
 		return -1 // unlimited
 	}
-	remaining := u.TrafficLimit - u.TrafficUsed
+	remaining := u.EffectiveTrafficLimit() - u.CountedTraffic()
 	if remaining < 0 {
 		return 0
 	}
 	return remaining
 }
+
+// Гранулярность бакетов истории трафика
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// TrafficSample хранит трафик пользователя за один бакет (час или сутки, UTC)
+type TrafficSample struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_traffic_sample_bucket,priority:1" json:"user_id"`
+	Granularity string    `gorm:"not null;uniqueIndex:idx_traffic_sample_bucket,priority:2" json:"granularity"`
+	BucketStart time.Time `gorm:"not null;uniqueIndex:idx_traffic_sample_bucket,priority:3;index" json:"bucket_start"`
+	Upload      int64     `gorm:"default:0" json:"upload"`
+	Download    int64     `gorm:"default:0" json:"download"`
+}
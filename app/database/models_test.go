@@ -0,0 +1,107 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountedTraffic(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want int64
+	}{
+		{name: "both", mode: LimitModeBoth, want: 300},
+		{name: "download", mode: LimitModeDownload, want: 200},
+		{name: "upload", mode: LimitModeUpload, want: 100},
+		{name: "empty mode counts both", mode: "", want: 300},
+		{name: "unknown mode counts both", mode: "sideways", want: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{TrafficLimitMode: tt.mode, TrafficUp: 100, TrafficDown: 200, TrafficUsed: 300}
+			if got := user.CountedTraffic(); got != tt.want {
+				t.Errorf("CountedTraffic() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOverLimitByMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		limit    int64
+		rollover int64
+		want     bool
+	}{
+		{name: "both over limit", mode: LimitModeBoth, limit: 300, want: true},
+		{name: "download under limit", mode: LimitModeDownload, limit: 300},
+		{name: "upload over limit", mode: LimitModeUpload, limit: 100, want: true},
+		{name: "rollover extends limit", mode: LimitModeBoth, limit: 250, rollover: 100},
+		{name: "unlimited", mode: LimitModeBoth, limit: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{
+				TrafficLimitMode: tt.mode,
+				TrafficLimit:     tt.limit,
+				RolloverBytes:    tt.rollover,
+				TrafficUp:        100,
+				TrafficDown:      200,
+				TrafficUsed:      300,
+			}
+			if got := user.IsOverLimit(); got != tt.want {
+				t.Errorf("IsOverLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// countedTrafficSQL должен считать так же, как User.CountedTraffic
+func TestCountedTrafficSQL(t *testing.T) {
+	repo := newTestRepository(t)
+
+	modes := []string{LimitModeBoth, LimitModeDownload, LimitModeUpload}
+	users := make([]*User, 0, len(modes))
+	for i, mode := range modes {
+		user := &User{
+			Username:         "user-" + mode,
+			UUID:             fmt.Sprintf("uuid-%d", i),
+			SubToken:         fmt.Sprintf("token-%d", i),
+			TrafficLimitMode: mode,
+			TrafficLimit:     150,
+			TrafficUp:        100,
+			TrafficDown:      200,
+			TrafficUsed:      300,
+		}
+		if err := repo.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+		users = append(users, user)
+	}
+
+	for _, user := range users {
+		t.Run(user.TrafficLimitMode, func(t *testing.T) {
+			var counted int64
+			if err := repo.db.Model(&User{}).Select(countedTrafficSQL).
+				Where("id = ?", user.ID).Scan(&counted).Error; err != nil {
+				t.Fatalf("query error = %v", err)
+			}
+			if counted != user.CountedTraffic() {
+				t.Errorf("countedTrafficSQL = %d, CountedTraffic() = %d", counted, user.CountedTraffic())
+			}
+		})
+	}
+
+	// Лимит 150: превышен в режимах both (300) и download (200), но не upload (100)
+	over, err := repo.CountUsersOverLimit()
+	if err != nil {
+		t.Fatalf("CountUsersOverLimit() error = %v", err)
+	}
+	if over != 2 {
+		t.Errorf("CountUsersOverLimit() = %d, want 2", over)
+	}
+}
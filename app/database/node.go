@@ -0,0 +1,112 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NodeTokenBytes - длина токена агента ноды в байтах
+const NodeTokenBytes = 32
+
+// Node представляет сервер кластера с Xray, которым управляет панель через агент
+type Node struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	Address   string    `gorm:"not null" json:"address"`          // адрес для клиентов (IP или домен)
+	AgentURL  string    `gorm:"not null" json:"agent_url"`        // http(s)://host:port агента
+	Token     string    `gorm:"not null" json:"-"`                // общий секрет панели и агента
+	Region    string    `json:"region"`                           // произвольная метка, например "eu-de"
+	Capacity  int       `gorm:"default:0" json:"capacity"`        // максимум пользователей, 0 = без ограничений
+	Protocols []string  `gorm:"serializer:json" json:"protocols"` // пусто = все протоколы панели
+	IsEnabled bool      `json:"is_enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AllowsProtocol проверяет, обслуживает ли нода протокол
+func (n *Node) AllowsProtocol(protocol string) bool {
+	if len(n.Protocols) == 0 {
+		return true
+	}
+	for _, allowed := range n.Protocols {
+		if allowed == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateNode регистрирует новую ноду
+func (r *Repository) CreateNode(node *Node) error {
+	if err := r.db.Create(node).Error; err != nil {
+		return fmt.Errorf("failed to create node: %w", err)
+	}
+	return nil
+}
+
+// GetNodeByID возвращает ноду по ID
+func (r *Repository) GetNodeByID(id uint) (*Node, error) {
+	var node Node
+	if err := r.db.First(&node, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("node not found")
+		}
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	return &node, nil
+}
+
+// GetNodeByName возвращает ноду по имени
+func (r *Repository) GetNodeByName(name string) (*Node, error) {
+	var node Node
+	if err := r.db.Where("name = ?", name).First(&node).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("node not found")
+		}
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	return &node, nil
+}
+
+// ListNodes возвращает список всех нод
+func (r *Repository) ListNodes() ([]*Node, error) {
+	var nodes []*Node
+	if err := r.db.Order("id ASC").Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// ListEnabledNodes возвращает включенные ноды
+func (r *Repository) ListEnabledNodes() ([]*Node, error) {
+	var nodes []*Node
+	if err := r.db.Where("is_enabled = ?", true).Order("id ASC").Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// UpdateNode обновляет ноду
+func (r *Repository) UpdateNode(node *Node) error {
+	if err := r.db.Save(node).Error; err != nil {
+		return fmt.Errorf("failed to update node: %w", err)
+	}
+	return nil
+}
+
+// DeleteNode удаляет ноду
+func (r *Repository) DeleteNode(id uint) error {
+	result := r.db.Delete(&Node{}, id)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete node: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("node not found")
+	}
+
+	return nil
+}
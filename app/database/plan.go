@@ -0,0 +1,134 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Протоколы, которые может разрешать тарифный план
+const (
+	ProtocolVLESS       = "vless"
+	ProtocolShadowsocks = "shadowsocks"
+	ProtocolTrojan      = "trojan"
+	ProtocolVMess       = "vmess"
+)
+
+// KnownProtocols - протоколы, поддерживаемые сервисом
+var KnownProtocols = []string{ProtocolVLESS, ProtocolShadowsocks, ProtocolTrojan, ProtocolVMess}
+
+// IsKnownProtocol проверяет, поддерживается ли протокол
+func IsKnownProtocol(protocol string) bool {
+	for _, known := range KnownProtocols {
+		if known == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan представляет тарифный план подписки
+type Plan struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"uniqueIndex;not null" json:"name"`
+	DurationDays int       `gorm:"default:0" json:"duration_days"`   // 0 = бессрочно
+	TrafficLimit int64     `gorm:"default:0" json:"traffic_limit"`   // 0 = unlimited
+	DeviceLimit  int       `gorm:"default:0" json:"device_limit"`    // 0 = unlimited
+	SpeedLimit   int64     `gorm:"default:0" json:"speed_limit"`     // байт/с в каждую сторону, 0 = unlimited
+	Protocols    []string  `gorm:"serializer:json" json:"protocols"` // пусто = все протоколы
+	Flow         string    `json:"flow"`                             // flow VLESS для пользователей плана
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Duration возвращает длительность плана
+func (p *Plan) Duration() time.Duration {
+	return time.Duration(p.DurationDays) * 24 * time.Hour
+}
+
+// AllowsProtocol проверяет, разрешен ли протокол планом
+func (p *Plan) AllowsProtocol(protocol string) bool {
+	if len(p.Protocols) == 0 {
+		return true
+	}
+	for _, allowed := range p.Protocols {
+		if allowed == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatePlan создает новый тарифный план
+func (r *Repository) CreatePlan(plan *Plan) error {
+	if err := r.db.Create(plan).Error; err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+	return nil
+}
+
+// GetPlanByID возвращает план по ID
+func (r *Repository) GetPlanByID(id uint) (*Plan, error) {
+	var plan Plan
+	if err := r.db.First(&plan, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("plan not found")
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	return &plan, nil
+}
+
+// GetPlanByName возвращает план по имени
+func (r *Repository) GetPlanByName(name string) (*Plan, error) {
+	var plan Plan
+	if err := r.db.Where("name = ?", name).First(&plan).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("plan not found")
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	return &plan, nil
+}
+
+// ListPlans возвращает список всех планов
+func (r *Repository) ListPlans() ([]*Plan, error) {
+	var plans []*Plan
+	if err := r.db.Order("id ASC").Find(&plans).Error; err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	return plans, nil
+}
+
+// UpdatePlan обновляет тарифный план
+func (r *Repository) UpdatePlan(plan *Plan) error {
+	if err := r.db.Save(plan).Error; err != nil {
+		return fmt.Errorf("failed to update plan: %w", err)
+	}
+	return nil
+}
+
+// DeletePlan удаляет тарифный план
+func (r *Repository) DeletePlan(id uint) error {
+	result := r.db.Delete(&Plan{}, id)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete plan: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("plan not found")
+	}
+
+	return nil
+}
+
+// CountUsersWithPlan возвращает количество пользователей на плане
+func (r *Repository) CountUsersWithPlan(planID uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&User{}).Where("plan_id = ?", planID).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count plan users: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Периоды сброса квоты
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodWeekly  = "weekly"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// Причины автоматической деактивации
+const (
+	DeactivatedOverLimit = "over_limit"
+	DeactivatedManual    = "manual"
+	DeactivatedDevices   = "device_limit" // превышен MaxDevices
+)
+
+// TrafficPeriod хранит итоги закрытого периода квоты
+type TrafficPeriod struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	PeriodStart time.Time `gorm:"not null" json:"period_start"`
+	PeriodEnd   time.Time `gorm:"not null" json:"period_end"`
+	Upload      int64     `json:"upload"`
+	Download    int64     `json:"download"`
+	Counted     int64     `json:"counted"` // трафик, учтенный в лимите
+	Limit       int64     `json:"limit"`   // лимит периода с учетом переноса, 0 = unlimited
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IsValidQuotaPeriod проверяет период сброса квоты ("" = без сброса)
+func IsValidQuotaPeriod(period string) bool {
+	switch period {
+	case "", QuotaPeriodDaily, QuotaPeriodWeekly, QuotaPeriodMonthly:
+		return true
+	}
+	return false
+}
+
+// CurrentPeriodStart возвращает начало периода квоты, в который попадает now.
+// Границы отсчитываются от QuotaAnchor (время суток, день недели или день месяца).
+func (u *User) CurrentPeriodStart(now time.Time) time.Time {
+	anchor := u.QuotaAnchor
+	if anchor.IsZero() {
+		anchor = u.CreatedAt
+	}
+	now = now.In(anchor.Location())
+
+	atAnchorTime := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, anchor.Hour(), anchor.Minute(), anchor.Second(), 0, anchor.Location())
+	}
+
+	switch u.QuotaPeriod {
+	case QuotaPeriodDaily:
+		start := atAnchorTime(now.Year(), now.Month(), now.Day())
+		if start.After(now) {
+			start = start.AddDate(0, 0, -1)
+		}
+		return start
+	case QuotaPeriodWeekly:
+		start := atAnchorTime(now.Year(), now.Month(), now.Day())
+		offset := (int(start.Weekday()) - int(anchor.Weekday()) + 7) % 7
+		start = start.AddDate(0, 0, -offset)
+		if start.After(now) {
+			start = start.AddDate(0, 0, -7)
+		}
+		return start
+	case QuotaPeriodMonthly:
+		start := atAnchorTime(now.Year(), now.Month(), clampDay(now.Year(), now.Month(), anchor.Day()))
+		if start.After(now) {
+			prev := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, anchor.Location()).AddDate(0, -1, 0)
+			start = atAnchorTime(prev.Year(), prev.Month(), clampDay(prev.Year(), prev.Month(), anchor.Day()))
+		}
+		return start
+	default:
+		return time.Time{}
+	}
+}
+
+// clampDay ограничивает день числом дней в месяце (31 -> 28/29/30)
+func clampDay(year int, month time.Month, day int) int {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		return last
+	}
+	return day
+}
+
+// QuotaRolloverDTO описывает закрытие периода квоты пользователя
+type QuotaRolloverDTO struct {
+	Period        *TrafficPeriod
+	NextStart     time.Time
+	RolloverBytes int64
+	Reactivate    bool
+}
+
+// CloseTrafficPeriod архивирует период и начинает новый.
+// Счетчики уменьшаются на архивированные значения, а не обнуляются,
+// чтобы не потерять трафик, записанный параллельно.
+func (r *Repository) CloseTrafficPeriod(userID uint, dto QuotaRolloverDTO) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if dto.Period != nil {
+			if err := tx.Create(dto.Period).Error; err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{
+			"period_start":   dto.NextStart,
+			"rollover_bytes": dto.RolloverBytes,
+		}
+		if dto.Period != nil {
+			updates["traffic_up"] = gorm.Expr("traffic_up - ?", dto.Period.Upload)
+			updates["traffic_down"] = gorm.Expr("traffic_down - ?", dto.Period.Download)
+			updates["traffic_used"] = gorm.Expr("traffic_used - ?", dto.Period.Upload+dto.Period.Download)
+		}
+		if dto.Reactivate {
+			updates["is_active"] = true
+			updates["deactivated_reason"] = ""
+		}
+
+		return tx.Model(&User{}).Where("id = ?", userID).UpdateColumns(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close traffic period: %w", err)
+	}
+	return nil
+}
+
+// ListTrafficPeriods возвращает архив периодов пользователя (новые первыми)
+func (r *Repository) ListTrafficPeriods(userID uint) ([]*TrafficPeriod, error) {
+	var periods []*TrafficPeriod
+	if err := r.db.Where("user_id = ?", userID).
+		Order("period_start DESC").
+		Find(&periods).Error; err != nil {
+		return nil, fmt.Errorf("failed to list traffic periods: %w", err)
+	}
+	return periods, nil
+}
+
+// ListUsersWithQuotaPeriod возвращает пользователей с периодическим сбросом квоты
+func (r *Repository) ListUsersWithQuotaPeriod() ([]*User, error) {
+	var users []*User
+	if err := r.db.Where("quota_period IS NOT NULL AND quota_period != ''").
+		Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to list users with quota period: %w", err)
+	}
+	return users, nil
+}
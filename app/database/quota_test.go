@@ -0,0 +1,118 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCurrentPeriodStart(t *testing.T) {
+	// Среда, 10 января 2024, 09:30 UTC
+	anchor := time.Date(2024, 1, 10, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		period string
+		anchor time.Time
+		now    time.Time
+		want   time.Time
+	}{
+		{
+			name:   "no period",
+			period: "",
+			anchor: anchor,
+			now:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			want:   time.Time{},
+		},
+		{
+			name:   "daily after anchor time",
+			period: QuotaPeriodDaily,
+			anchor: anchor,
+			now:    time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "daily before anchor time",
+			period: QuotaPeriodDaily,
+			anchor: anchor,
+			now:    time.Date(2024, 2, 1, 9, 29, 59, 0, time.UTC),
+			want:   time.Date(2024, 1, 31, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "daily exactly at anchor time",
+			period: QuotaPeriodDaily,
+			anchor: anchor,
+			now:    time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC),
+			want:   time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "weekly later in week",
+			period: QuotaPeriodWeekly,
+			anchor: anchor,
+			now:    time.Date(2024, 2, 3, 8, 0, 0, 0, time.UTC), // суббота
+			want:   time.Date(2024, 1, 31, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "weekly on anchor weekday before anchor time",
+			period: QuotaPeriodWeekly,
+			anchor: anchor,
+			now:    time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC), // среда
+			want:   time.Date(2024, 1, 24, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "monthly after anchor day",
+			period: QuotaPeriodMonthly,
+			anchor: anchor,
+			now:    time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "monthly before anchor day",
+			period: QuotaPeriodMonthly,
+			anchor: anchor,
+			now:    time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 2, 10, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "monthly anchor 31 clamps to leap february",
+			period: QuotaPeriodMonthly,
+			anchor: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+			now:    time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "monthly anchor 31 in short month",
+			period: QuotaPeriodMonthly,
+			anchor: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+			now:    time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "monthly before anchor day in january",
+			period: QuotaPeriodMonthly,
+			anchor: anchor,
+			now:    time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+			want:   time.Date(2024, 12, 10, 9, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{QuotaPeriod: tt.period, QuotaAnchor: tt.anchor}
+			if got := user.CurrentPeriodStart(tt.now); !got.Equal(tt.want) {
+				t.Errorf("CurrentPeriodStart(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrentPeriodStartDefaultsToCreatedAt(t *testing.T) {
+	user := &User{
+		QuotaPeriod: QuotaPeriodDaily,
+		CreatedAt:   time.Date(2024, 1, 10, 6, 0, 0, 0, time.UTC),
+	}
+	now := time.Date(2024, 1, 12, 7, 0, 0, 0, time.UTC)
+	want := time.Date(2024, 1, 12, 6, 0, 0, 0, time.UTC)
+	if got := user.CurrentPeriodStart(now); !got.Equal(want) {
+		t.Errorf("CurrentPeriodStart(%v) = %v, want %v", now, got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Состояния ключей Reality
+const (
+	RealityKeyActive   = "active"   // ключ inbound, его публичный ключ получают клиенты
+	RealityKeyPrevious = "previous" // прежний ключ, принимается до RetiresAt
+	RealityKeyRetired  = "retired"
+)
+
+// RealityKey - пара ключей x25519 Reality (base64 raw URL, как у xray x25519)
+type RealityKey struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	PrivateKey string    `gorm:"not null" json:"-"`
+	PublicKey  string    `gorm:"not null" json:"public_key"`
+	State      string    `gorm:"index;not null" json:"state"`
+	RetiresAt  time.Time `json:"retires_at"` // для previous: до какого момента принимается
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// GetRealityKey возвращает последний ключ в состоянии state
+func (r *Repository) GetRealityKey(state string) (*RealityKey, error) {
+	var key RealityKey
+	if err := r.db.Where("state = ?", state).Order("id DESC").First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get reality key: %w", err)
+	}
+	return &key, nil
+}
+
+// CreateRealityKey сохраняет ключ (например, сгенерированный при первом запуске)
+func (r *Repository) CreateRealityKey(key *RealityKey) error {
+	if err := r.db.Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create reality key: %w", err)
+	}
+	return nil
+}
+
+// RotateRealityKey делает next активным ключом, а текущий - прежним до retiresAt.
+// current записывается, если активного ключа в БД еще нет (ключ из окружения).
+// Ключ, который уже был прежним, выводится из оборота.
+func (r *Repository) RotateRealityKey(current, next *RealityKey, retiresAt time.Time) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&RealityKey{}).Where("state = ?", RealityKeyPrevious).
+			Update("state", RealityKeyRetired).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&RealityKey{}).Where("state = ?", RealityKeyActive).
+			Updates(map[string]interface{}{"state": RealityKeyPrevious, "retires_at": retiresAt})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && current != nil {
+			current.State = RealityKeyPrevious
+			current.RetiresAt = retiresAt
+			if err := tx.Create(current).Error; err != nil {
+				return err
+			}
+		}
+
+		next.State = RealityKeyActive
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rotate reality key: %w", err)
+	}
+	return nil
+}
+
+// RetireRealityKeys выводит из оборота прежние ключи, срок которых истек к now
+func (r *Repository) RetireRealityKeys(now time.Time) (int64, error) {
+	result := r.db.Model(&RealityKey{}).
+		Where("state = ? AND retires_at <= ?", RealityKeyPrevious, now).
+		Update("state", RealityKeyRetired)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to retire reality keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
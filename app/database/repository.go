@@ -1,12 +1,22 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// countedTrafficSQL - SQL аналог User.CountedTraffic
+const countedTrafficSQL = `(CASE traffic_limit_mode
+	WHEN 'download' THEN traffic_down
+	WHEN 'upload' THEN traffic_up
+	ELSE traffic_used END)`
+
+// ErrUserNotFound - пользователя нет в БД (в отличие от ошибки запроса)
+var ErrUserNotFound = errors.New("user not found")
+
 // Repository представляет репозиторий для работы с пользователями
 type Repository struct {
 	db *gorm.DB
@@ -30,7 +40,23 @@ func (r *Repository) GetUserByID(id uint) (*User, error) {
 	var user User
 	if err := r.db.First(&user, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// GetUserBySubToken получает пользователя по токену подписки
+func (r *Repository) GetUserBySubToken(token string) (*User, error) {
+	if token == "" {
+		return nil, ErrUserNotFound
+	}
+
+	var user User
+	if err := r.db.Where("sub_token = ?", token).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -42,7 +68,7 @@ func (r *Repository) GetUserByUsername(username string) (*User, error) {
 	var user User
 	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -54,7 +80,7 @@ func (r *Repository) GetUserByUUID(uuid string) (*User, error) {
 	var user User
 	if err := r.db.Where("uuid = ?", uuid).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -96,14 +122,18 @@ func (r *Repository) UpdateTrafficUsage(uuid string, upload, download int64) err
 
 	result := r.db.Model(&User{}).
 		Where("uuid = ?", uuid).
-		UpdateColumn("traffic_used", gorm.Expr("traffic_used + ?", totalTraffic))
+		UpdateColumns(map[string]interface{}{
+			"traffic_up":   gorm.Expr("traffic_up + ?", upload),
+			"traffic_down": gorm.Expr("traffic_down + ?", download),
+			"traffic_used": gorm.Expr("traffic_used + ?", totalTraffic),
+		})
 
 	if result.Error != nil {
 		return fmt.Errorf("failed to update traffic usage: %w", result.Error)
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrUserNotFound
 	}
 
 	// Проверяем, не превышен ли лимит
@@ -115,6 +145,7 @@ func (r *Repository) UpdateTrafficUsage(uuid string, upload, download int64) err
 	// Автоматически деактивируем пользователя если превышен лимит
 	if user.IsOverLimit() && user.IsActive {
 		user.IsActive = false
+		user.DeactivatedReason = DeactivatedOverLimit
 		if err := r.UpdateUser(user); err != nil {
 			return fmt.Errorf("failed to deactivate user over limit: %w", err)
 		}
@@ -134,7 +165,7 @@ func (r *Repository) DeactivateUser(id uint) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrUserNotFound
 	}
 
 	return nil
@@ -151,7 +182,7 @@ func (r *Repository) ActivateUser(id uint) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrUserNotFound
 	}
 
 	return nil
@@ -166,24 +197,30 @@ func (r *Repository) DeleteUser(id uint) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrUserNotFound
 	}
 
 	return nil
 }
 
-// ResetTraffic сбрасывает счетчик трафика пользователя
+// ResetTraffic сбрасывает счетчик трафика пользователя и перенесенный остаток квоты:
+// после ручного сброса лимит периода снова равен TrafficLimit
 func (r *Repository) ResetTraffic(id uint) error {
 	result := r.db.Model(&User{}).
 		Where("id = ?", id).
-		Update("traffic_used", 0)
+		Updates(map[string]interface{}{
+			"traffic_used":   0,
+			"traffic_up":     0,
+			"traffic_down":   0,
+			"rollover_bytes": 0,
+		})
 
 	if result.Error != nil {
 		return fmt.Errorf("failed to reset traffic: %w", result.Error)
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrUserNotFound
 	}
 
 	return nil
@@ -227,7 +264,7 @@ func (r *Repository) CountUsersOverLimit() (int64, error) {
 	var count int64
 	if err := r.db.Model(&User{}).
 		Where("traffic_limit > 0").
-		Where("traffic_used >= traffic_limit").
+		Where(countedTrafficSQL + " >= traffic_limit + rollover_bytes").
 		Count(&count).Error; err != nil {
 		return 0, fmt.Errorf("failed to count users over limit: %w", err)
 	}
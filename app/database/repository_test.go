@@ -0,0 +1,30 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetUserByUsernameNotFound(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.CreateUser(&User{Username: "alice", UUID: "uuid-alice", SubToken: "token-alice"}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	if _, err := repo.GetUserByUsername("alice"); err != nil {
+		t.Fatalf("GetUserByUsername(alice) error = %v", err)
+	}
+	if _, err := repo.GetUserByUsername("bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByUsername(bob) error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	// Ошибка БД не должна выглядеть как отсутствие пользователя
+	sqlDB, err := repo.db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database instance: %v", err)
+	}
+	sqlDB.Close()
+	if _, err := repo.GetUserByUsername("alice"); err == nil || errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByUsername() on closed database error = %v, want database error", err)
+	}
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ключи настроек
+const (
+	SettingAuthTokenSecret = "auth_token_secret" // сгенерированный ключ подписи access токенов
+)
+
+// Setting - настройка сервиса, сгенерированная при первом запуске и общая для перезапусков
+type Setting struct {
+	Key       string    `gorm:"primaryKey" json:"key"`
+	Value     string    `gorm:"not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetOrCreateSetting возвращает значение настройки key, а если ее нет - сохраняет value
+func (r *Repository) GetOrCreateSetting(key, value string) (string, error) {
+	setting := Setting{Key: key}
+	if err := r.db.Where(&Setting{Key: key}).Attrs(&Setting{Value: value}).FirstOrCreate(&setting).Error; err != nil {
+		return "", fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+	return setting.Value, nil
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrafficPoint представляет одну точку временного ряда трафика
+type TrafficPoint struct {
+	Time     time.Time `json:"time"`
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+}
+
+// AddTrafficSample добавляет трафик в часовой бакет пользователя
+func (r *Repository) AddTrafficSample(userID uint, at time.Time, upload, download int64) error {
+	sample := &TrafficSample{
+		UserID:      userID,
+		Granularity: GranularityHour,
+		BucketStart: BucketStart(at, GranularityHour),
+		Upload:      upload,
+		Download:    download,
+	}
+
+	if err := upsertTrafficSample(r.db, sample); err != nil {
+		return fmt.Errorf("failed to add traffic sample: %w", err)
+	}
+	return nil
+}
+
+// RollupTrafficSamples сворачивает часовые бакеты старше before в суточные
+func (r *Repository) RollupTrafficSamples(before time.Time) (int64, error) {
+	var rolled int64
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var hourly []TrafficSample
+		if err := tx.Where("granularity = ? AND bucket_start < ?", GranularityHour, before).
+			Find(&hourly).Error; err != nil {
+			return err
+		}
+		if len(hourly) == 0 {
+			return nil
+		}
+
+		type dayKey struct {
+			userID uint
+			day    time.Time
+		}
+		daily := make(map[dayKey]*TrafficSample)
+		for _, sample := range hourly {
+			key := dayKey{userID: sample.UserID, day: BucketStart(sample.BucketStart, GranularityDay)}
+			entry, ok := daily[key]
+			if !ok {
+				entry = &TrafficSample{
+					UserID:      key.userID,
+					Granularity: GranularityDay,
+					BucketStart: key.day,
+				}
+				daily[key] = entry
+			}
+			entry.Upload += sample.Upload
+			entry.Download += sample.Download
+		}
+
+		for _, sample := range daily {
+			if err := upsertTrafficSample(tx, sample); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Where("granularity = ? AND bucket_start < ?", GranularityHour, before).
+			Delete(&TrafficSample{})
+		if result.Error != nil {
+			return result.Error
+		}
+		rolled = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up traffic samples: %w", err)
+	}
+
+	return rolled, nil
+}
+
+// DeleteTrafficSamples удаляет бакеты указанной гранулярности старше before
+func (r *Repository) DeleteTrafficSamples(granularity string, before time.Time) (int64, error) {
+	result := r.db.Where("granularity = ? AND bucket_start < ?", granularity, before).
+		Delete(&TrafficSample{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete traffic samples: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// DeleteUserTrafficHistory удаляет историю трафика и архив периодов пользователя
+func (r *Repository) DeleteUserTrafficHistory(userID uint) error {
+	if err := r.db.Where("user_id = ?", userID).Delete(&TrafficSample{}).Error; err != nil {
+		return fmt.Errorf("failed to delete traffic samples: %w", err)
+	}
+	if err := r.db.Where("user_id = ?", userID).Delete(&TrafficPeriod{}).Error; err != nil {
+		return fmt.Errorf("failed to delete traffic periods: %w", err)
+	}
+	return nil
+}
+
+// QueryTraffic возвращает временной ряд трафика за [from, to); from округляется
+// вниз до начала бакета. userID = nil суммирует трафик всех пользователей.
+// Для granularity=day учитываются и еще не свернутые часовые бакеты.
+func (r *Repository) QueryTraffic(userID *uint, from, to time.Time, granularity string) ([]TrafficPoint, error) {
+	// bucket_start хранится в UTC, а SQLite сравнивает время как текст
+	// с зоной значения: границы тоже приводятся к UTC
+	query := r.db.Model(&TrafficSample{}).
+		Where("bucket_start >= ? AND bucket_start < ?", BucketStart(from, granularity), to.UTC())
+
+	if granularity == GranularityHour {
+		query = query.Where("granularity = ?", GranularityHour)
+	}
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+
+	var samples []TrafficSample
+	if err := query.Find(&samples).Error; err != nil {
+		return nil, fmt.Errorf("failed to query traffic samples: %w", err)
+	}
+
+	buckets := make(map[time.Time]*TrafficPoint)
+	for _, sample := range samples {
+		bucket := BucketStart(sample.BucketStart, granularity)
+		point, ok := buckets[bucket]
+		if !ok {
+			point = &TrafficPoint{Time: bucket}
+			buckets[bucket] = point
+		}
+		point.Upload += sample.Upload
+		point.Download += sample.Download
+	}
+
+	points := make([]TrafficPoint, 0, len(buckets))
+	for _, point := range buckets {
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	return points, nil
+}
+
+// BucketStart возвращает начало бакета (UTC), в который попадает момент t
+func BucketStart(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// upsertTrafficSample прибавляет трафик к существующему бакету или создает новый
+func upsertTrafficSample(db *gorm.DB, sample *TrafficSample) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "granularity"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"upload":   gorm.Expr("traffic_samples.upload + excluded.upload"),
+			"download": gorm.Expr("traffic_samples.download + excluded.download"),
+		}),
+	}).Create(sample).Error
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBucketStart(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	tests := []struct {
+		name        string
+		t           time.Time
+		granularity string
+		want        time.Time
+	}{
+		{
+			name:        "hour truncates minutes",
+			t:           time.Date(2026, 3, 14, 15, 42, 7, 500, time.UTC),
+			granularity: GranularityHour,
+			want:        time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "hour at boundary",
+			t:           time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC),
+			granularity: GranularityHour,
+			want:        time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "day truncates to UTC midnight",
+			t:           time.Date(2026, 3, 14, 23, 59, 59, 0, time.UTC),
+			granularity: GranularityDay,
+			want:        time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "day uses UTC date of local time",
+			t:           time.Date(2026, 3, 15, 1, 30, 0, 0, msk),
+			granularity: GranularityDay,
+			want:        time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "hour converts local time to UTC",
+			t:           time.Date(2026, 3, 15, 1, 30, 0, 0, msk),
+			granularity: GranularityHour,
+			want:        time.Date(2026, 3, 14, 22, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BucketStart(tt.t, tt.granularity)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("BucketStart(%v, %q) = %v, want %v", tt.t, tt.granularity, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestRepository открывает пустую БД с миграциями во временном каталоге
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return NewRepository(db)
+}
+
+func TestQueryTrafficBoundsInOtherZones(t *testing.T) {
+	repo := newTestRepository(t)
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	// Бакеты 20:00, 21:00 и 22:00 UTC 30 сентября
+	for hour := 20; hour <= 22; hour++ {
+		at := time.Date(2026, 9, 30, hour, 30, 0, 0, time.UTC)
+		if err := repo.AddTrafficSample(1, at, 1, int64(hour)); err != nil {
+			t.Fatalf("AddTrafficSample() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want []int64
+	}{
+		{
+			name: "utc bounds",
+			from: time.Date(2026, 9, 30, 20, 0, 0, 0, time.UTC),
+			to:   time.Date(2026, 9, 30, 22, 0, 0, 0, time.UTC),
+			want: []int64{20, 21},
+		},
+		{
+			name: "to with offset",
+			from: time.Date(2026, 9, 30, 20, 0, 0, 0, time.UTC),
+			to:   time.Date(2026, 10, 1, 0, 0, 0, 0, msk), // 21:00 UTC
+			want: []int64{20},
+		},
+		{
+			name: "both bounds with offset",
+			from: time.Date(2026, 10, 1, 0, 0, 0, 0, msk), // 21:00 UTC
+			to:   time.Date(2026, 10, 1, 2, 0, 0, 0, msk), // 23:00 UTC
+			want: []int64{21, 22},
+		},
+		{
+			name: "from inside bucket",
+			from: time.Date(2026, 9, 30, 21, 45, 0, 0, time.UTC),
+			to:   time.Date(2026, 9, 30, 23, 0, 0, 0, time.UTC),
+			want: []int64{21, 22},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := repo.QueryTraffic(nil, tt.from, tt.to, GranularityHour)
+			if err != nil {
+				t.Fatalf("QueryTraffic() error = %v", err)
+			}
+			got := make([]int64, 0, len(points))
+			for _, point := range points {
+				got = append(got, point.Download)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryTraffic() downloads = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"vpn-service/xray"
+)
+
+// runKeygen печатает пару ключей x25519 для Reality в формате xray x25519.
+// С аргументом вычисляет публичный ключ для заданного приватного.
+func runKeygen(args []string) {
+	var privateKey, publicKey string
+	var err error
+
+	if len(args) > 0 {
+		privateKey = args[0]
+		publicKey, err = xray.RealityPublicKey(privateKey)
+	} else {
+		privateKey, publicKey, err = xray.GenerateRealityKeyPair()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Private key: %s\n", privateKey)
+	fmt.Printf("Public key: %s\n", publicKey)
+}
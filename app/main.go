@@ -5,9 +5,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"vpn-service/api"
+	"vpn-service/cluster"
 	"vpn-service/controllers"
 	"vpn-service/database"
 	"vpn-service/monitoring"
@@ -24,25 +27,41 @@ import (
 	_ "github.com/xtls/xray-core/proxy/blackhole"
 	_ "github.com/xtls/xray-core/proxy/dokodemo"
 	_ "github.com/xtls/xray-core/proxy/freedom"
+	_ "github.com/xtls/xray-core/proxy/shadowsocks_2022"
+	_ "github.com/xtls/xray-core/proxy/trojan"
 	_ "github.com/xtls/xray-core/proxy/vless/inbound"
 	_ "github.com/xtls/xray-core/proxy/vless/outbound"
+	_ "github.com/xtls/xray-core/proxy/vmess/inbound"
+	_ "github.com/xtls/xray-core/transport/internet/grpc"
 	_ "github.com/xtls/xray-core/transport/internet/reality"
+	_ "github.com/xtls/xray-core/transport/internet/splithttp"
 	_ "github.com/xtls/xray-core/transport/internet/tcp"
+	_ "github.com/xtls/xray-core/transport/internet/tls"
+	_ "github.com/xtls/xray-core/transport/internet/websocket"
 )
 
 func main() {
+	// Режим ноды кластера: vpn-service agent
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		runAgent()
+		return
+	}
+
+	// Генерация ключей Reality: vpn-service keygen [private-key]
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		runKeygen(os.Args[2:])
+		return
+	}
+
 	log.Println("Starting VPN Service with embedded Xray...")
 
 	// Конфигурация из переменных окружения
 	dbPath := getEnv("DB_PATH", "./data/vpn.db")
 	logPath := getEnv("LOG_PATH", "/var/log/xray/access.log")
 	serverPort := getEnv("SERVER_PORT", "8080")
+	// Без XRAY_PRIVATE_KEY ключ Reality генерируется при первом запуске и хранится в БД
 	xrayPrivateKey := getEnv("XRAY_PRIVATE_KEY", "")
 
-	if xrayPrivateKey == "" {
-		log.Fatal("XRAY_PRIVATE_KEY environment variable is required")
-	}
-
 	// Инициализация базы данных
 	log.Println("Initializing database...")
 	if err := database.InitDatabase(dbPath); err != nil {
@@ -52,23 +71,79 @@ func main() {
 
 	repo := database.NewRepository(database.GetDB())
 
+	// Пул целей Reality "host[:port][=sni]" в порядке приоритета;
+	// запасные - на случай, если сайт перестанет подходить для Reality
+	realityTargets, err := xray.ParseRealityTargets(getEnvList("XRAY_REALITY_TARGETS", ","))
+	if err != nil {
+		log.Fatalf("Invalid XRAY_REALITY_TARGETS: %v", err)
+	}
+	if len(realityTargets) == 0 {
+		realityTargets = []xray.RealityTarget{{
+			Dest:       getEnv("XRAY_REALITY_DEST", "eh.vk.com:443"),
+			ServerName: getEnv("XRAY_REALITY_SNI", "eh.vk.com"),
+		}}
+	}
+
+	// Публикуется первая исправная цель пула
+	realityTargetTimeout := getEnvDuration("REALITY_TARGET_TIMEOUT", 5*time.Second)
+	realityTarget := services.SelectRealityTarget(realityTargets, realityTargetTimeout)
+
 	// Конфигурация Xray
 	xrayConfig := &xray.Config{
 		Port:               443,
 		RealityPrivateKey:  xrayPrivateKey,
 		RealityPublicKey:   getEnv("XRAY_PUBLIC_KEY", ""),
-		RealityDest:        getEnv("XRAY_REALITY_DEST", "eh.vk.com:443"),
-		RealityServerNames: []string{getEnv("XRAY_REALITY_SNI", "eh.vk.com")},
-		RealityShortIds:    []string{"", "0123456789abcdef"},
+		RealityDest:        realityTarget.Dest,
+		RealityServerNames: []string{realityTarget.ServerName},
+		RealityShortIds:    getEnvList("XRAY_REALITY_SHARED_SHORT_IDS", ","), // общие, для старых клиентов
+		Transport:          getEnv("XRAY_TRANSPORT", xray.TransportTCP),
 		XHTTPPath:          getEnv("XRAY_XHTTP_PATH", "/xhttp"),
+		XHTTPMode:          getEnv("XRAY_XHTTP_MODE", "auto"),
+		XHTTPHost:          getEnv("XRAY_XHTTP_HOST", ""),
+		GRPCServiceName:    getEnv("XRAY_GRPC_SERVICE", "vless-grpc"),
+		WSPath:             getEnv("XRAY_WS_PATH", "/ws"),
 		LogLevel:           getEnv("XRAY_LOG_LEVEL", "info"),
 		AccessLogPath:      logPath,
 		ErrorLogPath:       getEnv("XRAY_ERROR_LOG", "/var/log/xray/error.log"),
 		StatsPort:          10085,
 		InboundTag:         "vless-in",
 		APITimeoutSeconds:  3,
+
+		RealityFallbackPort:        getEnvInt("XRAY_REALITY_FALLBACK_PORT", 10443),
+		RealityShortIDDelaySeconds: int(getEnvDuration("XRAY_REALITY_SHORT_ID_DELAY", time.Minute) / time.Second),
+
+		ShadowsocksPort:       getEnvInt("XRAY_SS_PORT", 0),
+		ShadowsocksMethod:     getEnv("XRAY_SS_METHOD", xray.ShadowsocksMethodAES128),
+		ShadowsocksServerKey:  getEnv("XRAY_SS_SERVER_KEY", ""),
+		ShadowsocksInboundTag: "ss-in",
+
+		TrojanPort:       getEnvInt("XRAY_TROJAN_PORT", 0),
+		TrojanInboundTag: "trojan-in",
+
+		VMessPort:       getEnvInt("XRAY_VMESS_PORT", 0),
+		VMessPath:       getEnv("XRAY_VMESS_PATH", "/vmess"),
+		VMessInboundTag: "vmess-in",
+
+		TLSDomain:   getEnv("XRAY_TLS_DOMAIN", ""),
+		TLSCertFile: getEnv("XRAY_TLS_CERT", "/etc/xray/tls/cert.pem"),
+		TLSKeyFile:  getEnv("XRAY_TLS_KEY", "/etc/xray/tls/key.pem"),
+
+		ClashRules:   getEnvList("CLASH_RULES", ";"),
+		ClashDNS:     getEnvList("CLASH_DNS", ","),
+		ClashTestURL: getEnv("CLASH_TEST_URL", ""),
+
+		DisplayName: getEnv("SERVER_NAME", ""),
 	}
 
+	// Ключи Reality после ротации хранятся в БД и заменяют ключ из окружения
+	if err := services.LoadRealityKeys(repo, xrayConfig); err != nil {
+		log.Fatalf("Failed to load Reality keys: %v", err)
+	}
+
+	// Снимки конфигурации Xray для сервисов: ротация ключей и смена цели Reality
+	// публикуют новую конфигурацию, а не меняют ее на месте
+	xrayConfigStore := xray.NewConfigStore(xrayConfig)
+
 	// Создание менеджера Xray
 	log.Println("Initializing Xray manager...")
 	xrayManager, err := xray.NewManager(xrayConfig)
@@ -88,33 +163,158 @@ func main() {
 	}
 	defer xrayManager.Stop()
 
-	log.Printf("Xray started with %d users", len(users))
+	log.Printf("Xray started with %d users (VLESS transport: %s)", len(users), xrayConfig.Transport)
+	if xrayConfig.ShadowsocksEnabled() {
+		log.Printf("Shadowsocks 2022 inbound enabled on port %d (%s)", xrayConfig.ShadowsocksPort, xrayConfig.ShadowsocksMethod)
+	}
+	if xrayConfig.TrojanEnabled() {
+		log.Printf("Trojan inbound enabled on port %d", xrayConfig.TrojanPort)
+	}
+	if xrayConfig.VMessEnabled() {
+		log.Printf("VMess WebSocket+TLS inbound enabled on %s:%d%s", xrayConfig.TLSDomain, xrayConfig.VMessPort, xrayConfig.VMessPath)
+	}
 
 	// Инициализация метрик Prometheus
 	log.Println("Initializing Prometheus metrics...")
 	metrics := monitoring.NewMetrics()
+	// Задержки трафика по ограничению скорости пользователей
+	xrayManager.SetThrottleHook(metrics.ObserveThrottle)
 	metricsCollector := monitoring.NewMetricsCollector(metrics, repo)
 	metricsCollector.Start(15 * time.Second)
 	defer metricsCollector.Stop()
 
-	// Запуск мониторинга логов
-	log.Println("Starting log monitor...")
-	logMonitor := monitoring.NewLogMonitor(logPath, repo, 30*time.Second)
-	if err := logMonitor.Start(); err != nil {
-		log.Printf("Warning: failed to start log monitor: %v", err)
+	// Учет трафика: по умолчанию через StatsService Xray,
+	// парсинг access-лога оставлен как запасной вариант (TRAFFIC_SOURCE=log)
+	trafficSource := getEnv("TRAFFIC_SOURCE", "stats")
+	trafficInterval := getEnvDuration("TRAFFIC_POLL_INTERVAL", 30*time.Second)
+
+	var statsCollector *monitoring.StatsCollector
+	switch trafficSource {
+	case "log":
+		log.Println("Starting log monitor...")
+		logMonitor := monitoring.NewLogMonitor(logPath, repo, trafficInterval)
+		if err := logMonitor.Start(); err != nil {
+			log.Printf("Warning: failed to start log monitor: %v", err)
+		}
+		defer logMonitor.Stop()
+	default:
+		if trafficSource != "stats" {
+			log.Printf("Warning: unknown TRAFFIC_SOURCE %q, using stats", trafficSource)
+		}
+		log.Println("Starting Xray stats collector...")
+		// Трафик собственного Xray панели попадает в метрики нод под SERVER_NAME (или "local")
+		localSource := monitoring.NewNodeTrafficSource(xrayManager, metrics, getEnv("SERVER_NAME", "local"))
+		statsCollector = monitoring.NewStatsCollector(localSource, repo, trafficInterval)
+		if err := statsCollector.Start(); err != nil {
+			log.Printf("Warning: failed to start stats collector: %v", err)
+		}
 	}
-	defer logMonitor.Stop()
+
+	// Свертка истории трафика: часовые бакеты -> суточные
+	hourlyRetention := getEnvDuration("TRAFFIC_HOURLY_RETENTION", 7*24*time.Hour)
+	trafficRollup := monitoring.NewTrafficRollup(repo, hourlyRetention,
+		getEnvDuration("TRAFFIC_DAILY_RETENTION", 365*24*time.Hour),
+	)
+	trafficRollup.Start(time.Hour)
+	defer trafficRollup.Stop()
 
 	// Создание сервисов
 	serverIP := getEnv("SERVER_IP", "YOUR_SERVER_IP")
-	userService := services.NewUserService(repo, xrayManager, xrayConfig, serverIP)
+	limitMode := getEnv("TRAFFIC_LIMIT_MODE", database.LimitModeBoth)
+	subscriptionBaseURL := getEnv("SUBSCRIPTION_BASE_URL", "http://"+serverIP+":"+serverPort)
+	// TLS к агентам нод: CA их сертификатов и сертификат панели для mTLS
+	agentTLS, err := cluster.ClientTLSConfig(getEnv("NODE_AGENT_CA", ""),
+		getEnv("NODE_AGENT_CLIENT_CERT", ""), getEnv("NODE_AGENT_CLIENT_KEY", ""))
+	if err != nil {
+		log.Fatalf("Failed to configure node agent TLS: %v", err)
+	}
+	nodeService := services.NewNodeService(repo, xrayConfigStore, metrics, serverIP,
+		getEnvDuration("NODE_AGENT_TIMEOUT", 10*time.Second), agentTLS)
+	userService := services.NewUserService(repo, xrayManager, xrayConfigStore, nodeService, serverIP, limitMode, subscriptionBaseURL)
+	trafficService := services.NewTrafficService(repo, hourlyRetention)
+	planService := services.NewPlanService(repo, xrayConfigStore)
+	subscriptionService := services.NewSubscriptionService(repo, xrayManager, xrayConfigStore, nodeService,
+		getEnvDuration("SUBSCRIPTION_UPDATE_INTERVAL", 12*time.Hour))
+
+	// Синхронизация пользователей с нодами кластера и сбор их трафика
+	nodeService.Start(getEnvDuration("NODE_SYNC_INTERVAL", time.Minute))
+	defer nodeService.Stop()
+
+	nodeStatsCollector := monitoring.NewStatsCollector(nodeService, repo, trafficInterval)
+	if err := nodeStatsCollector.Start(); err != nil {
+		log.Printf("Warning: failed to start node stats collector: %v", err)
+	}
+
+	// Проверка здоровья нод: недоступные ноды исключаются из подписок
+	nodeHealthChecker := services.NewNodeHealthChecker(nodeService,
+		getEnvDuration("NODE_HEALTH_INTERVAL", 30*time.Second),
+		getEnvInt("NODE_HEALTH_FAILURES", 2))
+	nodeHealthChecker.Start()
+	defer nodeHealthChecker.Stop()
+
+	// Пользователи, загруженные в Xray при старте
+	userService.LoadAccessState(users)
+
+	// Фоновое применение ограничений (срок действия, лимит трафика)
+	enforcer := services.NewEnforcer(userService, metrics, getEnvDuration("ENFORCER_INTERVAL", 30*time.Second))
+	enforcer.Start()
+	defer enforcer.Stop()
+
+	// Лимит одновременных устройств (различных IP) пользователя
+	deviceLimiter := services.NewDeviceLimiter(userService, metrics,
+		getEnv("DEVICE_LIMIT_ACTION", services.DeviceActionLog),
+		getEnvDuration("DEVICE_SUSPEND_DURATION", 10*time.Minute),
+		getEnvDuration("DEVICE_CHECK_INTERVAL", time.Minute))
+	deviceLimiter.Start()
+	defer deviceLimiter.Stop()
+
+	// Сверка пользователей Xray с БД: исправляет пропущенные hot add/remove
+	reconciler := services.NewReconciler(userService, metrics, getEnvDuration("XRAY_RECONCILE_INTERVAL", 5*time.Minute))
+	reconciler.Start()
+	defer reconciler.Stop()
+
+	// Ротация ключей Reality: окончание перекрытия прежнего ключа
+	realityService := services.NewRealityService(repo, xrayConfigStore, userService)
+	realityService.Start(getEnvDuration("REALITY_KEY_CHECK_INTERVAL", time.Minute))
+	defer realityService.Stop()
+
+	// Проверка целей Reality (TLS 1.3, X25519, h2) и переключение с неисправной
+	realityTargetMonitor := services.NewRealityTargetMonitor(realityService, metrics, realityTargets,
+		getEnvDuration("REALITY_TARGET_CHECK_INTERVAL", 5*time.Minute),
+		realityTargetTimeout)
+	realityTargetMonitor.Start()
+	defer realityTargetMonitor.Stop()
+
+	// Сброс квот на границах периодов (daily/weekly/monthly)
+	quotaScheduler := services.NewQuotaScheduler(userService, getEnvDuration("QUOTA_CHECK_INTERVAL", time.Minute))
+	quotaScheduler.Start()
+	defer quotaScheduler.Stop()
+
+	// Аутентификация администраторов; API_BEARER_TOKEN - статический токен супер-администратора
+	authSecret, err := services.LoadAuthSecret(repo, getEnv("AUTH_TOKEN_SECRET", ""))
+	if err != nil {
+		log.Fatalf("Failed to load auth token secret: %v", err)
+	}
+	authService := services.NewAuthService(repo, getEnv("API_BEARER_TOKEN", ""), authSecret,
+		getEnvDuration("AUTH_ACCESS_TTL", 15*time.Minute),
+		getEnvDuration("AUTH_REFRESH_TTL", 30*24*time.Hour))
+	if !authService.Enabled() {
+		log.Println("Warning: API_BEARER_TOKEN is not set and no admins or API keys exist, authentication disabled")
+	}
 
 	// Создание контроллеров
-	mainController := controllers.NewMainController(userService)
+	authController := controllers.NewAuthController(authService)
+	apiKeyController := controllers.NewAPIKeyController(authService)
+	mainController := controllers.NewMainController(userService, reconciler)
 	userController := controllers.NewUserController(userService)
+	trafficController := controllers.NewTrafficController(trafficService)
+	planController := controllers.NewPlanController(planService)
+	subscriptionController := controllers.NewSubscriptionController(subscriptionService)
+	nodeController := controllers.NewNodeController(nodeService)
+	realityController := controllers.NewRealityController(realityService, realityTargetMonitor, getEnvDuration("REALITY_KEY_OVERLAP", 72*time.Hour))
 
 	// Настройка маршрутизатора
-	router := api.SetupRouter(mainController, userController)
+	router := api.SetupRouter(authService, authController, apiKeyController, mainController, userController, trafficController, planController, subscriptionController, nodeController, realityController)
 
 	// Запуск HTTP сервера
 	server := &http.Server{
@@ -129,13 +329,48 @@ func main() {
 	go func() {
 		log.Printf("HTTP server listening on port %s", serverPort)
 		log.Printf("API documentation:")
+		log.Printf("  - POST   /api/auth/login             - Admin login (access + refresh token)")
+		log.Printf("  - POST   /api/auth/refresh           - Refresh admin session")
+		log.Printf("  - POST   /api/auth/logout            - Revoke current session")
+		log.Printf("  - GET    /api/auth/me                - Current caller")
+		log.Printf("  - POST   /api/admins                 - Create admin")
+		log.Printf("  - GET    /api/admins                 - List admins")
+		log.Printf("  - PATCH  /api/admins/{id}            - Update admin (password, role, enabled)")
+		log.Printf("  - DELETE /api/admins/{id}            - Delete admin")
+		log.Printf("  - POST   /api/admins/{id}/revoke     - Revoke all sessions of admin")
+		log.Printf("  - POST   /api/keys                   - Create API key (role, scopes)")
+		log.Printf("  - GET    /api/keys                   - List API keys")
+		log.Printf("  - DELETE /api/keys/{id}              - Revoke API key")
+		log.Printf("  - GET    /api/roles                  - Roles and scopes")
 		log.Printf("  - POST   /api/users                  - Create user")
 		log.Printf("  - GET    /api/users                  - List users")
 		log.Printf("  - GET    /api/users/{id}             - Get user")
 		log.Printf("  - PATCH  /api/users/{id}             - Update user")
 		log.Printf("  - DELETE /api/users/{id}             - Delete user")
-		log.Printf("  - GET    /api/users/{id}/config      - Get client config")
+		log.Printf("  - GET    /api/users/{id}/config      - Get client config (?format=clash|singbox)")
 		log.Printf("  - POST   /api/users/{id}/reset-traffic - Reset traffic")
+		log.Printf("  - POST   /api/users/{id}/subscription/rotate - Rotate subscription token (users:write + config:read)")
+		log.Printf("  - GET    /api/users/{id}/sessions - Current IPs of user")
+		log.Printf("  - GET    /api/users/{id}/traffic     - User traffic history")
+		log.Printf("  - GET    /api/users/{id}/traffic/periods - Archived quota periods")
+		log.Printf("  - GET    /api/traffic                - Fleet traffic history")
+		log.Printf("  - POST   /api/plans                  - Create plan")
+		log.Printf("  - GET    /api/plans                  - List plans")
+		log.Printf("  - GET    /api/plans/{id}             - Get plan")
+		log.Printf("  - PATCH  /api/plans/{id}             - Update plan")
+		log.Printf("  - DELETE /api/plans/{id}             - Delete plan")
+		log.Printf("  - POST   /api/nodes                  - Register cluster node")
+		log.Printf("  - GET    /api/nodes                  - List nodes")
+		log.Printf("  - GET    /api/nodes/{id}             - Get node")
+		log.Printf("  - PATCH  /api/nodes/{id}             - Update node")
+		log.Printf("  - DELETE /api/nodes/{id}             - Delete node")
+		log.Printf("  - POST   /api/nodes/{id}/sync        - Sync users to node")
+		log.Printf("  - POST   /api/admin/reconcile        - Reconcile Xray users with database")
+		log.Printf("  - GET    /api/admin/health           - Health check with Xray reload and node details")
+		log.Printf("  - GET    /api/admin/reality          - Reality public key and rotation state")
+		log.Printf("  - POST   /api/admin/reality/rotate   - Rotate Reality keys (overlap_hours)")
+		log.Printf("  - GET    /api/admin/reality/targets  - Reality dest/SNI pool and probe results")
+		log.Printf("  - GET    /sub/{token}                - Public subscription (?format=base64|clash|singbox)")
 		log.Printf("  - GET    /health                     - Health check")
 		log.Printf("  - GET    /stats                      - Service stats")
 		log.Printf("  - GET    /metrics                    - Prometheus metrics")
@@ -152,6 +387,12 @@ func main() {
 
 	log.Println("Shutting down gracefully...")
 
+	// Забираем последние счетчики трафика до остановки Xray
+	if statsCollector != nil {
+		statsCollector.Stop()
+	}
+	nodeStatsCollector.Stop()
+
 	// Останавливаем Xray
 	if err := xrayManager.Stop(); err != nil {
 		log.Printf("Error stopping Xray: %v", err)
@@ -168,3 +409,61 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvDuration возвращает длительность из переменной окружения (например "30s", "5m")
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Warning: invalid %s=%q, using %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+// getEnvInt возвращает целое число из переменной окружения или значение по умолчанию
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getEnvBool возвращает флаг из переменной окружения ("true", "1", "false", "0")
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return flag
+}
+
+// getEnvList разбивает переменную окружения по разделителю, пустые элементы отбрасываются
+func getEnvList(key, separator string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -273,7 +273,7 @@ func (m *LogMonitor) flushStats() {
 		}
 
 		// Обновляем трафик в БД
-		if err := m.repository.UpdateTrafficUsage(user.UUID, upload, download); err != nil {
+		if err := recordTraffic(m.repository, user, upload, download); err != nil {
 			log.Printf("Failed to update traffic for user %s: %v", user.Username, err)
 			continue
 		}
@@ -2,8 +2,10 @@ package monitoring
 
 import (
 	"log"
+	"strconv"
 	"time"
 	"vpn-service/database"
+	"vpn-service/xray"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -19,8 +21,29 @@ type Metrics struct {
 	ConnectionsTotal prometheus.Counter
 	ConnectionActive prometheus.Gauge
 	UserLimitRemain  *prometheus.GaugeVec
+	AccessChanges    *prometheus.CounterVec
+	// XrayDrift - расхождения пользователей Xray с БД, найденные сверкой (labels kind, result)
+	XrayDrift *prometheus.CounterVec
+	// DeviceLimitViolations - превышения лимита устройств (label action)
+	DeviceLimitViolations *prometheus.CounterVec
+	// UserThrottled - время, на которое трафик пользователя задержан ограничением скорости
+	UserThrottled *prometheus.CounterVec
+
+	// Цели Reality (labels dest, server_name)
+	RealityTargetUp       *prometheus.GaugeVec
+	RealityTargetActive   *prometheus.GaugeVec
+	RealityTargetSwitches *prometheus.CounterVec
+
+	// Метрики нод кластера (label node)
+	NodeHealth       *prometheus.GaugeVec
+	NodeProbeLatency *prometheus.GaugeVec
+	NodeUsers        *prometheus.GaugeVec
+	NodeTraffic      *prometheus.CounterVec
 }
 
+// NodeHealthStates - состояния ноды для метрики vpn_node_health
+var NodeHealthStates = []string{"healthy", "degraded", "down"}
+
 // NewMetrics создает и регистрирует метрики
 func NewMetrics() *Metrics {
 	m := &Metrics{
@@ -69,6 +92,83 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"username", "uuid"},
 		),
+		AccessChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_user_access_changes_total",
+				Help: "Users added to or removed from Xray by the enforcer",
+			},
+			[]string{"action", "reason"},
+		),
+		XrayDrift: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_xray_drift_total",
+				Help: "Users found out of sync between the database and Xray inbounds by the reconciler",
+			},
+			[]string{"kind", "result"},
+		),
+		DeviceLimitViolations: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_device_limit_violations_total",
+				Help: "Users caught with more concurrent IPs than max_devices",
+			},
+			[]string{"action"},
+		),
+		UserThrottled: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_user_throttled_seconds_total",
+				Help: "Time user traffic was delayed by the per-user speed limit",
+			},
+			[]string{"user_id", "direction"},
+		),
+		RealityTargetUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_reality_target_up",
+				Help: "Reality dest/SNI target passed the last probe (TLS 1.3, X25519, h2)",
+			},
+			[]string{"dest", "server_name"},
+		),
+		RealityTargetActive: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_reality_target_active",
+				Help: "Reality dest/SNI target published in the inbound and client configs",
+			},
+			[]string{"dest", "server_name"},
+		),
+		RealityTargetSwitches: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_reality_target_switches_total",
+				Help: "Automatic switches of the Reality target away from a failing one",
+			},
+			[]string{"from", "to"},
+		),
+		NodeHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_node_health",
+				Help: "Cluster node health: 1 for the current state (healthy, degraded, down), 0 otherwise",
+			},
+			[]string{"node", "state"},
+		),
+		NodeProbeLatency: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_node_probe_latency_seconds",
+				Help: "Latency of the last node health probe (agent API, Xray port)",
+			},
+			[]string{"node", "probe"},
+		),
+		NodeUsers: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_node_users",
+				Help: "Users loaded into Xray on the node after the last sync",
+			},
+			[]string{"node"},
+		),
+		NodeTraffic: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_node_traffic_bytes_total",
+				Help: "Traffic collected from the node in bytes",
+			},
+			[]string{"node", "direction"},
+		),
 	}
 
 	// Регистрируем все метрики
@@ -81,10 +181,57 @@ func NewMetrics() *Metrics {
 	prometheus.MustRegister(m.ConnectionsTotal)
 	prometheus.MustRegister(m.ConnectionActive)
 	prometheus.MustRegister(m.UserLimitRemain)
+	prometheus.MustRegister(m.AccessChanges)
+	prometheus.MustRegister(m.XrayDrift)
+	prometheus.MustRegister(m.DeviceLimitViolations)
+	prometheus.MustRegister(m.UserThrottled)
+	prometheus.MustRegister(m.RealityTargetUp)
+	prometheus.MustRegister(m.RealityTargetActive)
+	prometheus.MustRegister(m.RealityTargetSwitches)
+	prometheus.MustRegister(m.NodeHealth)
+	prometheus.MustRegister(m.NodeProbeLatency)
+	prometheus.MustRegister(m.NodeUsers)
+	prometheus.MustRegister(m.NodeTraffic)
 
 	return m
 }
 
+// SetNodeHealth выставляет текущее состояние ноды
+func (m *Metrics) SetNodeHealth(node, state string) {
+	for _, known := range NodeHealthStates {
+		value := 0.0
+		if known == state {
+			value = 1
+		}
+		m.NodeHealth.WithLabelValues(node, known).Set(value)
+	}
+}
+
+// AddNodeTraffic учитывает трафик, собранный с ноды
+func (m *Metrics) AddNodeTraffic(node string, traffic map[string]*xray.UserTraffic) {
+	var upload, download int64
+	for _, entry := range traffic {
+		upload += entry.Uplink
+		download += entry.Downlink
+	}
+	m.NodeTraffic.WithLabelValues(node, "upload").Add(float64(upload))
+	m.NodeTraffic.WithLabelValues(node, "download").Add(float64(download))
+}
+
+// ObserveThrottle учитывает задержку трафика пользователя (xray.ThrottleHook)
+func (m *Metrics) ObserveThrottle(userID uint, direction string, delay time.Duration) {
+	m.UserThrottled.WithLabelValues(strconv.FormatUint(uint64(userID), 10), direction).Add(delay.Seconds())
+}
+
+// DeleteNode удаляет все серии удаленной ноды
+func (m *Metrics) DeleteNode(node string) {
+	labels := prometheus.Labels{"node": node}
+	m.NodeHealth.DeletePartialMatch(labels)
+	m.NodeProbeLatency.DeletePartialMatch(labels)
+	m.NodeUsers.DeletePartialMatch(labels)
+	m.NodeTraffic.DeletePartialMatch(labels)
+}
+
 // MetricsCollector собирает метрики из базы данных
 type MetricsCollector struct {
 	metrics    *Metrics
@@ -182,10 +329,8 @@ func (c *MetricsCollector) collectMetrics() {
 
 	var totalUpload, totalDownload int64
 	for _, user := range users {
-		// Предполагаем что трафик примерно 50/50 upload/download
-		// В реальности нужно хранить отдельно
-		upload := user.TrafficUsed / 2
-		download := user.TrafficUsed / 2
+		upload := user.TrafficUp
+		download := user.TrafficDown
 
 		c.metrics.UserTraffic.WithLabelValues(
 			user.Username, user.UUID, "upload",
@@ -0,0 +1,177 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+// TrafficSource отдает счетчики трафика пользователей (xray.Manager, ноды кластера).
+// Вместе с ошибкой может вернуть счетчики, которые удалось прочитать (и сбросить).
+type TrafficSource interface {
+	QueryUserTraffic(reset bool) (map[string]*xray.UserTraffic, error)
+}
+
+// nodeTrafficSource учитывает трафик источника в метриках под именем ноды
+type nodeTrafficSource struct {
+	source  TrafficSource
+	metrics *Metrics
+	node    string
+}
+
+// NewNodeTrafficSource оборачивает источник трафика (например, собственный Xray панели)
+// метрикой vpn_node_traffic_bytes_total с указанным именем ноды
+func NewNodeTrafficSource(source TrafficSource, metrics *Metrics, node string) TrafficSource {
+	return &nodeTrafficSource{source: source, metrics: metrics, node: node}
+}
+
+func (s *nodeTrafficSource) QueryUserTraffic(reset bool) (map[string]*xray.UserTraffic, error) {
+	// Сброшенные счетчики учитываются и при ошибке, иначе трафик пропадет из метрик
+	traffic, err := s.source.QueryUserTraffic(reset)
+	if reset && len(traffic) > 0 {
+		s.metrics.AddNodeTraffic(s.node, traffic)
+	}
+	return traffic, err
+}
+
+// StatsCollector периодически опрашивает StatsService Xray и сохраняет трафик в БД
+type StatsCollector struct {
+	source     TrafficSource
+	repository *database.Repository
+	interval   time.Duration
+	// pending хранит дельты, которые уже сброшены в Xray, но еще не записаны в БД
+	pending map[string]*xray.UserTraffic
+	mu      sync.Mutex
+	stopCh  chan struct{}
+	running bool
+}
+
+// NewStatsCollector создает новый коллектор трафика
+func NewStatsCollector(source TrafficSource, repo *database.Repository, interval time.Duration) *StatsCollector {
+	return &StatsCollector{
+		source:     source,
+		repository: repo,
+		interval:   interval,
+		pending:    make(map[string]*xray.UserTraffic),
+		stopCh:     make(chan struct{}),
+		running:    false,
+	}
+}
+
+// Start запускает периодический опрос статистики
+func (c *StatsCollector) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running {
+		return fmt.Errorf("stats collector is already running")
+	}
+	c.running = true
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.collect()
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Stats collector started (interval: %v)", c.interval)
+	return nil
+}
+
+// Stop останавливает опрос и сохраняет последние данные
+func (c *StatsCollector) Stop() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	close(c.stopCh)
+	c.running = false
+	c.mu.Unlock()
+
+	// Финальный сбор, пока Xray еще запущен
+	c.collect()
+
+	log.Println("Stats collector stopped")
+}
+
+// IsRunning проверяет, запущен ли коллектор
+func (c *StatsCollector) IsRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// collect читает счетчики со сбросом и записывает дельты в БД
+func (c *StatsCollector) collect() {
+	stats, err := c.source.QueryUserTraffic(true)
+	if err != nil {
+		log.Printf("Failed to query Xray stats: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Счетчики в Xray уже обнулены, поэтому сначала копим их локально
+	for email, traffic := range stats {
+		if traffic.Uplink == 0 && traffic.Downlink == 0 {
+			continue
+		}
+		entry, ok := c.pending[email]
+		if !ok {
+			entry = &xray.UserTraffic{Email: email}
+			c.pending[email] = entry
+		}
+		entry.Uplink += traffic.Uplink
+		entry.Downlink += traffic.Downlink
+	}
+
+	for email, traffic := range c.pending {
+		user, err := c.repository.GetUserByUsername(email)
+		if errors.Is(err, database.ErrUserNotFound) {
+			// Пользователь удален - данные больше некуда записывать
+			log.Printf("Dropping traffic for unknown user %s", email)
+			delete(c.pending, email)
+			continue
+		}
+		if err != nil {
+			// Ошибка БД: счетчики Xray уже обнулены, повторим на следующем тике
+			log.Printf("Failed to get user %s: %v", email, err)
+			continue
+		}
+
+		if err := recordTraffic(c.repository, user, traffic.Uplink, traffic.Downlink); err != nil {
+			// Оставляем в pending, повторим на следующем тике
+			log.Printf("Failed to update traffic for user %s: %v", user.Username, err)
+			continue
+		}
+
+		delete(c.pending, email)
+	}
+}
+
+// recordTraffic записывает дельту трафика в счетчики пользователя и историю
+func recordTraffic(repo *database.Repository, user *database.User, upload, download int64) error {
+	if err := repo.UpdateTrafficUsage(user.UUID, upload, download); err != nil {
+		return err
+	}
+
+	// История вторична: ошибка не должна приводить к повторному учету счетчиков
+	if err := repo.AddTrafficSample(user.ID, time.Now(), upload, download); err != nil {
+		log.Printf("Failed to record traffic sample for user %s: %v", user.Username, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"log"
+	"time"
+	"vpn-service/database"
+)
+
+// TrafficRollup сворачивает часовую историю трафика в суточную и чистит старые данные
+type TrafficRollup struct {
+	repository      *database.Repository
+	hourlyRetention time.Duration
+	dailyRetention  time.Duration
+	stopCh          chan struct{}
+	running         bool
+}
+
+// NewTrafficRollup создает планировщик свертки истории.
+// hourlyRetention - сколько хранить часовые бакеты, dailyRetention - суточные (0 = бессрочно).
+func NewTrafficRollup(repo *database.Repository, hourlyRetention, dailyRetention time.Duration) *TrafficRollup {
+	return &TrafficRollup{
+		repository:      repo,
+		hourlyRetention: hourlyRetention,
+		dailyRetention:  dailyRetention,
+		stopCh:          make(chan struct{}),
+		running:         false,
+	}
+}
+
+// Start запускает периодическую свертку
+func (r *TrafficRollup) Start(interval time.Duration) {
+	if r.running {
+		return
+	}
+
+	r.running = true
+
+	go func() {
+		r.rollup()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.rollup()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Traffic rollup started (hourly retention: %v, daily retention: %v)",
+		r.hourlyRetention, r.dailyRetention)
+}
+
+// Stop останавливает свертку
+func (r *TrafficRollup) Stop() {
+	if !r.running {
+		return
+	}
+
+	close(r.stopCh)
+	r.running = false
+	log.Println("Traffic rollup stopped")
+}
+
+// rollup выполняет один проход свертки
+func (r *TrafficRollup) rollup() {
+	now := time.Now()
+
+	// Граница по началу суток, чтобы день не делился между часовыми и суточными бакетами
+	hourlyBefore := database.BucketStart(now.Add(-r.hourlyRetention), database.GranularityDay)
+	rolled, err := r.repository.RollupTrafficSamples(hourlyBefore)
+	if err != nil {
+		log.Printf("Failed to roll up traffic samples: %v", err)
+	} else if rolled > 0 {
+		log.Printf("Rolled up %d hourly traffic samples older than %s",
+			rolled, hourlyBefore.Format(time.RFC3339))
+	}
+
+	if r.dailyRetention <= 0 {
+		return
+	}
+
+	dailyBefore := database.BucketStart(now.Add(-r.dailyRetention), database.GranularityDay)
+	deleted, err := r.repository.DeleteTrafficSamples(database.GranularityDay, dailyBefore)
+	if err != nil {
+		log.Printf("Failed to delete old traffic samples: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d daily traffic samples older than %s",
+			deleted, dailyBefore.Format(time.RFC3339))
+	}
+}
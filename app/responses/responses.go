@@ -27,6 +27,13 @@ func SendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// SendText отправляет текстовый ответ с указанным Content-Type (YAML, base64 и т.п.)
+func SendText(w http.ResponseWriter, statusCode int, contentType, body string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write([]byte(body))
+}
+
 // SendSuccess отправляет успешный ответ
 func SendSuccess(w http.ResponseWriter, data interface{}) {
 	SendJSON(w, http.StatusOK, Response{
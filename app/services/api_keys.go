@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"vpn-service/database"
+	"vpn-service/utils"
+)
+
+// Scopes - права вызывающего API. ScopeAdmin дает все права.
+const (
+	ScopeUsersRead  = "users:read"  // пользователи, планы, трафик
+	ScopeUsersWrite = "users:write" // создание, продление и удаление пользователей
+	ScopeConfigRead = "config:read" // клиентские конфигурации, UUID и ссылки подписок пользователей
+	ScopeAdmin      = "admin"       // планы, ноды, Reality, администраторы, ключи API
+)
+
+// KnownScopes - все scopes
+var KnownScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeConfigRead, ScopeAdmin}
+
+// Роли - именованные наборы scopes для администраторов и ключей API
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleBilling  = "billing"
+	RoleViewer   = "viewer"
+)
+
+// Roles - scopes каждой роли
+var Roles = map[string][]string{
+	RoleAdmin:    {ScopeAdmin},
+	RoleOperator: {ScopeUsersRead, ScopeUsersWrite, ScopeConfigRead},
+	RoleBilling:  {ScopeUsersRead, ScopeUsersWrite},
+	RoleViewer:   {ScopeUsersRead},
+}
+
+// apiKeyPrefix отличает ключи API от access токенов сессий
+const apiKeyPrefix = "vpn_"
+
+// apiKeyTouchInterval - не чаще этого last_used_at ключа пишется в БД
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrAPIKeyExists      = errors.New("api key name already exists")
+	ErrInvalidAPIKeyName = errors.New("api key name is required")
+	ErrInvalidRole       = errors.New("unknown role")
+	ErrInvalidScope      = errors.New("unknown scope")
+	ErrNoScopes          = errors.New("role or scopes are required")
+	ErrCreateAPIKey      = errors.New("failed to create api key")
+	ErrDeleteAPIKey      = errors.New("failed to delete api key")
+	ErrListAPIKeys       = errors.New("failed to list api keys")
+)
+
+// HasScope проверяет право вызывающего
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveScopes возвращает scopes роли role вместе с дополнительными scopes
+func ResolveScopes(role string, extra []string) ([]string, error) {
+	set := make(map[string]bool)
+	if role != "" {
+		scopes, ok := Roles[role]
+		if !ok {
+			return nil, ErrInvalidRole
+		}
+		for _, scope := range scopes {
+			set[scope] = true
+		}
+	}
+	for _, scope := range extra {
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		set[scope] = true
+	}
+
+	scopes := make([]string, 0, len(set))
+	for scope := range set {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range KnownScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyDTO структура для создания ключа API
+type APIKeyDTO struct {
+	Name      string
+	Role      string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// CreatedAPIKey - созданный ключ API. Key возвращается только при создании.
+type CreatedAPIKey struct {
+	*database.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey создает ключ API с ролью и/или scopes
+func (s *AuthService) CreateAPIKey(dto APIKeyDTO) (*CreatedAPIKey, error) {
+	name := strings.TrimSpace(dto.Name)
+	if name == "" {
+		return nil, ErrInvalidAPIKeyName
+	}
+	if _, err := s.repository.GetAPIKeyByName(name); err == nil {
+		return nil, ErrAPIKeyExists
+	}
+	if dto.Role == "" && len(dto.Scopes) == 0 {
+		return nil, ErrNoScopes
+	}
+	if _, err := ResolveScopes(dto.Role, dto.Scopes); err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateSecret(24)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateAPIKey, err)
+	}
+	plain := apiKeyPrefix + secret
+
+	key := &database.APIKey{
+		Name:      name,
+		Prefix:    plain[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(plain),
+		Role:      dto.Role,
+		Scopes:    dto.Scopes,
+		Enabled:   true,
+		ExpiresAt: dto.ExpiresAt,
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if err := s.repository.CreateAPIKey(key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateAPIKey, err)
+	}
+	s.resetEnabled()
+
+	log.Printf("API key %s created (role: %q, scopes: %v)", key.Name, key.Role, key.Scopes)
+	return &CreatedAPIKey{APIKey: key, Key: plain}, nil
+}
+
+// ListAPIKeys возвращает список ключей API (без самих ключей)
+func (s *AuthService) ListAPIKeys() ([]*database.APIKey, error) {
+	keys, err := s.repository.ListAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListAPIKeys, err)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey отзывает ключ API
+func (s *AuthService) DeleteAPIKey(id uint) error {
+	key, err := s.repository.GetAPIKeyByID(id)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+	if err := s.repository.DeleteAPIKey(id); err != nil {
+		return fmt.Errorf("%w: %v", ErrDeleteAPIKey, err)
+	}
+	s.resetEnabled()
+	log.Printf("API key %s revoked", key.Name)
+	return nil
+}
+
+// authenticateAPIKey проверяет ключ API и записывает время его использования
+func (s *AuthService) authenticateAPIKey(token string) (*Principal, error) {
+	key, err := s.repository.GetAPIKeyByHash(hashToken(token))
+	now := time.Now()
+	if err != nil || !key.Usable(now) {
+		return nil, ErrInvalidToken
+	}
+	scopes, err := ResolveScopes(key.Role, key.Scopes)
+	if err != nil {
+		// Роль могла исчезнуть в новой версии - такой ключ ничего не разрешает
+		log.Printf("Warning: API key %s: %v", key.Name, err)
+		return nil, ErrInvalidToken
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.repository.TouchAPIKey(key.ID, now); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	return &Principal{Username: key.Name, APIKeyID: key.ID, Role: key.Role, Scopes: scopes}, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		extra   []string
+		want    []string
+		wantErr error
+	}{
+		{name: "admin role", role: RoleAdmin, want: []string{ScopeAdmin}},
+		{name: "operator role", role: RoleOperator, want: []string{ScopeConfigRead, ScopeUsersRead, ScopeUsersWrite}},
+		{name: "viewer role", role: RoleViewer, want: []string{ScopeUsersRead}},
+		{name: "scopes without role", extra: []string{ScopeUsersWrite, ScopeUsersRead}, want: []string{ScopeUsersRead, ScopeUsersWrite}},
+		{name: "role with extra scope", role: RoleViewer, extra: []string{ScopeConfigRead}, want: []string{ScopeConfigRead, ScopeUsersRead}},
+		{name: "duplicates are merged", role: RoleBilling, extra: []string{ScopeUsersRead, ScopeUsersRead}, want: []string{ScopeUsersRead, ScopeUsersWrite}},
+		{name: "nothing", want: []string{}},
+		{name: "unknown role", role: "root", wantErr: ErrInvalidRole},
+		{name: "unknown scope", role: RoleViewer, extra: []string{"nodes:write"}, wantErr: ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveScopes(tt.role, tt.extra)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveScopes(%q, %v) error = %v, want %v", tt.role, tt.extra, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveScopes(%q, %v) = %v, want %v", tt.role, tt.extra, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{name: "granted", scopes: []string{ScopeUsersRead}, scope: ScopeUsersRead, want: true},
+		{name: "not granted", scopes: []string{ScopeUsersRead}, scope: ScopeUsersWrite},
+		{name: "admin grants everything", scopes: []string{ScopeAdmin}, scope: ScopeConfigRead, want: true},
+		{name: "no scopes", scope: ScopeUsersRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := &Principal{Scopes: tt.scopes}
+			if got := principal.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
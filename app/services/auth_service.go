@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"vpn-service/database"
+	"vpn-service/utils"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrNoSession          = errors.New("caller has no session")
+	ErrAdminNotFound      = errors.New("admin not found")
+	ErrAdminExists        = errors.New("admin username already exists")
+	ErrInvalidAdminName   = errors.New("admin username is required")
+	ErrWeakPassword       = errors.New("password is too short")
+	ErrCreateAdmin        = errors.New("failed to create admin")
+	ErrUpdateAdmin        = errors.New("failed to update admin")
+	ErrDeleteAdmin        = errors.New("failed to delete admin")
+	ErrListAdmins         = errors.New("failed to list admins")
+	ErrCreateSession      = errors.New("failed to create session")
+	ErrRevokeSessions     = errors.New("failed to revoke sessions")
+)
+
+// MinPasswordLength - минимальная длина пароля администратора
+const MinPasswordLength = 8
+
+// BootstrapAdmin - имя, под которым в API виден статический API_BEARER_TOKEN
+const BootstrapAdmin = "bootstrap"
+
+// Principal - аутентифицированный вызывающий API
+type Principal struct {
+	AdminID   uint     `json:"admin_id,omitempty"`
+	Username  string   `json:"username"` // имя администратора или ключа API
+	SessionID uint     `json:"session_id,omitempty"`
+	APIKeyID  uint     `json:"api_key_id,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Scopes    []string `json:"scopes"`
+	// Bootstrap - вход по статическому API_BEARER_TOKEN (супер-администратор без сессии)
+	Bootstrap bool `json:"bootstrap"`
+}
+
+// bootstrapPrincipal - вызывающий со статическим API_BEARER_TOKEN
+func bootstrapPrincipal() *Principal {
+	return &Principal{Username: BootstrapAdmin, Role: RoleAdmin, Scopes: []string{ScopeAdmin}, Bootstrap: true}
+}
+
+type principalKey struct{}
+
+// WithPrincipal сохраняет вызывающего в контексте запроса
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext возвращает вызывающего из контекста запроса (nil - не аутентифицирован)
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// AuthTokens - токены, выданные при входе или обновлении сессии
+type AuthTokens struct {
+	AccessToken      string          `json:"access_token"`
+	TokenType        string          `json:"token_type"`
+	ExpiresAt        time.Time       `json:"expires_at"`
+	RefreshToken     string          `json:"refresh_token"`
+	RefreshExpiresAt time.Time       `json:"refresh_expires_at"`
+	Admin            *database.Admin `json:"admin"`
+}
+
+// accessClaims - содержимое access токена
+type accessClaims struct {
+	AdminID   uint  `json:"aid"`
+	SessionID uint  `json:"sid"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// AuthService аутентифицирует администраторов и ключи API (api_keys.go). Access токен - подписанные HMAC-SHA256
+// claims с коротким сроком действия; refresh токен - случайная строка, в БД хранится ее хэш.
+// Оба проверяются по сессии, поэтому выход и отзыв действуют сразу.
+type AuthService struct {
+	repository  *database.Repository
+	staticToken string
+	secret      []byte
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+	// dummyHash сравнивается с паролем неизвестного администратора,
+	// чтобы время ответа не выдавало существующие имена
+	dummyHash string
+
+	// enabled - кэш Enabled (nil - не вычислен), сбрасывается при создании
+	// и удалении администраторов и ключей API
+	enabled   *bool
+	enabledMu sync.Mutex
+}
+
+// dummyPassword - пароль для dummyHash. Секрет подписи для этого не годится:
+// bcrypt не хэширует строки длиннее 72 байт
+const dummyPassword = "vpn-service-dummy-password"
+
+// LoadAuthSecret возвращает ключ подписи access токенов: AUTH_TOKEN_SECRET, а если он
+// не задан - ключ, сгенерированный при первом запуске и сохраненный в БД, чтобы
+// access токены переживали перезапуск
+func LoadAuthSecret(repo *database.Repository, secret string) (string, error) {
+	if secret != "" {
+		return secret, nil
+	}
+
+	generated, err := utils.GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	stored, err := repo.GetOrCreateSetting(database.SettingAuthTokenSecret, generated)
+	if err != nil {
+		return "", err
+	}
+	if stored == generated {
+		log.Println("AUTH_TOKEN_SECRET is not set, generated a secret and saved it to the database")
+	}
+	return stored, nil
+}
+
+// NewAuthService создает новый экземпляр AuthService. staticToken - API_BEARER_TOKEN
+// (пусто = отключен), secret - ключ подписи access токенов.
+func NewAuthService(repo *database.Repository, staticToken, secret string, accessTTL, refreshTTL time.Duration) *AuthService {
+	dummyHash, err := utils.HashPassword(dummyPassword)
+	if err != nil {
+		log.Printf("Warning: failed to prepare dummy password hash: %v", err)
+	}
+
+	return &AuthService{
+		repository:  repo,
+		staticToken: staticToken,
+		secret:      []byte(secret),
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+		dummyHash:   dummyHash,
+	}
+}
+
+// Enabled проверяет, требуется ли аутентификация: задан статический токен,
+// есть администраторы или ключи API
+func (s *AuthService) Enabled() bool {
+	if s.staticToken != "" {
+		return true
+	}
+
+	s.enabledMu.Lock()
+	defer s.enabledMu.Unlock()
+	if s.enabled != nil {
+		return *s.enabled
+	}
+
+	admins, err := s.repository.CountAdmins()
+	if err != nil {
+		// Не кэшируем: при ошибке БД аутентификация требуется
+		return true
+	}
+	keys, err := s.repository.CountAPIKeys()
+	if err != nil {
+		return true
+	}
+	enabled := admins > 0 || keys > 0
+	s.enabled = &enabled
+	return enabled
+}
+
+// resetEnabled сбрасывает кэш Enabled после создания или удаления администратора или ключа API
+func (s *AuthService) resetEnabled() {
+	s.enabledMu.Lock()
+	defer s.enabledMu.Unlock()
+	s.enabled = nil
+}
+
+// Authenticate проверяет Bearer токен: статический API_BEARER_TOKEN, ключ API
+// или access токен сессии - и возвращает вызывающего с его scopes
+func (s *AuthService) Authenticate(token string) (*Principal, error) {
+	if s.staticToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.staticToken)) == 1 {
+		return bootstrapPrincipal(), nil
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return s.authenticateAPIKey(token)
+	}
+
+	claims, err := s.parseAccessToken(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := s.repository.GetAdminSession(claims.SessionID)
+	if err != nil || session.AdminID != claims.AdminID || !session.Active(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	admin, err := s.repository.GetAdminByID(claims.AdminID)
+	if err != nil || !admin.Enabled {
+		return nil, ErrInvalidToken
+	}
+
+	scopes, err := ResolveScopes(admin.Role, nil)
+	if err != nil {
+		log.Printf("Warning: admin %s: %v", admin.Username, err)
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{AdminID: admin.ID, Username: admin.Username, SessionID: session.ID, Role: admin.Role, Scopes: scopes}, nil
+}
+
+// Login проверяет пароль администратора и открывает новую сессию
+func (s *AuthService) Login(username, password string) (*AuthTokens, error) {
+	admin, err := s.repository.GetAdminByUsername(strings.TrimSpace(username))
+	if err != nil {
+		utils.CheckPassword(password, s.dummyHash)
+		return nil, ErrInvalidCredentials
+	}
+	if !utils.CheckPassword(password, admin.PasswordHash) || !admin.Enabled {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	if err := s.repository.DeleteExpiredAdminSessions(now); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	refreshToken, err := utils.GenerateSecret(32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateSession, err)
+	}
+	session := &database.AdminSession{
+		AdminID:     admin.ID,
+		RefreshHash: hashToken(refreshToken),
+		ExpiresAt:   now.Add(s.refreshTTL),
+	}
+	if err := s.repository.CreateAdminSession(session); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateSession, err)
+	}
+
+	admin.LastLoginAt = &now
+	if err := s.repository.UpdateAdmin(admin); err != nil {
+		log.Printf("Warning: failed to record login of admin %s: %v", admin.Username, err)
+	}
+
+	log.Printf("Admin %s logged in (session %d)", admin.Username, session.ID)
+	return s.issue(admin, session, refreshToken, now)
+}
+
+// Refresh выдает новые токены по refresh токену. Прежний refresh токен перестает действовать.
+func (s *AuthService) Refresh(refreshToken string) (*AuthTokens, error) {
+	oldHash := hashToken(refreshToken)
+	session, err := s.repository.GetAdminSessionByRefreshHash(oldHash)
+	now := time.Now()
+	if err != nil || !session.Active(now) {
+		return nil, ErrInvalidToken
+	}
+	admin, err := s.repository.GetAdminByID(session.AdminID)
+	if err != nil || !admin.Enabled {
+		return nil, ErrInvalidToken
+	}
+
+	nextToken, err := utils.GenerateSecret(32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateSession, err)
+	}
+	session.ExpiresAt = now.Add(s.refreshTTL)
+	ok, err := s.repository.RotateAdminSessionRefresh(session.ID, oldHash, hashToken(nextToken), session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateSession, err)
+	}
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return s.issue(admin, session, nextToken, now)
+}
+
+// Logout отзывает сессию вызывающего
+func (s *AuthService) Logout(principal *Principal) error {
+	if principal == nil || principal.SessionID == 0 {
+		return ErrNoSession
+	}
+	if err := s.repository.RevokeAdminSession(principal.SessionID, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrRevokeSessions, err)
+	}
+	log.Printf("Admin %s logged out (session %d)", principal.Username, principal.SessionID)
+	return nil
+}
+
+// AdminDTO структура для создания и обновления администратора.
+// nil-поля при обновлении не меняются.
+type AdminDTO struct {
+	Username *string
+	Password *string
+	Role     *string
+	Enabled  *bool
+}
+
+// CreateAdmin создает администратора
+func (s *AuthService) CreateAdmin(dto AdminDTO) (*database.Admin, error) {
+	if dto.Username == nil || strings.TrimSpace(*dto.Username) == "" {
+		return nil, ErrInvalidAdminName
+	}
+	username := strings.TrimSpace(*dto.Username)
+	if username == BootstrapAdmin {
+		return nil, ErrAdminExists
+	}
+	if _, err := s.repository.GetAdminByUsername(username); err == nil {
+		return nil, ErrAdminExists
+	}
+	if dto.Password == nil {
+		return nil, ErrWeakPassword
+	}
+
+	admin := &database.Admin{Username: username, Role: RoleAdmin, Enabled: true}
+	if dto.Role != nil {
+		if _, ok := Roles[*dto.Role]; !ok {
+			return nil, ErrInvalidRole
+		}
+		admin.Role = *dto.Role
+	}
+	if dto.Enabled != nil {
+		admin.Enabled = *dto.Enabled
+	}
+	if err := s.setPassword(admin, *dto.Password); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.CreateAdmin(admin); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateAdmin, err)
+	}
+	s.resetEnabled()
+	log.Printf("Admin %s created", admin.Username)
+	return admin, nil
+}
+
+// ListAdmins возвращает список администраторов
+func (s *AuthService) ListAdmins() ([]*database.Admin, error) {
+	admins, err := s.repository.ListAdmins()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListAdmins, err)
+	}
+	return admins, nil
+}
+
+// UpdateAdmin меняет имя, пароль, роль или включает/выключает администратора.
+// После смены пароля или выключения его сессии отзываются.
+func (s *AuthService) UpdateAdmin(id uint, dto AdminDTO) (*database.Admin, error) {
+	admin, err := s.repository.GetAdminByID(id)
+	if err != nil {
+		return nil, ErrAdminNotFound
+	}
+	if dto.Username != nil && strings.TrimSpace(*dto.Username) != admin.Username {
+		username := strings.TrimSpace(*dto.Username)
+		if username == "" {
+			return nil, ErrInvalidAdminName
+		}
+		if _, err := s.repository.GetAdminByUsername(username); err == nil || username == BootstrapAdmin {
+			return nil, ErrAdminExists
+		}
+		admin.Username = username
+	}
+
+	// Роль применяется к действующим сессиям сразу: scopes берутся из БД при каждом запросе
+	if dto.Role != nil {
+		if _, ok := Roles[*dto.Role]; !ok {
+			return nil, ErrInvalidRole
+		}
+		admin.Role = *dto.Role
+	}
+
+	revoke := false
+	if dto.Password != nil {
+		if err := s.setPassword(admin, *dto.Password); err != nil {
+			return nil, err
+		}
+		revoke = true
+	}
+	if dto.Enabled != nil {
+		revoke = revoke || (admin.Enabled && !*dto.Enabled)
+		admin.Enabled = *dto.Enabled
+	}
+
+	if err := s.repository.UpdateAdmin(admin); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpdateAdmin, err)
+	}
+	if revoke {
+		if _, err := s.RevokeSessions(admin.ID); err != nil {
+			return nil, err
+		}
+	}
+	return admin, nil
+}
+
+// DeleteAdmin удаляет администратора и его сессии
+func (s *AuthService) DeleteAdmin(id uint) error {
+	if _, err := s.repository.GetAdminByID(id); err != nil {
+		return ErrAdminNotFound
+	}
+	if err := s.repository.DeleteAdmin(id); err != nil {
+		return fmt.Errorf("%w: %v", ErrDeleteAdmin, err)
+	}
+	s.resetEnabled()
+	return nil
+}
+
+// RevokeSessions отзывает все сессии администратора, возвращает их количество
+func (s *AuthService) RevokeSessions(id uint) (int64, error) {
+	revoked, err := s.repository.RevokeAdminSessions(id, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrRevokeSessions, err)
+	}
+	if revoked > 0 {
+		log.Printf("Revoked %d sessions of admin %d", revoked, id)
+	}
+	return revoked, nil
+}
+
+func (s *AuthService) setPassword(admin *database.Admin, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpdateAdmin, err)
+	}
+	admin.PasswordHash = hash
+	return nil
+}
+
+// issue подписывает access токен сессии
+func (s *AuthService) issue(admin *database.Admin, session *database.AdminSession, refreshToken string, now time.Time) (*AuthTokens, error) {
+	expiresAt := now.Add(s.accessTTL)
+	payload, err := json.Marshal(accessClaims{AdminID: admin.ID, SessionID: session.ID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateSession, err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return &AuthTokens{
+		AccessToken:      encoded + "." + s.sign(encoded),
+		TokenType:        "Bearer",
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		Admin:            admin,
+	}, nil
+}
+
+// parseAccessToken проверяет подпись и срок действия access токена
+func (s *AuthService) parseAccessToken(token string) (*accessClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func (s *AuthService) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashToken возвращает хэш refresh токена для хранения в БД
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"vpn-service/database"
+)
+
+// signedToken подписывает claims так же, как issue
+func signedToken(s *AuthService, payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + s.sign(encoded)
+}
+
+func TestSign(t *testing.T) {
+	s := &AuthService{secret: []byte("secret")}
+	other := &AuthService{secret: []byte("other-secret")}
+
+	if s.sign("payload") != s.sign("payload") {
+		t.Error("sign is not deterministic")
+	}
+	if s.sign("payload") == s.sign("payload2") {
+		t.Error("different payloads have the same signature")
+	}
+	if s.sign("payload") == other.sign("payload") {
+		t.Error("different secrets produce the same signature")
+	}
+}
+
+func TestIssuedAccessTokenParses(t *testing.T) {
+	s := &AuthService{secret: []byte("secret"), accessTTL: time.Minute}
+	tokens, err := s.issue(&database.Admin{ID: 7}, &database.AdminSession{ID: 42}, "refresh", time.Now())
+	if err != nil {
+		t.Fatalf("issue() error = %v", err)
+	}
+
+	claims, err := s.parseAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("parseAccessToken() error = %v", err)
+	}
+	if claims.AdminID != 7 || claims.SessionID != 42 || claims.ExpiresAt != tokens.ExpiresAt.Unix() {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	s := &AuthService{secret: []byte("secret")}
+	other := &AuthService{secret: []byte("other-secret")}
+
+	claims := func(expiresAt time.Time) string {
+		payload, _ := json.Marshal(accessClaims{AdminID: 1, SessionID: 2, ExpiresAt: expiresAt.Unix()})
+		return string(payload)
+	}
+	valid := signedToken(s, claims(time.Now().Add(time.Hour)))
+	encoded, _, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: valid},
+		{name: "expired", token: signedToken(s, claims(time.Now().Add(-time.Second))), wantErr: true},
+		{name: "signed with other secret", token: signedToken(other, claims(time.Now().Add(time.Hour))), wantErr: true},
+		{name: "tampered signature", token: encoded + "." + s.sign(encoded+"x"), wantErr: true},
+		{name: "no signature", token: encoded, wantErr: true},
+		{name: "empty", token: "", wantErr: true},
+		{name: "payload is not base64", token: "!!!." + s.sign("!!!"), wantErr: true},
+		{name: "payload is not json", token: signedToken(s, "not json"), wantErr: true},
+		{name: "static token", token: "vpn_abcdef", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.parseAccessToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAccessToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err != ErrInvalidToken {
+				t.Errorf("parseAccessToken() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
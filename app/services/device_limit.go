@@ -0,0 +1,285 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"vpn-service/database"
+	"vpn-service/monitoring"
+)
+
+// Действия при превышении лимита устройств
+const (
+	DeviceActionLog        = "log"        // только записать в лог
+	DeviceActionSuspend    = "suspend"    // убрать из Xray на время
+	DeviceActionDeactivate = "deactivate" // деактивировать до ручного включения
+)
+
+// IsValidDeviceAction проверяет действие при превышении лимита устройств
+func IsValidDeviceAction(action string) bool {
+	switch action {
+	case DeviceActionLog, DeviceActionSuspend, DeviceActionDeactivate:
+		return true
+	}
+	return false
+}
+
+// UserSession - адрес, с которого пользователь подключен к серверу
+type UserSession struct {
+	IP       string    `json:"ip"`
+	Node     string    `json:"node"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// UserSessions - текущие подключения пользователя со всех серверов
+type UserSessions struct {
+	UserID     uint          `json:"user_id"`
+	Username   string        `json:"username"`
+	MaxDevices int           `json:"max_devices"`
+	Devices    int           `json:"devices"` // различных IP
+	Sessions   []UserSession `json:"sessions"`
+	// Error - серверы, которые не ответили (список может быть неполным)
+	Error string `json:"error,omitempty"`
+}
+
+// DeviceLimitViolation описывает превышение лимита устройств
+type DeviceLimitViolation struct {
+	UserID     uint     `json:"user_id"`
+	Username   string   `json:"username"`
+	MaxDevices int      `json:"max_devices"`
+	IPs        []string `json:"ips"`
+	Action     string   `json:"action"`
+}
+
+// GetUserSessions возвращает IP адреса, с которых пользователь сейчас подключен
+func (s *UserService) GetUserSessions(id uint) (*UserSessions, error) {
+	user, err := s.repository.GetUserByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	sessions, err := s.userSessions([]*database.User{user})
+	result := &UserSessions{
+		UserID:     user.ID,
+		Username:   user.Username,
+		MaxDevices: user.MaxDevices,
+		Sessions:   sessions[user.Username],
+		Devices:    len(distinctIPs(sessions[user.Username])),
+	}
+	if result.Sessions == nil {
+		result.Sessions = []UserSession{}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+// EnforceDeviceLimits находит подключенных пользователей, у которых различных IP
+// больше MaxDevices, и применяет к ним action. suspendFor - срок отключения для suspend.
+func (s *UserService) EnforceDeviceLimits(action string, suspendFor time.Duration) ([]DeviceLimitViolation, error) {
+	users, err := s.repository.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListUsers, err)
+	}
+
+	limited := make([]*database.User, 0)
+	for _, user := range users {
+		if user.MaxDevices > 0 && user.CanConnect() {
+			limited = append(limited, user)
+		}
+	}
+	if len(limited) == 0 {
+		return nil, nil
+	}
+
+	// Ошибки отдельных серверов не мешают проверке по остальным
+	sessions, err := s.userSessions(limited)
+	if err != nil {
+		log.Printf("Device limit: some servers did not report sessions: %v", err)
+	}
+
+	violations := make([]DeviceLimitViolation, 0)
+	for _, user := range limited {
+		ips := distinctIPs(sessions[user.Username])
+		if len(ips) <= user.MaxDevices {
+			continue
+		}
+
+		if err := s.applyDeviceAction(user, action, suspendFor); err != nil {
+			log.Printf("Device limit: failed to %s user %s: %v", action, user.Username, err)
+			continue
+		}
+		violations = append(violations, DeviceLimitViolation{
+			UserID:     user.ID,
+			Username:   user.Username,
+			MaxDevices: user.MaxDevices,
+			IPs:        ips,
+			Action:     action,
+		})
+	}
+
+	return violations, nil
+}
+
+// applyDeviceAction применяет действие к пользователю, превысившему лимит устройств
+func (s *UserService) applyDeviceAction(user *database.User, action string, suspendFor time.Duration) error {
+	switch action {
+	case DeviceActionSuspend:
+		user.SuspendedUntil = time.Now().Add(suspendFor)
+	case DeviceActionDeactivate:
+		user.IsActive = false
+		user.DeactivatedReason = database.DeactivatedDevices
+	default:
+		return nil
+	}
+
+	if err := s.repository.UpdateUser(user); err != nil {
+		return err
+	}
+	// Enforcer вернет пользователя в Xray, когда истечет SuspendedUntil
+	s.applyUserAccess(user)
+	return nil
+}
+
+// userSessions собирает подключения пользователей с локального Xray и нод.
+// Нодам список передается только для одного пользователя, чтобы не упереться в длину URL.
+func (s *UserService) userSessions(users []*database.User) (map[string][]UserSession, error) {
+	emails := make([]string, 0, len(users))
+	wanted := make(map[string]bool, len(users))
+	for _, user := range users {
+		emails = append(emails, user.Username)
+		wanted[user.Username] = true
+	}
+
+	result := make(map[string][]UserSession)
+	var errs []error
+
+	local, err := s.xrayManager.QueryOnlineIPs(emails)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("local: %w", err))
+	}
+	localName := s.xrayConfig.Load().DisplayName
+	if localName == "" {
+		localName = "local"
+	}
+	for email, ips := range local {
+		for _, ip := range ips {
+			result[email] = append(result[email], UserSession{IP: ip.IP, Node: localName, LastSeen: ip.LastSeen})
+		}
+	}
+
+	nodeEmails := emails
+	if len(emails) > 1 {
+		nodeEmails = nil
+	}
+	remote, err := s.nodes.QueryOnlineIPs(nodeEmails)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for email, sessions := range remote {
+		if wanted[email] {
+			result[email] = append(result[email], sessions...)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// distinctIPs возвращает различные IP адреса подключений (один IP на нескольких нодах - одно устройство)
+func distinctIPs(sessions []UserSession) []string {
+	seen := make(map[string]bool, len(sessions))
+	ips := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		if seen[session.IP] {
+			continue
+		}
+		seen[session.IP] = true
+		ips = append(ips, session.IP)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// DeviceLimiter периодически проверяет лимит одновременных устройств пользователей
+type DeviceLimiter struct {
+	userService *UserService
+	metrics     *monitoring.Metrics
+	action      string
+	suspendFor  time.Duration
+	interval    time.Duration
+	stopCh      chan struct{}
+	running     bool
+}
+
+// NewDeviceLimiter создает проверку лимита устройств. Неизвестное action заменяется на log.
+func NewDeviceLimiter(userService *UserService, metrics *monitoring.Metrics, action string, suspendFor, interval time.Duration) *DeviceLimiter {
+	if !IsValidDeviceAction(action) {
+		log.Printf("Warning: unknown device limit action %q, using %s", action, DeviceActionLog)
+		action = DeviceActionLog
+	}
+	return &DeviceLimiter{
+		userService: userService,
+		metrics:     metrics,
+		action:      action,
+		suspendFor:  suspendFor,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+		running:     false,
+	}
+}
+
+// Start запускает периодическую проверку
+func (d *DeviceLimiter) Start() {
+	if d.running {
+		return
+	}
+
+	d.running = true
+
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.scan()
+			case <-d.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Device limiter started (interval: %v, action: %s)", d.interval, d.action)
+}
+
+// Stop останавливает проверку
+func (d *DeviceLimiter) Stop() {
+	if !d.running {
+		return
+	}
+
+	close(d.stopCh)
+	d.running = false
+	log.Println("Device limiter stopped")
+}
+
+// scan выполняет один проход проверки
+func (d *DeviceLimiter) scan() {
+	violations, err := d.userService.EnforceDeviceLimits(d.action, d.suspendFor)
+	if err != nil {
+		log.Printf("Device limit: failed to check users: %v", err)
+		return
+	}
+
+	for _, violation := range violations {
+		log.Printf("Device limit: user %s uses %d IPs (max %d): %v, action: %s",
+			violation.Username, len(violation.IPs), violation.MaxDevices, violation.IPs, violation.Action)
+		if d.metrics != nil {
+			d.metrics.DeviceLimitViolations.WithLabelValues(violation.Action).Inc()
+		}
+	}
+}
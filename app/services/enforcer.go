@@ -0,0 +1,142 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"vpn-service/database"
+	"vpn-service/monitoring"
+)
+
+// Действия над пользователем в Xray
+const (
+	AccessActionAdd    = "add"
+	AccessActionRemove = "remove"
+)
+
+// AccessChange описывает изменение доступа пользователя к Xray
+type AccessChange struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason"`
+}
+
+// EnforceAccess находит пользователей, у которых изменился CanConnect(),
+// и добавляет/удаляет их в работающем Xray
+func (s *UserService) EnforceAccess() ([]AccessChange, error) {
+	users, err := s.repository.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListUsers, err)
+	}
+
+	changes := make([]AccessChange, 0)
+	for _, user := range users {
+		if !s.applyUserAccess(user) {
+			continue
+		}
+
+		change := AccessChange{
+			UserID:   user.ID,
+			Username: user.Username,
+			Action:   AccessActionRemove,
+			Reason:   accessReason(user),
+		}
+		if user.CanConnect() {
+			change.Action = AccessActionAdd
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// accessReason возвращает причину текущего состояния доступа пользователя
+func accessReason(user *database.User) string {
+	switch {
+	case user.CanConnect():
+		return "allowed"
+	case !user.IsActive:
+		return "inactive"
+	case user.IsExpired():
+		return "expired"
+	case user.IsOverLimit():
+		return "over_limit"
+	case user.IsSuspended():
+		return "suspended"
+	default:
+		return "unknown"
+	}
+}
+
+// Enforcer периодически применяет ограничения (срок действия, лимит трафика) к работающему Xray
+type Enforcer struct {
+	userService *UserService
+	metrics     *monitoring.Metrics
+	interval    time.Duration
+	stopCh      chan struct{}
+	running     bool
+}
+
+// NewEnforcer создает новый enforcer
+func NewEnforcer(userService *UserService, metrics *monitoring.Metrics, interval time.Duration) *Enforcer {
+	return &Enforcer{
+		userService: userService,
+		metrics:     metrics,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+		running:     false,
+	}
+}
+
+// Start запускает периодическую проверку
+func (e *Enforcer) Start() {
+	if e.running {
+		return
+	}
+
+	e.running = true
+
+	go func() {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.scan()
+			case <-e.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Access enforcer started (interval: %v)", e.interval)
+}
+
+// Stop останавливает проверку
+func (e *Enforcer) Stop() {
+	if !e.running {
+		return
+	}
+
+	close(e.stopCh)
+	e.running = false
+	log.Println("Access enforcer stopped")
+}
+
+// scan выполняет один проход проверки
+func (e *Enforcer) scan() {
+	changes, err := e.userService.EnforceAccess()
+	if err != nil {
+		log.Printf("Enforcer: failed to check users: %v", err)
+		return
+	}
+
+	for _, change := range changes {
+		log.Printf("Enforcer: %s user %s (reason: %s)", change.Action, change.Username, change.Reason)
+		if e.metrics != nil {
+			e.metrics.AccessChanges.WithLabelValues(change.Action, change.Reason).Inc()
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+// Состояния ноды по результатам проверки
+const (
+	NodeStateUnknown  = "unknown"  // еще не проверялась
+	NodeStateHealthy  = "healthy"  // агент, API Xray и порт доступны
+	NodeStateDegraded = "degraded" // порт доступен, но агент или API Xray нет (синхронизация не работает)
+	NodeStateDown     = "down"     // порт недоступен - нода исключается из подписок
+)
+
+// Пробы для метрики vpn_node_probe_latency_seconds
+const (
+	nodeProbeAgent = "agent"
+	nodeProbePort  = "port"
+)
+
+// NodeHealth - результат последней проверки ноды
+type NodeHealth struct {
+	State     string    `json:"state"`
+	AgentOK   bool      `json:"agent_ok"`    // API агента ответил
+	XrayAPIOK bool      `json:"xray_api_ok"` // Xray ноды ответил по gRPC API
+	PortOK    bool      `json:"port_ok"`     // порт Reality принимает TCP соединения
+	Port      int       `json:"port"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// ChangedAt - время последней смены состояния
+	ChangedAt time.Time `json:"changed_at"`
+	// Failures - число проверок подряд с недоступным портом
+	Failures int `json:"failures"`
+}
+
+// NodeHealthChange описывает смену состояния ноды
+type NodeHealthChange struct {
+	NodeID uint   `json:"node_id"`
+	Node   string `json:"node"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CheckNodesHealth проверяет все включенные ноды: API агента (вместе с API Xray)
+// и порт Reality. Нода становится down после failThreshold неудачных проверок порта подряд.
+func (s *NodeService) CheckNodesHealth(failThreshold int) ([]NodeHealthChange, error) {
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListNodes, err)
+	}
+
+	var (
+		changes []NodeHealthChange
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *database.Node) {
+			defer wg.Done()
+			if change := s.checkNode(node, failThreshold); change != nil {
+				mu.Lock()
+				changes = append(changes, *change)
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	return changes, nil
+}
+
+// checkNode проверяет одну ноду и возвращает смену состояния, если она произошла
+func (s *NodeService) checkNode(node *database.Node, failThreshold int) *NodeHealthChange {
+	health := s.probeNode(node)
+
+	s.statusMu.Lock()
+	previous, ok := s.health[node.ID]
+	from := NodeStateUnknown
+	if ok {
+		from = previous.State
+		health.Failures = previous.Failures
+		health.ChangedAt = previous.ChangedAt
+	}
+
+	if health.PortOK {
+		health.Failures = 0
+	} else {
+		health.Failures++
+	}
+	health.State = nodeState(health, failThreshold)
+	if health.State != from {
+		health.ChangedAt = health.CheckedAt
+	}
+	s.health[node.ID] = health
+	s.statusMu.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.SetNodeHealth(node.Name, health.State)
+	}
+
+	if health.State == from {
+		return nil
+	}
+	if from == NodeStateDown {
+		// Нода вернулась: сразу отдаем ей актуальных пользователей
+		s.Notify()
+	}
+	return &NodeHealthChange{
+		NodeID: node.ID,
+		Node:   node.Name,
+		From:   from,
+		To:     health.State,
+		Error:  health.Error,
+	}
+}
+
+// probeNode опрашивает агента ноды и проверяет TCP порт
+func (s *NodeService) probeNode(node *database.Node) *NodeHealth {
+	health := &NodeHealth{CheckedAt: time.Now()}
+
+	start := time.Now()
+	status, err := s.client(node).Status()
+	s.observeProbe(node, nodeProbeAgent, time.Since(start))
+	var problems []string
+	if err != nil {
+		problems = append(problems, "agent: "+err.Error())
+	} else {
+		health.AgentOK = true
+		health.XrayAPIOK = status.APIOK
+		if !status.APIOK {
+			problems = append(problems, "xray api: "+status.APIError)
+		}
+	}
+
+	health.Port = nodeProbePortNumber(node, s.nodeXrayConfig(node))
+	address := net.JoinHostPort(node.Address, strconv.Itoa(health.Port))
+	start = time.Now()
+	conn, err := net.DialTimeout("tcp", address, s.clientTimeout)
+	s.observeProbe(node, nodeProbePort, time.Since(start))
+	if err != nil {
+		problems = append(problems, "port: "+err.Error())
+	} else {
+		conn.Close()
+		health.PortOK = true
+	}
+
+	health.Error = strings.Join(problems, "; ")
+	return health
+}
+
+func (s *NodeService) observeProbe(node *database.Node, probe string, latency time.Duration) {
+	if s.metrics != nil {
+		s.metrics.NodeProbeLatency.WithLabelValues(node.Name, probe).Set(latency.Seconds())
+	}
+}
+
+// nodeHealth возвращает последнее состояние ноды
+func (s *NodeService) nodeHealth(id uint) NodeHealth {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+
+	if health, ok := s.health[id]; ok {
+		return *health
+	}
+	return NodeHealth{State: NodeStateUnknown}
+}
+
+// forgetNode убирает состояние и метрики ноды (удаление, выключение, переименование)
+func (s *NodeService) forgetNode(id uint, name string) {
+	s.statusMu.Lock()
+	delete(s.status, id)
+	delete(s.health, id)
+	delete(s.seats, id)
+	s.statusMu.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.DeleteNode(name)
+	}
+}
+
+// HealthSummary возвращает состояние включенных нод для /health
+func (s *NodeService) HealthSummary() ([]map[string]interface{}, error) {
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := make([]map[string]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		health := s.nodeHealth(node.ID)
+		entry := map[string]interface{}{
+			"name":   node.Name,
+			"region": node.Region,
+			"state":  health.State,
+		}
+		if !health.CheckedAt.IsZero() {
+			entry["checked_at"] = health.CheckedAt.Format(time.RFC3339)
+		}
+		if health.Error != "" {
+			entry["error"] = health.Error
+		}
+		summary = append(summary, entry)
+	}
+	return summary, nil
+}
+
+// nodeState вычисляет состояние ноды по результатам проб
+func nodeState(health *NodeHealth, failThreshold int) string {
+	switch {
+	case !health.PortOK && health.Failures >= failThreshold:
+		return NodeStateDown
+	case !health.PortOK, !health.AgentOK, !health.XrayAPIOK:
+		return NodeStateDegraded
+	default:
+		return NodeStateHealthy
+	}
+}
+
+// nodeProbePortNumber возвращает порт для проверки: Reality (VLESS),
+// либо первого другого протокола, включенного на ноде
+func nodeProbePortNumber(node *database.Node, cfg *xray.Config) int {
+	switch {
+	case node.AllowsProtocol(database.ProtocolVLESS):
+		return cfg.Port
+	case cfg.TrojanEnabled():
+		return cfg.TrojanPort
+	case cfg.ShadowsocksEnabled():
+		return cfg.ShadowsocksPort
+	case cfg.VMessEnabled():
+		return cfg.VMessPort
+	default:
+		return cfg.Port
+	}
+}
+
+// NodeHealthChecker периодически проверяет ноды кластера
+type NodeHealthChecker struct {
+	nodeService   *NodeService
+	interval      time.Duration
+	failThreshold int
+	stopCh        chan struct{}
+	running       bool
+}
+
+// NewNodeHealthChecker создает проверку нод. failThreshold - сколько неудачных
+// проверок порта подряд нужно, чтобы признать ноду down.
+func NewNodeHealthChecker(nodeService *NodeService, interval time.Duration, failThreshold int) *NodeHealthChecker {
+	if failThreshold < 1 {
+		failThreshold = 1
+	}
+	return &NodeHealthChecker{
+		nodeService:   nodeService,
+		interval:      interval,
+		failThreshold: failThreshold,
+		stopCh:        make(chan struct{}),
+		running:       false,
+	}
+}
+
+// Start запускает периодическую проверку
+func (c *NodeHealthChecker) Start() {
+	if c.running {
+		return
+	}
+
+	c.running = true
+
+	go func() {
+		// Первая проверка сразу, чтобы /health и подписки не ждали интервал
+		c.check()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.check()
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Node health checker started (interval: %v, down after %d failures)", c.interval, c.failThreshold)
+}
+
+// Stop останавливает проверку
+func (c *NodeHealthChecker) Stop() {
+	if !c.running {
+		return
+	}
+
+	close(c.stopCh)
+	c.running = false
+	log.Println("Node health checker stopped")
+}
+
+// check выполняет один проход проверки
+func (c *NodeHealthChecker) check() {
+	changes, err := c.nodeService.CheckNodesHealth(c.failThreshold)
+	if err != nil {
+		log.Printf("Node health: failed to check nodes: %v", err)
+		return
+	}
+
+	for _, change := range changes {
+		if change.Error != "" {
+			log.Printf("Node health: %s %s -> %s (%s)", change.Node, change.From, change.To, change.Error)
+		} else {
+			log.Printf("Node health: %s %s -> %s", change.Node, change.From, change.To)
+		}
+	}
+}
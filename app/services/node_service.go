@@ -0,0 +1,626 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"vpn-service/cluster"
+	"vpn-service/database"
+	"vpn-service/monitoring"
+	"vpn-service/utils"
+	"vpn-service/xray"
+)
+
+var (
+	ErrNodeNotFound   = errors.New("node not found")
+	ErrNodeNameExists = errors.New("node name already exists")
+	ErrInvalidNode    = errors.New("invalid node parameters")
+	ErrCreateNode     = errors.New("failed to create node")
+	ErrUpdateNode     = errors.New("failed to update node")
+	ErrDeleteNode     = errors.New("failed to delete node")
+	ErrListNodes      = errors.New("failed to list nodes")
+	ErrSyncNode       = errors.New("failed to sync node")
+)
+
+// NodeService ведет реестр нод кластера и синхронизирует с ними пользователей.
+// Панель хранит желаемое состояние, агенты нод приводят к нему свой Xray.
+type NodeService struct {
+	repository    *database.Repository
+	xrayConfig    *xray.ConfigStore
+	metrics       *monitoring.Metrics
+	serverIP      string
+	clientTimeout time.Duration
+	agentHTTP     *http.Client // общий для клиентов агентов
+
+	// status - результат последней синхронизации, health - последней проверки нод (по ID)
+	status map[uint]*NodeStatus
+	health map[uint]*NodeHealth
+	// seats - пользователи нод с ограниченной емкостью, принятые последней синхронизацией
+	// (по ID ноды, затем по email): подписки перечисляют ноду только им
+	seats    map[uint]map[string]bool
+	statusMu sync.RWMutex
+
+	trigger chan struct{}
+	stopCh  chan struct{}
+	running bool
+	mu      sync.Mutex
+}
+
+// NewNodeService создает новый экземпляр NodeService.
+// xrayCfg и serverIP описывают собственный Xray панели, agentTLS - TLS к агентам
+// (nil - настройки по умолчанию).
+func NewNodeService(repo *database.Repository, xrayCfg *xray.ConfigStore, metrics *monitoring.Metrics, serverIP string, clientTimeout time.Duration, agentTLS *tls.Config) *NodeService {
+	return &NodeService{
+		repository:    repo,
+		xrayConfig:    xrayCfg,
+		metrics:       metrics,
+		serverIP:      serverIP,
+		clientTimeout: clientTimeout,
+		agentHTTP:     cluster.NewHTTPClient(clientTimeout, agentTLS),
+		status:        make(map[uint]*NodeStatus),
+		health:        make(map[uint]*NodeHealth),
+		seats:         make(map[uint]map[string]bool),
+		trigger:       make(chan struct{}, 1),
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// NodeDTO структура для регистрации и обновления ноды.
+// nil-поля при обновлении не меняются.
+type NodeDTO struct {
+	Name      *string
+	Address   *string
+	AgentURL  *string
+	Token     *string // пусто при создании - сгенерировать
+	Region    *string
+	Capacity  *int
+	Protocols []string
+	IsEnabled *bool
+}
+
+// NodeStatus - состояние синхронизации ноды
+type NodeStatus struct {
+	Synced     bool      `json:"synced"`
+	Users      int       `json:"users"`
+	LastSyncAt time.Time `json:"last_sync_at"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// NodeInfo - нода вместе с состоянием синхронизации и здоровья
+type NodeInfo struct {
+	*database.Node
+	Status NodeStatus `json:"status"`
+	Health NodeHealth `json:"health"`
+}
+
+// NodeCreated - ответ на регистрацию ноды. Токен агента показывается только здесь.
+type NodeCreated struct {
+	*database.Node
+	Token string `json:"token"`
+}
+
+// ProfileEndpoint - сервер, подключения к которому попадают в профиль пользователя
+type ProfileEndpoint struct {
+	Config  *xray.Config
+	Address string
+	// User - копия пользователя только с протоколами, доступными на сервере
+	User *database.User
+}
+
+// CreateNode регистрирует ноду и запускает ее синхронизацию
+func (s *NodeService) CreateNode(dto NodeDTO) (*NodeCreated, error) {
+	node := &database.Node{IsEnabled: true}
+	if err := s.applyDTO(node, dto); err != nil {
+		return nil, err
+	}
+
+	if dto.Token != nil && *dto.Token != "" {
+		node.Token = *dto.Token
+	} else {
+		token, err := utils.GenerateSecret(database.NodeTokenBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCreateNode, err)
+		}
+		node.Token = token
+	}
+
+	if err := s.repository.CreateNode(node); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateNode, err)
+	}
+
+	s.Notify()
+	return &NodeCreated{Node: node, Token: node.Token}, nil
+}
+
+// ListNodes возвращает ноды с состоянием синхронизации
+func (s *NodeService) ListNodes() ([]*NodeInfo, error) {
+	nodes, err := s.repository.ListNodes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListNodes, err)
+	}
+
+	infos := make([]*NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		infos = append(infos, s.nodeInfo(node))
+	}
+	return infos, nil
+}
+
+// GetNode возвращает ноду по ID
+func (s *NodeService) GetNode(id uint) (*NodeInfo, error) {
+	node, err := s.repository.GetNodeByID(id)
+	if err != nil {
+		return nil, ErrNodeNotFound
+	}
+	return s.nodeInfo(node), nil
+}
+
+// UpdateNode обновляет ноду. Выключенная нода освобождается от пользователей.
+func (s *NodeService) UpdateNode(id uint, dto NodeDTO) (*NodeInfo, error) {
+	node, err := s.repository.GetNodeByID(id)
+	if err != nil {
+		return nil, ErrNodeNotFound
+	}
+	wasEnabled := node.IsEnabled
+	previousName := node.Name
+
+	if err := s.applyDTO(node, dto); err != nil {
+		return nil, err
+	}
+	if dto.Token != nil && *dto.Token != "" {
+		node.Token = *dto.Token
+	}
+
+	if err := s.repository.UpdateNode(node); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpdateNode, err)
+	}
+
+	if wasEnabled && !node.IsEnabled {
+		s.releaseNode(node)
+	}
+	if !node.IsEnabled || node.Name != previousName {
+		s.forgetNode(node.ID, previousName)
+	}
+	s.Notify()
+	return s.nodeInfo(node), nil
+}
+
+// DeleteNode удаляет ноду из реестра, предварительно убрав с нее пользователей
+func (s *NodeService) DeleteNode(id uint) error {
+	node, err := s.repository.GetNodeByID(id)
+	if err != nil {
+		return ErrNodeNotFound
+	}
+
+	if node.IsEnabled {
+		s.releaseNode(node)
+	}
+
+	if err := s.repository.DeleteNode(id); err != nil {
+		return fmt.Errorf("%w: %v", ErrDeleteNode, err)
+	}
+
+	s.forgetNode(id, node.Name)
+	return nil
+}
+
+// SyncNode синхронизирует ноду немедленно и возвращает результат
+func (s *NodeService) SyncNode(id uint) (*NodeInfo, error) {
+	node, err := s.repository.GetNodeByID(id)
+	if err != nil {
+		return nil, ErrNodeNotFound
+	}
+	if !node.IsEnabled {
+		return nil, ErrInvalidNode
+	}
+
+	users, err := s.repository.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSyncNode, err)
+	}
+
+	if err := s.syncNode(node, users); err != nil {
+		return s.nodeInfo(node), fmt.Errorf("%w: %v", ErrSyncNode, err)
+	}
+	return s.nodeInfo(node), nil
+}
+
+// Notify запрашивает внеочередную синхронизацию всех нод (после изменения пользователей)
+func (s *NodeService) Notify() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start запускает фоновую синхронизацию: по интервалу и по Notify
+func (s *NodeService) Start(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+
+	go func() {
+		// Первая синхронизация сразу: агенты после рестарта ждут состояние от панели
+		s.syncAll()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.syncAll()
+			case <-s.trigger:
+				s.syncAll()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Node sync started (interval: %v)", interval)
+}
+
+// Stop останавливает фоновую синхронизацию
+func (s *NodeService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return
+	}
+	close(s.stopCh)
+	s.running = false
+	log.Println("Node sync stopped")
+}
+
+// QueryUserTraffic собирает счетчики трафика со всех включенных нод.
+// Реализует monitoring.TrafficSource: ошибки отдельных нод не мешают учету остальных.
+func (s *NodeService) QueryUserTraffic(reset bool) (map[string]*xray.UserTraffic, error) {
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*xray.UserTraffic)
+	var errs []error
+	for _, node := range nodes {
+		traffic, err := s.client(node).QueryUserTraffic(reset)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.Name, err))
+			continue
+		}
+		if reset && s.metrics != nil {
+			s.metrics.AddNodeTraffic(node.Name, traffic)
+		}
+
+		for email, entry := range traffic {
+			total, ok := result[email]
+			if !ok {
+				total = &xray.UserTraffic{Email: email}
+				result[email] = total
+			}
+			total.Uplink += entry.Uplink
+			total.Downlink += entry.Downlink
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// QueryOnlineIPs собирает IP адреса подключенных пользователей со всех включенных нод.
+// Пустой список emails - все пользователи нод. Результат сгруппирован по email.
+func (s *NodeService) QueryOnlineIPs(emails []string) (map[string][]UserSession, error) {
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]UserSession)
+	var errs []error
+	for _, node := range nodes {
+		online, err := s.client(node).QueryOnlineIPs(emails)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.Name, err))
+			continue
+		}
+		for email, ips := range online {
+			for _, ip := range ips {
+				result[email] = append(result[email], UserSession{IP: ip.IP, Node: node.Name, LastSeen: ip.LastSeen})
+			}
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// Endpoints возвращает серверы, доступные пользователю: собственный Xray панели
+// и включенные ноды, на которых есть хотя бы один протокол пользователя.
+// Ноды в состоянии down пропускаются до восстановления, заполненные - для
+// пользователей, которым на них не хватило места.
+func (s *NodeService) Endpoints(user *database.User) ([]ProfileEndpoint, error) {
+	endpoints := []ProfileEndpoint{{
+		Config:  s.xrayConfig.Load(),
+		Address: s.serverIP,
+		User:    user,
+	}}
+
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListNodes, err)
+	}
+
+	for _, node := range nodes {
+		if s.nodeHealth(node.ID).State == NodeStateDown || !s.hasSeat(node, user.Username) {
+			continue
+		}
+		cfg := s.nodeXrayConfig(node)
+		nodeUser := nodeUserView(user, node)
+		if !hasEnabledProtocol(nodeUser, cfg) {
+			continue
+		}
+		endpoints = append(endpoints, ProfileEndpoint{
+			Config:  cfg,
+			Address: node.Address,
+			User:    nodeUser,
+		})
+	}
+
+	return endpoints, nil
+}
+
+// syncAll синхронизирует все включенные ноды параллельно
+func (s *NodeService) syncAll() {
+	nodes, err := s.repository.ListEnabledNodes()
+	if err != nil {
+		log.Printf("Failed to list nodes: %v", err)
+		return
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	users, err := s.repository.ListUsers()
+	if err != nil {
+		log.Printf("Failed to list users for node sync: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *database.Node) {
+			defer wg.Done()
+			if err := s.syncNode(node, users); err != nil {
+				log.Printf("Failed to sync node %s: %v", node.Name, err)
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+// syncNode отправляет ноде желаемое состояние и запоминает результат
+func (s *NodeService) syncNode(node *database.Node, users []*database.User) error {
+	state := s.nodeState(node, users)
+	result, err := s.client(node).ApplyState(state)
+
+	status := &NodeStatus{LastSyncAt: time.Now()}
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.Synced = true
+		status.Users = result.Users
+		if result.Restarted {
+			log.Printf("Node %s: Xray restarted with %d users", node.Name, result.Users)
+		} else if result.Added > 0 || result.Removed > 0 {
+			log.Printf("Node %s: +%d/-%d users (total: %d)", node.Name, result.Added, result.Removed, result.Users)
+		}
+		if s.metrics != nil {
+			s.metrics.NodeUsers.WithLabelValues(node.Name).Set(float64(result.Users))
+		}
+	}
+
+	s.statusMu.Lock()
+	s.status[node.ID] = status
+	if err == nil {
+		if node.Capacity > 0 {
+			seats := make(map[string]bool, len(state.Users))
+			for _, user := range state.Users {
+				seats[user.Username] = true
+			}
+			s.seats[node.ID] = seats
+		} else {
+			delete(s.seats, node.ID)
+		}
+	}
+	s.statusMu.Unlock()
+
+	return err
+}
+
+// releaseNode убирает с ноды всех пользователей (best effort)
+func (s *NodeService) releaseNode(node *database.Node) {
+	if _, err := s.client(node).ApplyState(s.nodeState(node, nil)); err != nil {
+		log.Printf("Warning: failed to release node %s: %v", node.Name, err)
+	}
+}
+
+// nodeState формирует желаемое состояние ноды: конфигурацию и пользователей с доступом
+func (s *NodeService) nodeState(node *database.Node, users []*database.User) *cluster.State {
+	cfg := s.nodeXrayConfig(node)
+	state := &cluster.State{
+		Config: cfg,
+		Users:  make([]cluster.NodeUser, 0, len(users)),
+	}
+
+	eligible := make([]*database.User, 0, len(users))
+	for _, user := range users {
+		if !user.CanConnect() {
+			continue
+		}
+		nodeUser := nodeUserView(user, node)
+		if !hasEnabledProtocol(nodeUser, cfg) {
+			continue
+		}
+		eligible = append(eligible, nodeUser)
+	}
+
+	if node.Capacity > 0 && len(eligible) > node.Capacity {
+		// Места на заполненной ноде остаются за пользователями, созданными раньше,
+		// чтобы новые пользователи не вытесняли подключенных
+		log.Printf("Warning: node %s is full (capacity %d), %d users are not assigned to it",
+			node.Name, node.Capacity, len(eligible)-node.Capacity)
+		sort.Slice(eligible, func(i, j int) bool { return eligible[i].ID < eligible[j].ID })
+		eligible = eligible[:node.Capacity]
+	}
+
+	for _, user := range eligible {
+		state.Users = append(state.Users, cluster.NewNodeUser(user))
+	}
+	return state
+}
+
+// hasSeat проверяет, назначен ли пользователь на ноду с ограниченной емкостью
+func (s *NodeService) hasSeat(node *database.Node, email string) bool {
+	if node.Capacity <= 0 {
+		return true
+	}
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.seats[node.ID][email]
+}
+
+// nodeXrayConfig строит конфигурацию Xray ноды из конфигурации панели:
+// протоколы, которых нет на ноде, выключаются, TLS выдается на адрес ноды
+func (s *NodeService) nodeXrayConfig(node *database.Node) *xray.Config {
+	panel := s.xrayConfig.Load()
+	cfg := *panel
+	cfg.DisplayName = node.Name
+
+	if !node.AllowsProtocol(database.ProtocolShadowsocks) {
+		cfg.ShadowsocksPort = 0
+	}
+	if !node.AllowsProtocol(database.ProtocolTrojan) {
+		cfg.TrojanPort = 0
+	}
+	if !node.AllowsProtocol(database.ProtocolVMess) {
+		cfg.VMessPort = 0
+	}
+	if nodeNeedsTLS(node, panel) {
+		cfg.TLSDomain = node.Address
+	}
+	return &cfg
+}
+
+// applyDTO валидирует и переносит поля DTO в ноду
+func (s *NodeService) applyDTO(node *database.Node, dto NodeDTO) error {
+	if dto.Name != nil {
+		if *dto.Name == "" {
+			return ErrInvalidNode
+		}
+		if existing, err := s.repository.GetNodeByName(*dto.Name); err == nil && existing.ID != node.ID {
+			return ErrNodeNameExists
+		}
+		node.Name = *dto.Name
+	}
+
+	if dto.Address != nil {
+		node.Address = *dto.Address
+	}
+
+	if dto.AgentURL != nil {
+		parsed, err := url.Parse(*dto.AgentURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return ErrInvalidNode
+		}
+		node.AgentURL = *dto.AgentURL
+	}
+
+	if dto.Region != nil {
+		node.Region = *dto.Region
+	}
+
+	if dto.Capacity != nil {
+		if *dto.Capacity < 0 {
+			return ErrInvalidNode
+		}
+		node.Capacity = *dto.Capacity
+	}
+
+	if dto.Protocols != nil {
+		for _, protocol := range dto.Protocols {
+			if !database.IsKnownProtocol(protocol) {
+				return ErrInvalidNode
+			}
+		}
+		node.Protocols = dto.Protocols
+	}
+
+	if dto.IsEnabled != nil {
+		node.IsEnabled = *dto.IsEnabled
+	}
+
+	if node.Name == "" || node.Address == "" || node.AgentURL == "" {
+		return ErrInvalidNode
+	}
+
+	// Сертификат TLS выпускается на домен, IP-адрес ноды для TLS не подходит
+	if nodeNeedsTLS(node, s.xrayConfig.Load()) && net.ParseIP(node.Address) != nil {
+		return ErrInvalidNode
+	}
+
+	return nil
+}
+
+func (s *NodeService) client(node *database.Node) *cluster.Client {
+	return cluster.NewClient(node.AgentURL, node.Token, s.agentHTTP)
+}
+
+func (s *NodeService) nodeInfo(node *database.Node) *NodeInfo {
+	info := &NodeInfo{Node: node}
+
+	s.statusMu.RLock()
+	if status, ok := s.status[node.ID]; ok {
+		info.Status = *status
+	}
+	s.statusMu.RUnlock()
+
+	info.Health = s.nodeHealth(node.ID)
+	return info
+}
+
+// nodeNeedsTLS проверяет, работают ли на ноде протоколы с TLS вместо Reality
+func nodeNeedsTLS(node *database.Node, cfg *xray.Config) bool {
+	if cfg.VMessEnabled() && node.AllowsProtocol(database.ProtocolVMess) {
+		return true
+	}
+	return !cfg.VlessUsesReality() && node.AllowsProtocol(database.ProtocolVLESS)
+}
+
+// nodeUserView возвращает копию пользователя только с протоколами, разрешенными на ноде
+func nodeUserView(user *database.User, node *database.Node) *database.User {
+	view := *user
+	for _, protocol := range database.KnownProtocols {
+		view.SetProtocolEnabled(protocol, user.ProtocolEnabled(protocol) && node.AllowsProtocol(protocol))
+	}
+	return &view
+}
+
+// hasEnabledProtocol проверяет, есть ли у пользователя протокол, включенный на сервере
+func hasEnabledProtocol(user *database.User, cfg *xray.Config) bool {
+	return user.VlessEnabled ||
+		(cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled) ||
+		(cfg.TrojanEnabled() && user.TrojanEnabled) ||
+		(cfg.VMessEnabled() && user.VmessEnabled)
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+var (
+	ErrPlanNotFound    = errors.New("plan not found")
+	ErrPlanNameExists  = errors.New("plan name already exists")
+	ErrInvalidPlanName = errors.New("plan name is required")
+	ErrInvalidPlan     = errors.New("invalid plan parameters")
+	ErrPlanInUse       = errors.New("plan is assigned to users")
+	ErrNoPlan          = errors.New("user has no plan")
+	ErrPlanConflict    = errors.New("plan_id and renew_plan are mutually exclusive")
+	ErrCreatePlan      = errors.New("failed to create plan")
+	ErrUpdatePlan      = errors.New("failed to update plan")
+	ErrDeletePlan      = errors.New("failed to delete plan")
+	ErrListPlans       = errors.New("failed to list plans")
+)
+
+// PlanService содержит бизнес-логику для работы с тарифными планами
+type PlanService struct {
+	repository *database.Repository
+	xrayConfig *xray.ConfigStore
+}
+
+// NewPlanService создает новый экземпляр PlanService
+func NewPlanService(repo *database.Repository, xrayCfg *xray.ConfigStore) *PlanService {
+	return &PlanService{
+		repository: repo,
+		xrayConfig: xrayCfg,
+	}
+}
+
+// PlanDTO структура для создания и обновления плана.
+// nil-поля при обновлении не меняются.
+type PlanDTO struct {
+	Name         *string
+	DurationDays *int
+	TrafficLimit *int64
+	DeviceLimit  *int
+	SpeedLimit   *int64
+	Protocols    []string
+	Flow         *string
+}
+
+// CreatePlan создает новый тарифный план
+func (s *PlanService) CreatePlan(dto PlanDTO) (*database.Plan, error) {
+	plan := &database.Plan{}
+	if err := s.applyDTO(plan, dto); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.CreatePlan(plan); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreatePlan, err)
+	}
+
+	return plan, nil
+}
+
+// ListPlans возвращает список планов
+func (s *PlanService) ListPlans() ([]*database.Plan, error) {
+	plans, err := s.repository.ListPlans()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListPlans, err)
+	}
+	return plans, nil
+}
+
+// GetPlan возвращает план по ID
+func (s *PlanService) GetPlan(id uint) (*database.Plan, error) {
+	plan, err := s.repository.GetPlanByID(id)
+	if err != nil {
+		return nil, ErrPlanNotFound
+	}
+	return plan, nil
+}
+
+// UpdatePlan обновляет план. Пользователи, уже назначенные на план,
+// получают новые параметры при следующем назначении или продлении.
+func (s *PlanService) UpdatePlan(id uint, dto PlanDTO) (*database.Plan, error) {
+	plan, err := s.repository.GetPlanByID(id)
+	if err != nil {
+		return nil, ErrPlanNotFound
+	}
+
+	if err := s.applyDTO(plan, dto); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.UpdatePlan(plan); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpdatePlan, err)
+	}
+
+	return plan, nil
+}
+
+// DeletePlan удаляет план, если на нем нет пользователей
+func (s *PlanService) DeletePlan(id uint) error {
+	if _, err := s.repository.GetPlanByID(id); err != nil {
+		return ErrPlanNotFound
+	}
+
+	count, err := s.repository.CountUsersWithPlan(id)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDeletePlan, err)
+	}
+	if count > 0 {
+		return ErrPlanInUse
+	}
+
+	if err := s.repository.DeletePlan(id); err != nil {
+		return fmt.Errorf("%w: %v", ErrDeletePlan, err)
+	}
+	return nil
+}
+
+// applyDTO валидирует и переносит поля DTO в план
+func (s *PlanService) applyDTO(plan *database.Plan, dto PlanDTO) error {
+	if dto.Name != nil {
+		if *dto.Name == "" {
+			return ErrInvalidPlanName
+		}
+		if existing, err := s.repository.GetPlanByName(*dto.Name); err == nil && existing.ID != plan.ID {
+			return ErrPlanNameExists
+		}
+		plan.Name = *dto.Name
+	}
+	if plan.Name == "" {
+		return ErrInvalidPlanName
+	}
+
+	if dto.DurationDays != nil {
+		if *dto.DurationDays < 0 {
+			return ErrInvalidPlan
+		}
+		plan.DurationDays = *dto.DurationDays
+	}
+
+	if dto.TrafficLimit != nil {
+		if *dto.TrafficLimit < 0 {
+			return ErrInvalidPlan
+		}
+		plan.TrafficLimit = *dto.TrafficLimit
+	}
+
+	if dto.DeviceLimit != nil {
+		if *dto.DeviceLimit < 0 {
+			return ErrInvalidPlan
+		}
+		plan.DeviceLimit = *dto.DeviceLimit
+	}
+
+	if dto.SpeedLimit != nil {
+		if *dto.SpeedLimit < 0 {
+			return ErrInvalidPlan
+		}
+		plan.SpeedLimit = *dto.SpeedLimit
+	}
+
+	if dto.Protocols != nil {
+		for _, protocol := range dto.Protocols {
+			if !database.IsKnownProtocol(protocol) {
+				return ErrInvalidPlan
+			}
+		}
+		plan.Protocols = dto.Protocols
+	}
+
+	if dto.Flow != nil {
+		if err := xray.ValidateFlow(*dto.Flow, s.xrayConfig.Load()); err != nil {
+			return ErrInvalidPlan
+		}
+		plan.Flow = *dto.Flow
+	}
+
+	return nil
+}
+
+// assignPlan назначает план пользователю: лимиты, протоколы и flow берутся из плана,
+// срок действия отсчитывается от now
+func assignPlan(user *database.User, plan *database.Plan, now time.Time) {
+	planID := plan.ID
+	user.PlanID = &planID
+	user.TrafficLimit = plan.TrafficLimit
+	user.Flow = plan.Flow
+	user.MaxDevices = plan.DeviceLimit
+	user.SpeedLimitUp = plan.SpeedLimit
+	user.SpeedLimitDown = plan.SpeedLimit
+
+	for _, protocol := range database.KnownProtocols {
+		user.SetProtocolEnabled(protocol, plan.AllowsProtocol(protocol))
+	}
+
+	if plan.DurationDays > 0 {
+		user.ExpiresAt = now.Add(plan.Duration())
+	} else {
+		user.ExpiresAt = time.Time{}
+	}
+}
+
+// renewPlan продлевает срок действия на длительность плана.
+// Если подписка уже истекла, новый срок считается от now.
+func renewPlan(user *database.User, plan *database.Plan, now time.Time) {
+	if plan.DurationDays == 0 {
+		user.ExpiresAt = time.Time{}
+		return
+	}
+
+	base := user.ExpiresAt
+	if base.IsZero() || base.Before(now) {
+		base = now
+	}
+	user.ExpiresAt = base.Add(plan.Duration())
+}
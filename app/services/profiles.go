@@ -0,0 +1,103 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+// endpointLinks возвращает ссылки всех разрешенных протоколов на всех серверах в base64 (формат v2rayN)
+func endpointLinks(endpoints []ProfileEndpoint) (string, error) {
+	links := make([]string, 0, len(endpoints)*len(database.KnownProtocols))
+	for _, endpoint := range endpoints {
+		endpointLinks, err := protocolLinks(endpoint)
+		if err != nil {
+			return "", err
+		}
+		links = append(links, endpointLinks...)
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))), nil
+}
+
+// protocolLinks возвращает ссылки разрешенных протоколов одного сервера
+func protocolLinks(endpoint ProfileEndpoint) ([]string, error) {
+	user, cfg, address := endpoint.User, endpoint.Config, endpoint.Address
+	links := make([]string, 0, len(database.KnownProtocols))
+
+	if user.VlessEnabled {
+		uri, err := xray.GenerateVlessURI(user, cfg, address)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, uri)
+	}
+
+	if cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled {
+		uri, err := xray.GenerateShadowsocksURI(user, cfg, address)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, uri)
+	}
+
+	if cfg.TrojanEnabled() && user.TrojanEnabled {
+		uri, err := xray.GenerateTrojanURI(user, cfg, address)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, uri)
+	}
+
+	if cfg.VMessEnabled() && user.VmessEnabled {
+		uri, err := xray.GenerateVMessURI(user, cfg)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, uri)
+	}
+
+	return links, nil
+}
+
+// clashProfile собирает профиль Clash/Mihomo с прокси всех серверов.
+// Правила и DNS берутся из конфигурации панели.
+func clashProfile(endpoints []ProfileEndpoint, cfg *xray.Config) (string, error) {
+	proxies := make([]map[string]interface{}, 0)
+	for _, endpoint := range endpoints {
+		endpointProxies, err := xray.ClashProxies(endpoint.User, endpoint.Config, endpoint.Address)
+		if err != nil {
+			return "", err
+		}
+		proxies = append(proxies, endpointProxies...)
+	}
+
+	return xray.MarshalClashProfile(xray.BuildClashProfile(proxies, cfg))
+}
+
+// singBoxProfile собирает профиль sing-box с outbound всех серверов.
+// Серверы без совместимых с sing-box протоколов пропускаются.
+func singBoxProfile(endpoints []ProfileEndpoint) (string, error) {
+	outbounds := make([]map[string]interface{}, 0)
+	var lastErr error
+	for _, endpoint := range endpoints {
+		endpointOutbounds, err := xray.SingBoxOutbounds(endpoint.User, endpoint.Config, endpoint.Address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		outbounds = append(outbounds, endpointOutbounds...)
+	}
+	if len(outbounds) == 0 {
+		return "", lastErr
+	}
+
+	jsonBytes, err := json.MarshalIndent(xray.BuildSingBoxProfile(outbounds), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box config: %w", err)
+	}
+	return string(jsonBytes), nil
+}
@@ -0,0 +1,41 @@
+package services
+
+import "vpn-service/database"
+
+// validateProtocols проверяет, что в флагах только известные протоколы
+func validateProtocols(flags map[string]bool) error {
+	for protocol := range flags {
+		if !database.IsKnownProtocol(protocol) {
+			return ErrInvalidProtocol
+		}
+	}
+	return nil
+}
+
+// enableAllProtocols разрешает пользователю все протоколы
+func enableAllProtocols(user *database.User) {
+	for _, protocol := range database.KnownProtocols {
+		user.SetProtocolEnabled(protocol, true)
+	}
+}
+
+// applyProtocolFlags применяет явно переданные флаги протоколов
+func applyProtocolFlags(user *database.User, flags map[string]bool) {
+	for protocol, enabled := range flags {
+		user.SetProtocolEnabled(protocol, enabled)
+	}
+}
+
+// xrayAccountChanged проверяет, изменились ли параметры учетных записей пользователя
+// в Xray (набор протоколов или flow) - тогда его нужно переподключить к inbound
+func xrayAccountChanged(before, after *database.User) bool {
+	if before.Flow != after.Flow {
+		return true
+	}
+	for _, protocol := range database.KnownProtocols {
+		if before.ProtocolEnabled(protocol) != after.ProtocolEnabled(protocol) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"vpn-service/database"
+)
+
+// QuotaReset описывает сброс квоты пользователя на границе периода
+type QuotaReset struct {
+	UserID        uint      `json:"user_id"`
+	Username      string    `json:"username"`
+	PeriodStart   time.Time `json:"period_start"`
+	RolloverBytes int64     `json:"rollover_bytes"`
+	Reactivated   bool      `json:"reactivated"`
+}
+
+// startQuotaPeriod выставляет начало текущего периода квоты без архивации
+func startQuotaPeriod(user *database.User, now time.Time) {
+	user.RolloverBytes = 0
+	if user.QuotaPeriod == "" {
+		user.PeriodStart = time.Time{}
+		return
+	}
+	if user.QuotaAnchor.IsZero() {
+		user.QuotaAnchor = now
+	}
+	user.PeriodStart = user.CurrentPeriodStart(now)
+}
+
+// ResetQuotaPeriods закрывает истекшие периоды квоты: архивирует использованный
+// трафик, обнуляет счетчики, переносит остаток и возвращает доступ пользователям,
+// отключенным только за превышение лимита
+func (s *UserService) ResetQuotaPeriods(now time.Time) ([]QuotaReset, error) {
+	users, err := s.repository.ListUsersWithQuotaPeriod()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListUsers, err)
+	}
+
+	resets := make([]QuotaReset, 0)
+	for _, user := range users {
+		start := user.CurrentPeriodStart(now)
+		if !user.PeriodStart.Before(start) {
+			continue
+		}
+
+		dto := database.QuotaRolloverDTO{NextStart: start}
+
+		// Период еще не был инициализирован - архивировать нечего
+		if !user.PeriodStart.IsZero() {
+			dto.Period = &database.TrafficPeriod{
+				UserID:      user.ID,
+				PeriodStart: user.PeriodStart,
+				PeriodEnd:   start,
+				Upload:      user.TrafficUp,
+				Download:    user.TrafficDown,
+				Counted:     user.CountedTraffic(),
+				Limit:       user.EffectiveTrafficLimit(),
+			}
+
+			if user.QuotaRollover && user.TrafficLimit > 0 {
+				// Переносим не больше одного базового лимита, чтобы остаток не копился бесконечно
+				unused := user.EffectiveTrafficLimit() - user.CountedTraffic()
+				if unused > user.TrafficLimit {
+					unused = user.TrafficLimit
+				}
+				if unused > 0 {
+					dto.RolloverBytes = unused
+				}
+			}
+
+			dto.Reactivate = !user.IsActive && user.DeactivatedReason == database.DeactivatedOverLimit
+		}
+
+		if err := s.repository.CloseTrafficPeriod(user.ID, dto); err != nil {
+			log.Printf("Failed to reset quota for user %s: %v", user.Username, err)
+			continue
+		}
+
+		if dto.Period != nil {
+			resets = append(resets, QuotaReset{
+				UserID:        user.ID,
+				Username:      user.Username,
+				PeriodStart:   start,
+				RolloverBytes: dto.RolloverBytes,
+				Reactivated:   dto.Reactivate,
+			})
+		}
+
+		// Возвращаем пользователя в Xray, если он снова может подключаться
+		updated, err := s.repository.GetUserByID(user.ID)
+		if err != nil {
+			log.Printf("Failed to reload user %s after quota reset: %v", user.Username, err)
+			continue
+		}
+		s.applyUserAccess(updated)
+	}
+
+	return resets, nil
+}
+
+// QuotaScheduler периодически сбрасывает квоты на границах периодов
+type QuotaScheduler struct {
+	userService *UserService
+	interval    time.Duration
+	stopCh      chan struct{}
+	running     bool
+}
+
+// NewQuotaScheduler создает новый планировщик сброса квот
+func NewQuotaScheduler(userService *UserService, interval time.Duration) *QuotaScheduler {
+	return &QuotaScheduler{
+		userService: userService,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+		running:     false,
+	}
+}
+
+// Start запускает планировщик
+func (q *QuotaScheduler) Start() {
+	if q.running {
+		return
+	}
+
+	q.running = true
+
+	go func() {
+		// Первая проверка сразу - границы могли пройти, пока сервис был остановлен
+		q.run()
+
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				q.run()
+			case <-q.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Quota scheduler started (interval: %v)", q.interval)
+}
+
+// Stop останавливает планировщик
+func (q *QuotaScheduler) Stop() {
+	if !q.running {
+		return
+	}
+
+	close(q.stopCh)
+	q.running = false
+	log.Println("Quota scheduler stopped")
+}
+
+func (q *QuotaScheduler) run() {
+	resets, err := q.userService.ResetQuotaPeriods(time.Now())
+	if err != nil {
+		log.Printf("Quota scheduler: %v", err)
+		return
+	}
+
+	for _, reset := range resets {
+		log.Printf("Quota reset for user %s (new period: %s, rollover: %d bytes, reactivated: %v)",
+			reset.Username, reset.PeriodStart.Format(time.RFC3339), reset.RolloverBytes, reset.Reactivated)
+	}
+}
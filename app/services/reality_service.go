@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+var (
+	ErrInvalidOverlap = errors.New("overlap must be positive")
+	ErrRotateKeys     = errors.New("failed to rotate reality keys")
+	// ErrRealityKeyMismatch - публичный ключ Reality не соответствует приватному
+	ErrRealityKeyMismatch = errors.New("reality public key mismatch")
+)
+
+// RealityKeyStatus - текущие ключи Reality
+type RealityKeyStatus struct {
+	PublicKey         string     `json:"public_key"`
+	PreviousPublicKey string     `json:"previous_public_key,omitempty"`
+	PreviousRetiresAt *time.Time `json:"previous_retires_at,omitempty"` // до этого момента принимается прежний ключ
+}
+
+// LoadRealityKeys выбирает ключи Reality до запуска Xray. Ключ из БД (после ротации
+// или генерации) важнее XRAY_PRIVATE_KEY; если ключа нет ни там, ни там, он генерируется
+// и сохраняется в БД. Публичный ключ вычисляется по приватному: заданный
+// XRAY_PUBLIC_KEY, который не совпадает с вычисленным, - ошибка. XRAY_PRIVATE_KEY,
+// отличный от ключа в БД, игнорируется с предупреждением.
+func LoadRealityKeys(repo *database.Repository, cfg *xray.Config) error {
+	if _, err := repo.RetireRealityKeys(time.Now()); err != nil {
+		return err
+	}
+
+	active, err := repo.GetRealityKey(database.RealityKeyActive)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case active != nil:
+		if cfg.RealityPrivateKey != "" && cfg.RealityPrivateKey != active.PrivateKey {
+			log.Printf("Warning: XRAY_PRIVATE_KEY differs from the active Reality key in the database "+
+				"(public key %s, rotated or generated earlier); using the database key, remove or update XRAY_PRIVATE_KEY",
+				active.PublicKey)
+		}
+		cfg.RealityPrivateKey = active.PrivateKey
+		cfg.RealityPublicKey = ""
+	case cfg.RealityPrivateKey == "":
+		privateKey, publicKey, err := xray.GenerateRealityKeyPair()
+		if err != nil {
+			return err
+		}
+		key := &database.RealityKey{PrivateKey: privateKey, PublicKey: publicKey, State: database.RealityKeyActive}
+		if err := repo.CreateRealityKey(key); err != nil {
+			return err
+		}
+		cfg.RealityPrivateKey = privateKey
+		log.Printf("Generated Reality key pair, public key: %s", publicKey)
+	}
+
+	publicKey, err := xray.RealityPublicKey(cfg.RealityPrivateKey)
+	if err != nil {
+		return err
+	}
+	if cfg.RealityPublicKey != "" && cfg.RealityPublicKey != publicKey {
+		return fmt.Errorf("%w: XRAY_PUBLIC_KEY does not match XRAY_PRIVATE_KEY (expected %s)", ErrRealityKeyMismatch, publicKey)
+	}
+	cfg.RealityPublicKey = publicKey
+
+	previous, err := repo.GetRealityKey(database.RealityKeyPrevious)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		cfg.RealityPreviousPrivateKey = previous.PrivateKey
+		log.Printf("Reality key rotation in progress, previous key accepted until %s", previous.RetiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RealityService ротирует ключи Reality. Во время перекрытия Xray принимает и прежний ключ,
+// поэтому клиенты со старыми подписками работают, пока не обновят их.
+type RealityService struct {
+	repository  *database.Repository
+	xrayConfig  *xray.ConfigStore
+	userService *UserService
+	mu          sync.Mutex
+
+	stopCh  chan struct{}
+	running bool
+}
+
+// NewRealityService создает новый экземпляр RealityService
+func NewRealityService(repo *database.Repository, xrayCfg *xray.ConfigStore, userService *UserService) *RealityService {
+	return &RealityService{
+		repository:  repo,
+		xrayConfig:  xrayCfg,
+		userService: userService,
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// KeyStatus возвращает текущие ключи Reality
+func (s *RealityService) KeyStatus() (*RealityKeyStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keyStatus()
+}
+
+// RotateKeys генерирует новую пару ключей x25519. Клиенты сразу получают новый публичный ключ,
+// прежний принимается еще overlap. Ключ, оставшийся от предыдущей ротации, перестает приниматься.
+func (s *RealityService) RotateKeys(overlap time.Duration) (*RealityKeyStatus, error) {
+	if overlap <= 0 {
+		return nil, ErrInvalidOverlap
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	privateKey, publicKey, err := xray.GenerateRealityKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRotateKeys, err)
+	}
+
+	cfg := s.xrayConfig.Load()
+	current := &database.RealityKey{
+		PrivateKey: cfg.RealityPrivateKey,
+		PublicKey:  cfg.RealityPublicKey,
+	}
+
+	// restore возвращает Xray и клиентские конфигурации к прежним ключам
+	restore := func() {
+		s.xrayConfig.Store(cfg)
+		if err := s.userService.ReloadXray(); err != nil {
+			log.Printf("Warning: failed to restore Xray after key rotation: %v", err)
+		}
+	}
+
+	s.setKeys(privateKey, publicKey, current.PrivateKey)
+	if err := s.userService.ReloadXray(); err != nil {
+		restore()
+		return nil, fmt.Errorf("%w: %v", ErrRotateKeys, err)
+	}
+
+	next := &database.RealityKey{PrivateKey: privateKey, PublicKey: publicKey}
+	if err := s.repository.RotateRealityKey(current, next, time.Now().Add(overlap)); err != nil {
+		// Без записи в БД после перезапуска вернулся бы прежний ключ, и клиенты,
+		// уже получившие новый, остались бы без перекрытия: откатываем ротацию
+		restore()
+		return nil, fmt.Errorf("%w: %v", ErrRotateKeys, err)
+	}
+
+	log.Printf("Reality key rotated, previous key accepted for %v", overlap)
+	return s.keyStatus()
+}
+
+// Start запускает проверку окончания перекрытия ключей
+func (s *RealityService) Start(interval time.Duration) {
+	if s.running {
+		return
+	}
+
+	s.running = true
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.retirePrevious()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Reality key rotation watcher started (interval: %v)", interval)
+}
+
+// Stop останавливает проверку
+func (s *RealityService) Stop() {
+	if !s.running {
+		return
+	}
+
+	close(s.stopCh)
+	s.running = false
+	log.Println("Reality key rotation watcher stopped")
+}
+
+// retirePrevious убирает из Xray прежний ключ, когда перекрытие закончилось
+func (s *RealityService) retirePrevious() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := s.xrayConfig.Load()
+	if !cfg.RealityOverlapActive() {
+		return
+	}
+
+	previous, err := s.repository.GetRealityKey(database.RealityKeyPrevious)
+	if err != nil {
+		log.Printf("Warning: failed to check reality key rotation: %v", err)
+		return
+	}
+	now := time.Now()
+	if previous != nil && now.Before(previous.RetiresAt) {
+		return
+	}
+
+	s.setKeys(cfg.RealityPrivateKey, cfg.RealityPublicKey, "")
+	if err := s.userService.ReloadXray(); err != nil {
+		// Повторим на следующей проверке
+		s.xrayConfig.Store(cfg)
+		log.Printf("Warning: failed to reload Xray without previous reality key: %v", err)
+		return
+	}
+	if _, err := s.repository.RetireRealityKeys(now); err != nil {
+		log.Printf("Warning: failed to retire reality keys: %v", err)
+	}
+	log.Println("Reality key overlap finished, previous key is no longer accepted")
+}
+
+// setKeys публикует ключи в общей конфигурации Xray (ее же используют ноды и подписки)
+func (s *RealityService) setKeys(privateKey, publicKey, previousPrivateKey string) {
+	s.xrayConfig.Update(func(cfg *xray.Config) {
+		cfg.RealityPrivateKey = privateKey
+		cfg.RealityPublicKey = publicKey
+		cfg.RealityPreviousPrivateKey = previousPrivateKey
+	})
+}
+
+func (s *RealityService) keyStatus() (*RealityKeyStatus, error) {
+	cfg := s.xrayConfig.Load()
+	status := &RealityKeyStatus{PublicKey: cfg.RealityPublicKey}
+	if !cfg.RealityOverlapActive() {
+		return status, nil
+	}
+
+	previous, err := s.repository.GetRealityKey(database.RealityKeyPrevious)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		status.PreviousPublicKey = previous.PublicKey
+		status.PreviousRetiresAt = &previous.RetiresAt
+	}
+	return status, nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/monitoring"
+	"vpn-service/xray"
+)
+
+// RealityTargetStatus - результат последней проверки цели Reality
+type RealityTargetStatus struct {
+	xray.RealityTarget
+	Healthy   bool      `json:"healthy"` // последняя проверка прошла
+	Active    bool      `json:"active"`  // цель опубликована в inbound и клиентских конфигурациях
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Failures - число проверок подряд с ошибкой
+	Failures int `json:"failures"`
+}
+
+// RealityTargetMonitor проверяет пул целей Reality (dest/SNI) и переключает Xray
+// на первую исправную, как только активная не проходит проверку.
+// Клиенты получают новый SNI при обновлении подписки.
+type RealityTargetMonitor struct {
+	realityService *RealityService
+	metrics        *monitoring.Metrics
+	targets        []xray.RealityTarget
+	interval       time.Duration
+	timeout        time.Duration
+
+	statusMu sync.RWMutex
+	status   []RealityTargetStatus // в порядке targets
+
+	stopCh  chan struct{}
+	running bool
+}
+
+// NewRealityTargetMonitor создает проверку целей Reality. Порядок targets - приоритет:
+// при переключении выбирается первая исправная цель.
+func NewRealityTargetMonitor(realityService *RealityService, metrics *monitoring.Metrics, targets []xray.RealityTarget,
+	interval, timeout time.Duration) *RealityTargetMonitor {
+	status := make([]RealityTargetStatus, len(targets))
+	for i, target := range targets {
+		status[i].RealityTarget = target
+	}
+
+	return &RealityTargetMonitor{
+		realityService: realityService,
+		metrics:        metrics,
+		targets:        targets,
+		interval:       interval,
+		timeout:        timeout,
+		status:         status,
+		stopCh:         make(chan struct{}),
+		running:        false,
+	}
+}
+
+// Start выполняет проверку сразу и затем периодически
+func (m *RealityTargetMonitor) Start() {
+	if m.running {
+		return
+	}
+
+	m.running = true
+
+	go func() {
+		// Первая проверка сразу: при старте цели проверяются однократно
+		// (SelectRealityTarget), дальше их состояние ведет монитор
+		m.Check()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.Check()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Reality target monitor started (%d targets, interval: %v)", len(m.targets), m.interval)
+}
+
+// Stop останавливает проверку
+func (m *RealityTargetMonitor) Stop() {
+	if !m.running {
+		return
+	}
+
+	close(m.stopCh)
+	m.running = false
+	log.Println("Reality target monitor stopped")
+}
+
+// Status возвращает результаты последней проверки целей
+func (m *RealityTargetMonitor) Status() []RealityTargetStatus {
+	active := m.realityService.Target()
+
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+
+	status := make([]RealityTargetStatus, len(m.status))
+	copy(status, m.status)
+	for i := range status {
+		status[i].Active = status[i].RealityTarget == active
+	}
+	return status
+}
+
+// Check проверяет все цели и при необходимости переключает активную
+func (m *RealityTargetMonitor) Check() {
+	m.probeAll()
+
+	active := m.realityService.Target()
+	next, ok := m.nextTarget(active)
+	if ok && next != active {
+		if err := m.realityService.SwitchTarget(next); err != nil {
+			log.Printf("Reality target: failed to switch %s -> %s: %v", active, next, err)
+		} else {
+			log.Printf("Reality target: switched %s -> %s", active, next)
+			if m.metrics != nil {
+				m.metrics.RealityTargetSwitches.WithLabelValues(active.String(), next.String()).Inc()
+			}
+			active = next
+		}
+	}
+
+	if m.metrics != nil {
+		for _, target := range m.targets {
+			value := 0.0
+			if target == active {
+				value = 1
+			}
+			m.metrics.RealityTargetActive.WithLabelValues(target.Dest, target.ServerName).Set(value)
+		}
+	}
+}
+
+// probeAll проверяет цели параллельно и обновляет их состояние
+func (m *RealityTargetMonitor) probeAll() {
+	var wg sync.WaitGroup
+	for i, target := range m.targets {
+		wg.Add(1)
+		go func(i int, target xray.RealityTarget) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := xray.ProbeRealityTarget(target, m.timeout)
+			latency := time.Since(start)
+
+			m.statusMu.Lock()
+			status := &m.status[i]
+			first := status.CheckedAt.IsZero()
+			changed := first || status.Healthy != (err == nil)
+			status.CheckedAt = start
+			status.LatencyMs = latency.Milliseconds()
+			status.Healthy = err == nil
+			if err != nil {
+				status.Error = err.Error()
+				status.Failures++
+			} else {
+				status.Error = ""
+				status.Failures = 0
+			}
+			m.statusMu.Unlock()
+
+			switch {
+			case changed && err != nil:
+				log.Printf("Reality target: %s failed probe: %v", target, err)
+			case changed && !first:
+				log.Printf("Reality target: %s recovered", target)
+			}
+			if m.metrics != nil {
+				value := 0.0
+				if err == nil {
+					value = 1
+				}
+				m.metrics.RealityTargetUp.WithLabelValues(target.Dest, target.ServerName).Set(value)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+}
+
+// nextTarget выбирает цель для публикации: активную, пока она проходит проверку,
+// иначе первую исправную. false - менять нечего.
+func (m *RealityTargetMonitor) nextTarget(active xray.RealityTarget) (xray.RealityTarget, bool) {
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+	return nextRealityTarget(m.status, active)
+}
+
+// nextRealityTarget выбирает цель по результатам проверок status (в порядке приоритета)
+func nextRealityTarget(status []RealityTargetStatus, active xray.RealityTarget) (xray.RealityTarget, bool) {
+	for _, target := range status {
+		if target.RealityTarget == active && target.Healthy {
+			return active, true
+		}
+	}
+	for _, target := range status {
+		if target.Healthy {
+			return target.RealityTarget, true
+		}
+	}
+
+	log.Printf("Reality target: no healthy targets, keeping %s", active)
+	return active, false
+}
+
+// SelectRealityTarget проверяет цели один раз и возвращает первую исправную.
+// Вызывается при запуске до публикации конфигурации, чтобы Xray и клиенты
+// не получили недоступную цель. Если исправных нет, возвращается первая.
+func SelectRealityTarget(targets []xray.RealityTarget, timeout time.Duration) xray.RealityTarget {
+	if len(targets) < 2 {
+		return targets[0]
+	}
+
+	healthy := make([]bool, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target xray.RealityTarget) {
+			defer wg.Done()
+			if err := xray.ProbeRealityTarget(target, timeout); err != nil {
+				log.Printf("Reality target: %s failed probe: %v", target, err)
+				return
+			}
+			healthy[i] = true
+		}(i, target)
+	}
+	wg.Wait()
+
+	for i, target := range targets {
+		if healthy[i] {
+			if i > 0 {
+				log.Printf("Reality target: %s is unavailable, starting with %s", targets[0], target)
+			}
+			return target
+		}
+	}
+	log.Printf("Reality target: no healthy targets, starting with %s", targets[0])
+	return targets[0]
+}
+
+// Target возвращает цель Reality, опубликованную в конфигурации Xray
+func (s *RealityService) Target() xray.RealityTarget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.target()
+}
+
+// SwitchTarget публикует цель Reality в inbound и клиентских конфигурациях
+// и применяет конфигурацию Xray (ноды получают ее при синхронизации)
+func (s *RealityService) SwitchTarget(target xray.RealityTarget) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.setTarget(target)
+	if err := s.userService.ReloadXray(); err != nil {
+		s.xrayConfig.Store(previous)
+		if err := s.userService.ReloadXray(); err != nil {
+			log.Printf("Warning: failed to restore Xray after reality target switch: %v", err)
+		}
+		return fmt.Errorf("failed to apply reality target: %w", err)
+	}
+	return nil
+}
+
+func (s *RealityService) target() xray.RealityTarget {
+	cfg := s.xrayConfig.Load()
+	target := xray.RealityTarget{Dest: cfg.RealityDest}
+	if len(cfg.RealityServerNames) > 0 {
+		target.ServerName = cfg.RealityServerNames[0]
+	}
+	return target
+}
+
+// setTarget публикует цель в общей конфигурации Xray (как setKeys),
+// возвращает прежнюю конфигурацию для отката
+func (s *RealityService) setTarget(target xray.RealityTarget) *xray.Config {
+	return s.xrayConfig.Update(func(cfg *xray.Config) {
+		cfg.RealityDest = target.Dest
+		cfg.RealityServerNames = []string{target.ServerName}
+	})
+}
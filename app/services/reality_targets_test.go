@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"vpn-service/xray"
+)
+
+func TestNextRealityTarget(t *testing.T) {
+	first := xray.RealityTarget{Dest: "a.example.com:443", ServerName: "a.example.com"}
+	second := xray.RealityTarget{Dest: "b.example.com:443", ServerName: "b.example.com"}
+	third := xray.RealityTarget{Dest: "c.example.com:443", ServerName: "c.example.com"}
+
+	status := func(healthy ...bool) []RealityTargetStatus {
+		targets := []xray.RealityTarget{first, second, third}
+		result := make([]RealityTargetStatus, len(healthy))
+		for i := range healthy {
+			result[i] = RealityTargetStatus{RealityTarget: targets[i], Healthy: healthy[i]}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		status []RealityTargetStatus
+		active xray.RealityTarget
+		want   xray.RealityTarget
+		wantOK bool
+	}{
+		{name: "healthy active is kept", status: status(true, true, true), active: second, want: second, wantOK: true},
+		{name: "failed active switches to first healthy", status: status(false, false, true), active: first, want: third, wantOK: true},
+		{name: "priority order on switch", status: status(true, false, true), active: second, want: first, wantOK: true},
+		{name: "unknown active switches", status: status(false, true, true), active: xray.RealityTarget{Dest: "old:443"}, want: second, wantOK: true},
+		{name: "no healthy targets", status: status(false, false, false), active: first, want: first},
+		{name: "no status", status: nil, active: first, want: first},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := nextRealityTarget(tt.status, tt.active)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("nextRealityTarget() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"vpn-service/monitoring"
+	"vpn-service/xray"
+)
+
+// ReconcileXray сверяет пользователей в inbound Xray с БД и исправляет расхождения:
+// недобавленных пользователей, оставшихся после отключения и с устаревшими учетными записями
+func (s *UserService) ReconcileXray() (*xray.ReconcileReport, error) {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+
+	users, err := s.repository.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListUsers, err)
+	}
+
+	report, err := s.xrayManager.Reconcile(users)
+	if err != nil {
+		return nil, err
+	}
+	if report.Failed() == 0 {
+		s.resetAccessState(users)
+	}
+	if len(report.Drift) > 0 {
+		// Ноды могли пропустить те же изменения
+		s.nodes.Notify()
+	}
+	return report, nil
+}
+
+// Reconciler периодически сверяет пользователей Xray с БД (первая сверка - при старте)
+type Reconciler struct {
+	userService *UserService
+	metrics     *monitoring.Metrics
+	interval    time.Duration
+	stopCh      chan struct{}
+	running     bool
+}
+
+// NewReconciler создает сверку пользователей Xray
+func NewReconciler(userService *UserService, metrics *monitoring.Metrics, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		userService: userService,
+		metrics:     metrics,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+		running:     false,
+	}
+}
+
+// Start выполняет сверку сразу и затем периодически
+func (r *Reconciler) Start() {
+	if r.running {
+		return
+	}
+
+	r.running = true
+
+	go func() {
+		r.scan()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.scan()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Xray reconciler started (interval: %v)", r.interval)
+}
+
+// Stop останавливает сверку
+func (r *Reconciler) Stop() {
+	if !r.running {
+		return
+	}
+
+	close(r.stopCh)
+	r.running = false
+	log.Println("Xray reconciler stopped")
+}
+
+// Run выполняет сверку по запросу и возвращает отчет
+func (r *Reconciler) Run() (*xray.ReconcileReport, error) {
+	report, err := r.userService.ReconcileXray()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range report.Drift {
+		result := "fixed"
+		if !entry.Fixed {
+			result = "failed"
+			log.Printf("Reconcile: failed to fix %s user %s in %s: %s", entry.Kind, entry.Email, entry.Inbound, entry.Error)
+		} else {
+			log.Printf("Reconcile: fixed %s user %s in %s", entry.Kind, entry.Email, entry.Inbound)
+		}
+		if r.metrics != nil {
+			r.metrics.XrayDrift.WithLabelValues(entry.Kind, result).Inc()
+		}
+	}
+	return report, nil
+}
+
+// scan выполняет один проход сверки
+func (r *Reconciler) scan() {
+	if _, err := r.Run(); err != nil {
+		log.Printf("Reconcile: failed to check Xray users: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"vpn-service/database"
+	"vpn-service/xray"
+)
+
+// Форматы подписки
+const (
+	SubscriptionFormatBase64  = "base64"  // список ссылок в base64 (v2rayN, v2rayNG, Shadowrocket)
+	SubscriptionFormatClash   = "clash"   // YAML профиль Clash/Mihomo
+	SubscriptionFormatSingBox = "singbox" // JSON профиль sing-box
+)
+
+var (
+	ErrSubscriptionNotFound      = errors.New("subscription not found")
+	ErrSubscriptionInactive      = errors.New("subscription is inactive")
+	ErrInvalidSubscriptionFormat = errors.New("invalid subscription format")
+)
+
+// SubscriptionService формирует публичные подписки пользователей
+type SubscriptionService struct {
+	repository     *database.Repository
+	xrayManager    *xray.Manager
+	xrayConfig     *xray.ConfigStore
+	nodes          *NodeService
+	updateInterval time.Duration
+}
+
+// NewSubscriptionService создает новый экземпляр SubscriptionService
+func NewSubscriptionService(repo *database.Repository, xrayMgr *xray.Manager, xrayCfg *xray.ConfigStore, nodes *NodeService, updateInterval time.Duration) *SubscriptionService {
+	return &SubscriptionService{
+		repository:     repo,
+		xrayManager:    xrayMgr,
+		xrayConfig:     xrayCfg,
+		nodes:          nodes,
+		updateInterval: updateInterval,
+	}
+}
+
+// Subscription - готовый ответ подписки
+type Subscription struct {
+	Body        string
+	ContentType string
+	Filename    string
+	// UserInfo - значение заголовка subscription-userinfo
+	UserInfo string
+	// UpdateIntervalHours - значение заголовка profile-update-interval
+	UpdateIntervalHours int
+}
+
+// DetectSubscriptionFormat выбирает формат по параметру запроса, иначе по User-Agent клиента
+func DetectSubscriptionFormat(format, userAgent string) (string, error) {
+	switch strings.ToLower(format) {
+	case SubscriptionFormatBase64, "v2ray", "links":
+		return SubscriptionFormatBase64, nil
+	case SubscriptionFormatClash, "mihomo":
+		return SubscriptionFormatClash, nil
+	case SubscriptionFormatSingBox, "sing-box":
+		return SubscriptionFormatSingBox, nil
+	case "":
+	default:
+		return "", ErrInvalidSubscriptionFormat
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return SubscriptionFormatClash, nil
+	case strings.Contains(ua, "sing-box"), strings.Contains(ua, "hiddify"),
+		strings.Contains(ua, "sfa"), strings.Contains(ua, "sfi"), strings.Contains(ua, "sfm"):
+		return SubscriptionFormatSingBox, nil
+	default:
+		return SubscriptionFormatBase64, nil
+	}
+}
+
+// GetSubscription возвращает подписку пользователя по токену в указанном формате.
+// Для неактивного пользователя возвращается ErrSubscriptionInactive вместе с
+// заполненным UserInfo, чтобы клиент показал причину (трафик, срок).
+func (s *SubscriptionService) GetSubscription(token, format string) (*Subscription, error) {
+	user, err := s.repository.GetUserBySubToken(token)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	subscription := &Subscription{
+		Filename:            user.Username,
+		UserInfo:            subscriptionUserInfo(user),
+		UpdateIntervalHours: s.updateIntervalHours(),
+	}
+
+	if !user.CanConnect() {
+		return subscription, ErrSubscriptionInactive
+	}
+
+	// Подключения ко всем серверам кластера, доступным пользователю
+	endpoints, err := s.nodes.Endpoints(s.xrayManager.ClientView(user))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGenerateConfig, err)
+	}
+
+	switch format {
+	case SubscriptionFormatClash:
+		subscription.Body, err = clashProfile(endpoints, s.xrayConfig.Load())
+		subscription.ContentType = "text/yaml; charset=utf-8"
+		subscription.Filename += ".yaml"
+	case SubscriptionFormatSingBox:
+		subscription.Body, err = singBoxProfile(endpoints)
+		subscription.ContentType = "application/json"
+		subscription.Filename += ".json"
+	default:
+		subscription.Body, err = endpointLinks(endpoints)
+		subscription.ContentType = "text/plain; charset=utf-8"
+		subscription.Filename += ".txt"
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGenerateConfig, err)
+	}
+
+	return subscription, nil
+}
+
+// updateIntervalHours возвращает интервал обновления профиля в часах (не меньше 1)
+func (s *SubscriptionService) updateIntervalHours() int {
+	hours := int(s.updateInterval / time.Hour)
+	if hours < 1 {
+		return 1
+	}
+	return hours
+}
+
+// subscriptionUserInfo формирует заголовок subscription-userinfo.
+// total и expire равны 0, если лимита или срока нет.
+func subscriptionUserInfo(user *database.User) string {
+	var expire int64
+	if !user.ExpiresAt.IsZero() {
+		expire = user.ExpiresAt.Unix()
+	}
+
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d",
+		user.TrafficUp,
+		user.TrafficDown,
+		user.EffectiveTrafficLimit(),
+		expire,
+	)
+}
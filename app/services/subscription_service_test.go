@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectSubscriptionFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		userAgent string
+		want      string
+		wantErr   error
+	}{
+		{name: "explicit base64", format: "base64", want: SubscriptionFormatBase64},
+		{name: "v2ray alias", format: "v2ray", want: SubscriptionFormatBase64},
+		{name: "explicit clash", format: "clash", want: SubscriptionFormatClash},
+		{name: "mihomo alias is case insensitive", format: "Mihomo", want: SubscriptionFormatClash},
+		{name: "explicit singbox", format: "singbox", want: SubscriptionFormatSingBox},
+		{name: "sing-box alias", format: "sing-box", want: SubscriptionFormatSingBox},
+		{name: "format wins over user agent", format: "base64", userAgent: "ClashMeta/1.18", want: SubscriptionFormatBase64},
+		{name: "unknown format", format: "quantumult", wantErr: ErrInvalidSubscriptionFormat},
+		{name: "clash user agent", userAgent: "clash-verge/v1.7.7", want: SubscriptionFormatClash},
+		{name: "mihomo user agent", userAgent: "mihomo/1.18.5", want: SubscriptionFormatClash},
+		{name: "stash user agent", userAgent: "Stash/2.6.0 Clash/1.9.0", want: SubscriptionFormatClash},
+		{name: "sing-box user agent", userAgent: "sing-box 1.10.1", want: SubscriptionFormatSingBox},
+		{name: "hiddify user agent", userAgent: "HiddifyNext/2.5.7", want: SubscriptionFormatSingBox},
+		{name: "sfa user agent", userAgent: "SFA/1.10.1", want: SubscriptionFormatSingBox},
+		{name: "v2rayNG user agent", userAgent: "v2rayNG/1.8.19", want: SubscriptionFormatBase64},
+		{name: "no hints", want: SubscriptionFormatBase64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectSubscriptionFormat(tt.format, tt.userAgent)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DetectSubscriptionFormat(%q, %q) error = %v, want %v", tt.format, tt.userAgent, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectSubscriptionFormat(%q, %q) = %q, want %q", tt.format, tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"vpn-service/database"
+)
+
+var (
+	ErrInvalidGranularity = errors.New("invalid granularity")
+	ErrInvalidTimeRange   = errors.New("invalid time range")
+	ErrOutsideRetention   = errors.New("hourly traffic is not retained for the requested range")
+	ErrQueryTraffic       = errors.New("failed to query traffic")
+)
+
+// Окна по умолчанию, если from не указан
+const (
+	defaultHourlyWindow = 24 * time.Hour
+	defaultDailyWindow  = 30 * 24 * time.Hour
+)
+
+// TrafficService отвечает за историю трафика
+type TrafficService struct {
+	repository *database.Repository
+	// hourlyRetention - сколько хранятся часовые бакеты (TRAFFIC_HOURLY_RETENTION),
+	// более старые свернуты в суточные
+	hourlyRetention time.Duration
+}
+
+// NewTrafficService создает новый экземпляр TrafficService
+func NewTrafficService(repo *database.Repository, hourlyRetention time.Duration) *TrafficService {
+	return &TrafficService{
+		repository:      repo,
+		hourlyRetention: hourlyRetention,
+	}
+}
+
+// HourlyRetentionStart возвращает начало часовой истории: раньше есть только суточные бакеты.
+// Граница совпадает с границей свертки (TrafficRollup).
+func (s *TrafficService) HourlyRetentionStart(now time.Time) time.Time {
+	return database.BucketStart(now.Add(-s.hourlyRetention), database.GranularityDay)
+}
+
+// TrafficQueryDTO параметры запроса истории трафика
+type TrafficQueryDTO struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+// TrafficReport временной ряд трафика с итогами
+type TrafficReport struct {
+	UserID        *uint                   `json:"user_id,omitempty"`
+	Username      string                  `json:"username,omitempty"`
+	From          time.Time               `json:"from"` // начало первого бакета (from, округленный вниз)
+	To            time.Time               `json:"to"`
+	Granularity   string                  `json:"granularity"`
+	TotalUpload   int64                   `json:"total_upload"`
+	TotalDownload int64                   `json:"total_download"`
+	Points        []database.TrafficPoint `json:"points"`
+}
+
+// GetUserTraffic возвращает историю трафика пользователя
+func (s *TrafficService) GetUserTraffic(id uint, dto TrafficQueryDTO) (*TrafficReport, error) {
+	user, err := s.repository.GetUserByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	report, err := s.buildReport(&user.ID, dto)
+	if err != nil {
+		return nil, err
+	}
+	report.Username = user.Username
+
+	return report, nil
+}
+
+// GetFleetTraffic возвращает суммарную историю трафика всех пользователей
+func (s *TrafficService) GetFleetTraffic(dto TrafficQueryDTO) (*TrafficReport, error) {
+	return s.buildReport(nil, dto)
+}
+
+func (s *TrafficService) buildReport(userID *uint, dto TrafficQueryDTO) (*TrafficReport, error) {
+	granularity := dto.Granularity
+	if granularity == "" {
+		granularity = database.GranularityHour
+	}
+	if granularity != database.GranularityHour && granularity != database.GranularityDay {
+		return nil, ErrInvalidGranularity
+	}
+
+	to := dto.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	from := dto.From
+	if from.IsZero() {
+		if granularity == database.GranularityDay {
+			from = to.Add(-defaultDailyWindow)
+		} else {
+			from = to.Add(-defaultHourlyWindow)
+			if retained := s.HourlyRetentionStart(time.Now()); from.Before(retained) {
+				from = retained
+			}
+		}
+	} else if granularity == database.GranularityHour && from.Before(s.HourlyRetentionStart(time.Now())) {
+		// Часовые бакеты этого диапазона уже свернуты: ответ был бы неполным
+		return nil, ErrOutsideRetention
+	}
+
+	if !from.Before(to) {
+		return nil, ErrInvalidTimeRange
+	}
+	// Ряд начинается с бакета, в который попадает from: в отчете - фактическое начало
+	from = database.BucketStart(from, granularity)
+
+	points, err := s.repository.QueryTraffic(userID, from, to, granularity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrQueryTraffic, err)
+	}
+
+	report := &TrafficReport{
+		UserID:      userID,
+		From:        from.UTC(),
+		To:          to.UTC(),
+		Granularity: granularity,
+		Points:      points,
+	}
+	for _, point := range points {
+		report.TotalUpload += point.Upload
+		report.TotalDownload += point.Download
+	}
+
+	return report, nil
+}
+
+// GetUserPeriods возвращает архив закрытых периодов квоты пользователя
+func (s *TrafficService) GetUserPeriods(id uint) ([]*database.TrafficPeriod, error) {
+	if _, err := s.repository.GetUserByID(id); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	periods, err := s.repository.ListTrafficPeriods(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrQueryTraffic, err)
+	}
+	return periods, nil
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 	"vpn-service/database"
 	"vpn-service/utils"
@@ -10,62 +12,150 @@ import (
 )
 
 var (
-	ErrUserNotFound    = errors.New("user not found")
-	ErrUsernameExists  = errors.New("username already exists")
-	ErrInvalidUsername = errors.New("username is required")
-	ErrInvalidUserID   = errors.New("invalid user ID")
-	ErrCreateUser      = errors.New("failed to create user")
-	ErrUpdateUser      = errors.New("failed to update user")
-	ErrDeleteUser      = errors.New("failed to delete user")
-	ErrListUsers       = errors.New("failed to list users")
-	ErrGenerateConfig  = errors.New("failed to generate config")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUsernameExists     = errors.New("username already exists")
+	ErrInvalidUsername    = errors.New("username is required")
+	ErrInvalidUserID      = errors.New("invalid user ID")
+	ErrInvalidLimitMode   = errors.New("invalid traffic limit mode")
+	ErrInvalidQuotaPeriod = errors.New("invalid quota period")
+	ErrInvalidProtocol    = errors.New("unknown protocol")
+	ErrInvalidFlow        = errors.New("flow is not supported by the current transport")
+	ErrInvalidMaxDevices  = errors.New("max devices must not be negative")
+	ErrInvalidSpeedLimit  = errors.New("speed limit must not be negative")
+	ErrCreateUser         = errors.New("failed to create user")
+	ErrUpdateUser         = errors.New("failed to update user")
+	ErrDeleteUser         = errors.New("failed to delete user")
+	ErrListUsers          = errors.New("failed to list users")
+	ErrGenerateConfig     = errors.New("failed to generate config")
 )
 
 // UserService содержит бизнес-логику для работы с пользователями
 type UserService struct {
-	repository  *database.Repository
-	xrayManager *xray.Manager
-	xrayConfig  *xray.Config
-	serverIP    string
+	repository       *database.Repository
+	xrayManager      *xray.Manager
+	xrayConfig       *xray.ConfigStore
+	serverIP         string
+	defaultLimitMode string
+	// subscriptionBaseURL - публичный адрес сервиса для ссылок /sub/{token}
+	subscriptionBaseURL string
+	// nodes - ноды кластера, получают изменения пользователей вслед за локальным Xray
+	nodes *NodeService
+
+	// xrayUsers - пользователи, загруженные сейчас в inbound Xray (по ID)
+	xrayUsers map[uint]bool
+	accessMu  sync.Mutex
+	// shortIDReload - отложенная пересборка Xray для новых shortId Reality
+	shortIDReload *time.Timer
 }
 
-// NewUserService создает новый экземпляр UserService
-func NewUserService(repo *database.Repository, xrayMgr *xray.Manager, xrayCfg *xray.Config, serverIP string) *UserService {
+// shortIDRetryInterval - через сколько повторить пересборку для shortId, если она не удалась
+const shortIDRetryInterval = 30 * time.Second
+
+// NewUserService создает новый экземпляр UserService.
+// defaultLimitMode применяется к новым пользователям без явного режима учета трафика.
+func NewUserService(repo *database.Repository, xrayMgr *xray.Manager, xrayCfg *xray.ConfigStore, nodes *NodeService, serverIP, defaultLimitMode, subscriptionBaseURL string) *UserService {
+	if !database.IsValidLimitMode(defaultLimitMode) {
+		defaultLimitMode = database.LimitModeBoth
+	}
 	return &UserService{
-		repository:  repo,
-		xrayManager: xrayMgr,
-		xrayConfig:  xrayCfg,
-		serverIP:    serverIP,
+		repository:       repo,
+		xrayManager:      xrayMgr,
+		xrayConfig:       xrayCfg,
+		serverIP:         serverIP,
+		defaultLimitMode: defaultLimitMode,
+		xrayUsers:        make(map[uint]bool),
+		nodes:            nodes,
+
+		subscriptionBaseURL: strings.TrimRight(subscriptionBaseURL, "/"),
 	}
 }
 
+// LoadAccessState запоминает, какие пользователи были загружены в Xray при старте
+func (s *UserService) LoadAccessState(users []*database.User) {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	s.resetAccessState(users)
+}
+
 // CreateUserDTO структура для создания пользователя
 type CreateUserDTO struct {
-	Username     string
-	TrafficLimit int64
-	ExpiresAt    time.Time
+	Username         string
+	TrafficLimit     int64
+	TrafficLimitMode string
+	ExpiresAt        time.Time
+	QuotaPeriod      string
+	QuotaAnchor      time.Time
+	QuotaRollover    bool
+	// PlanID назначает тарифный план; ненулевые TrafficLimit/ExpiresAt переопределяют значения плана
+	PlanID *uint
+	// Protocols включает/выключает протоколы (по умолчанию разрешены все или все из плана)
+	Protocols map[string]bool
+	// Flow переопределяет flow плана ("" - по умолчанию)
+	Flow string
+	// MaxDevices переопределяет лимит устройств плана (0 - по умолчанию)
+	MaxDevices int
+	// SpeedLimitUp/SpeedLimitDown переопределяют скорость плана, байт/с (0 - по умолчанию)
+	SpeedLimitUp   int64
+	SpeedLimitDown int64
 }
 
 // UpdateUserDTO структура для обновления пользователя
 type UpdateUserDTO struct {
-	TrafficLimit *int64
-	ExpiresAt    *time.Time
-	IsActive     *bool
+	TrafficLimit     *int64
+	TrafficLimitMode *string
+	ExpiresAt        *time.Time
+	IsActive         *bool
+	QuotaPeriod      *string
+	QuotaAnchor      *time.Time
+	QuotaRollover    *bool
+	// PlanID назначает новый план (0 - снять план), RenewPlan продлевает текущий;
+	// вместе их передавать нельзя.
+	// Явно переданные TrafficLimit/ExpiresAt переопределяют значения плана.
+	PlanID    *uint
+	RenewPlan bool
+	// Protocols переключает только перечисленные протоколы
+	Protocols      map[string]bool
+	Flow           *string
+	MaxDevices     *int
+	SpeedLimitUp   *int64
+	SpeedLimitDown *int64
 }
 
 // UserConfigResponse структура ответа с конфигурацией пользователя
 type UserConfigResponse struct {
-	Username     string `json:"username"`
-	UUID         string `json:"uuid"`
-	ServerIP     string `json:"server_ip"`
-	ServerPort   int    `json:"server_port"`
-	JSON         string `json:"json"`
-	URI          string `json:"uri"`
-	QRCode       string `json:"qr_code"`
-	ExpiresAt    string `json:"expires_at"`
-	TrafficLimit int64  `json:"traffic_limit"`
-	TrafficUsed  int64  `json:"traffic_used"`
-	IsActive     bool   `json:"is_active"`
+	Username         string `json:"username"`
+	UUID             string `json:"uuid"`
+	ServerIP         string `json:"server_ip"`
+	ServerPort       int    `json:"server_port"`
+	JSON             string `json:"json"`
+	URI              string `json:"uri"`
+	QRCode           string `json:"qr_code"`
+	ExpiresAt        string `json:"expires_at"`
+	TrafficLimit     int64  `json:"traffic_limit"`
+	TrafficLimitMode string `json:"traffic_limit_mode"`
+	TrafficUsed      int64  `json:"traffic_used"`
+	TrafficUp        int64  `json:"traffic_up"`
+	TrafficDown      int64  `json:"traffic_down"`
+	IsActive         bool   `json:"is_active"`
+	SubscriptionURL  string `json:"subscription_url"`
+
+	Shadowsocks *ProtocolConfig `json:"shadowsocks,omitempty"`
+	Trojan      *ProtocolConfig `json:"trojan,omitempty"`
+	VMess       *ProtocolConfig `json:"vmess,omitempty"`
+}
+
+// SubscriptionInfo содержит ссылку на подписку пользователя
+type SubscriptionInfo struct {
+	Username        string `json:"username"`
+	SubscriptionURL string `json:"subscription_url"`
+}
+
+// ProtocolConfig содержит клиентские конфигурации дополнительного протокола
+type ProtocolConfig struct {
+	URI     string `json:"uri"`
+	JSON    string `json:"json"`
+	SingBox string `json:"sing_box"`
+	QRCode  string `json:"qr_code"`
 }
 
 // CreateUser создает нового пользователя
@@ -75,28 +165,115 @@ func (s *UserService) CreateUser(dto CreateUserDTO) (*database.User, error) {
 		return nil, ErrInvalidUsername
 	}
 
+	limitMode := dto.TrafficLimitMode
+	if limitMode == "" {
+		limitMode = s.defaultLimitMode
+	}
+	if !database.IsValidLimitMode(limitMode) {
+		return nil, ErrInvalidLimitMode
+	}
+
+	if !database.IsValidQuotaPeriod(dto.QuotaPeriod) {
+		return nil, ErrInvalidQuotaPeriod
+	}
+
+	if err := validateProtocols(dto.Protocols); err != nil {
+		return nil, err
+	}
+
+	if err := xray.ValidateFlow(dto.Flow, s.xrayConfig.Load()); err != nil {
+		return nil, ErrInvalidFlow
+	}
+
+	if dto.MaxDevices < 0 {
+		return nil, ErrInvalidMaxDevices
+	}
+
+	if dto.SpeedLimitUp < 0 || dto.SpeedLimitDown < 0 {
+		return nil, ErrInvalidSpeedLimit
+	}
+
 	// Проверяем уникальность
 	if _, err := s.repository.GetUserByUsername(dto.Username); err == nil {
 		return nil, ErrUsernameExists
 	}
 
+	secret, err := utils.GenerateSecret(database.UserSecretBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateUser, err)
+	}
+
+	trojanPassword, err := utils.GenerateSecret(database.TrojanPasswordBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateUser, err)
+	}
+
+	subToken, err := utils.GenerateSecret(database.SubTokenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateUser, err)
+	}
+
+	shortID, err := utils.GenerateSecret(database.ShortIDBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCreateUser, err)
+	}
+
 	// Создаем пользователя
 	user := &database.User{
-		Username:     dto.Username,
-		UUID:         utils.GenerateUUID(),
-		IsActive:     true,
-		TrafficLimit: dto.TrafficLimit,
-		ExpiresAt:    dto.ExpiresAt,
+		Username:         dto.Username,
+		UUID:             utils.GenerateUUID(),
+		Secret:           secret,
+		TrojanPassword:   trojanPassword,
+		SubToken:         subToken,
+		ShortID:          shortID,
+		IsActive:         true,
+		TrafficLimitMode: limitMode,
+		QuotaPeriod:      dto.QuotaPeriod,
+		QuotaAnchor:      dto.QuotaAnchor,
+		QuotaRollover:    dto.QuotaRollover,
+	}
+
+	now := time.Now()
+	if dto.PlanID != nil {
+		plan, err := s.repository.GetPlanByID(*dto.PlanID)
+		if err != nil {
+			return nil, ErrPlanNotFound
+		}
+		assignPlan(user, plan, now)
+	}
+
+	// Индивидуальные значения имеют приоритет над планом
+	if dto.TrafficLimit != 0 {
+		user.TrafficLimit = dto.TrafficLimit
+	}
+	if !dto.ExpiresAt.IsZero() {
+		user.ExpiresAt = dto.ExpiresAt
+	}
+	if dto.PlanID == nil {
+		enableAllProtocols(user)
+	}
+	applyProtocolFlags(user, dto.Protocols)
+	if dto.Flow != "" {
+		user.Flow = dto.Flow
+	}
+	if dto.MaxDevices != 0 {
+		user.MaxDevices = dto.MaxDevices
 	}
+	if dto.SpeedLimitUp != 0 {
+		user.SpeedLimitUp = dto.SpeedLimitUp
+	}
+	if dto.SpeedLimitDown != 0 {
+		user.SpeedLimitDown = dto.SpeedLimitDown
+	}
+
+	startQuotaPeriod(user, now)
 
 	if err := s.repository.CreateUser(user); err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrCreateUser, err)
 	}
 
 	// Hot-update Xray (fallback to full restart on error)
-	if user.CanConnect() {
-		s.hotAddUserWithFallback(user)
-	}
+	s.applyUserAccess(user)
 
 	return user, nil
 }
@@ -135,26 +312,124 @@ func (s *UserService) UpdateUser(id uint, dto UpdateUserDTO) (*database.User, er
 		return nil, ErrUserNotFound
 	}
 
-	oldCanConnect := user.CanConnect()
+	if err := validateProtocols(dto.Protocols); err != nil {
+		return nil, err
+	}
+	// Назначение плана уже начинает новый срок, продлевать нечего
+	if dto.PlanID != nil && dto.RenewPlan {
+		return nil, ErrPlanConflict
+	}
+	previous := *user
+
+	// Сначала применяем план, затем индивидуальные значения
+	if dto.PlanID != nil {
+		if *dto.PlanID == 0 {
+			user.PlanID = nil
+		} else {
+			plan, err := s.repository.GetPlanByID(*dto.PlanID)
+			if err != nil {
+				return nil, ErrPlanNotFound
+			}
+			assignPlan(user, plan, time.Now())
+		}
+	} else if dto.RenewPlan {
+		if user.PlanID == nil {
+			return nil, ErrNoPlan
+		}
+		plan, err := s.repository.GetPlanByID(*user.PlanID)
+		if err != nil {
+			return nil, ErrPlanNotFound
+		}
+		renewPlan(user, plan, time.Now())
+	}
 
 	// Обновляем поля если они указаны
 	if dto.TrafficLimit != nil {
 		user.TrafficLimit = *dto.TrafficLimit
 	}
 
+	if dto.TrafficLimitMode != nil {
+		if !database.IsValidLimitMode(*dto.TrafficLimitMode) {
+			return nil, ErrInvalidLimitMode
+		}
+		user.TrafficLimitMode = *dto.TrafficLimitMode
+	}
+
 	if dto.ExpiresAt != nil {
 		user.ExpiresAt = *dto.ExpiresAt
 	}
 
 	if dto.IsActive != nil {
 		user.IsActive = *dto.IsActive
+		user.DeactivatedReason = ""
+		// Ручное включение или выключение снимает временное отключение
+		user.SuspendedUntil = time.Time{}
+		if !user.IsActive {
+			user.DeactivatedReason = database.DeactivatedManual
+		}
+	}
+
+	if dto.QuotaPeriod != nil || dto.QuotaAnchor != nil {
+		if dto.QuotaPeriod != nil {
+			if !database.IsValidQuotaPeriod(*dto.QuotaPeriod) {
+				return nil, ErrInvalidQuotaPeriod
+			}
+			user.QuotaPeriod = *dto.QuotaPeriod
+		}
+		if dto.QuotaAnchor != nil {
+			user.QuotaAnchor = *dto.QuotaAnchor
+		}
+		// Новое расписание начинается с текущего периода, без переноса остатка
+		startQuotaPeriod(user, time.Now())
+	}
+
+	if dto.QuotaRollover != nil {
+		user.QuotaRollover = *dto.QuotaRollover
+	}
+
+	applyProtocolFlags(user, dto.Protocols)
+
+	if dto.Flow != nil {
+		if err := xray.ValidateFlow(*dto.Flow, s.xrayConfig.Load()); err != nil {
+			return nil, ErrInvalidFlow
+		}
+		user.Flow = *dto.Flow
+	}
+
+	if dto.MaxDevices != nil {
+		if *dto.MaxDevices < 0 {
+			return nil, ErrInvalidMaxDevices
+		}
+		user.MaxDevices = *dto.MaxDevices
+	}
+
+	if dto.SpeedLimitUp != nil {
+		if *dto.SpeedLimitUp < 0 {
+			return nil, ErrInvalidSpeedLimit
+		}
+		user.SpeedLimitUp = *dto.SpeedLimitUp
+	}
+	if dto.SpeedLimitDown != nil {
+		if *dto.SpeedLimitDown < 0 {
+			return nil, ErrInvalidSpeedLimit
+		}
+		user.SpeedLimitDown = *dto.SpeedLimitDown
 	}
 
 	if err := s.repository.UpdateUser(user); err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrUpdateUser, err)
 	}
 
-	s.hotUpdateUserAccess(user, oldCanConnect)
+	// Скорость применяется без пересоздания пользователя в Xray
+	if user.SpeedLimitUp != previous.SpeedLimitUp || user.SpeedLimitDown != previous.SpeedLimitDown {
+		s.xrayManager.SetSpeedLimit(user)
+		s.nodes.Notify()
+	}
+
+	if xrayAccountChanged(&previous, user) {
+		s.removeXrayAccounts(&previous)
+	}
+	s.applyUserAccess(user)
 
 	return user, nil
 }
@@ -170,9 +445,16 @@ func (s *UserService) DeleteUser(id uint) error {
 		return ErrUserNotFound
 	}
 
-	if user.CanConnect() {
+	if err := s.repository.DeleteUserTrafficHistory(id); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	s.accessMu.Lock()
+	if s.xrayUsers[user.ID] {
 		s.hotRemoveUserWithFallback(user)
 	}
+	delete(s.xrayUsers, user.ID)
+	s.accessMu.Unlock()
 
 	return nil
 }
@@ -183,38 +465,229 @@ func (s *UserService) GetUserConfig(id uint) (*UserConfigResponse, error) {
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
+	user = s.xrayManager.ClientView(user)
+	cfg := s.xrayConfig.Load()
 
 	// Генерируем конфигурации
-	jsonConfig, err := xray.GenerateClientJSON(user, s.xrayConfig, s.serverIP)
+	var jsonConfig, vlessURI, qrCode string
+	if user.VlessEnabled {
+		jsonConfig, err = xray.GenerateClientJSON(user, cfg, s.serverIP)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate JSON config: %v", ErrGenerateConfig, err)
+		}
+
+		vlessURI, err = xray.GenerateVlessURI(user, cfg, s.serverIP)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate VLESS URI: %v", ErrGenerateConfig, err)
+		}
+
+		qrCode, err = utils.GenerateQRCode(vlessURI)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate QR code: %v", ErrGenerateConfig, err)
+		}
+	}
+
+	response := &UserConfigResponse{
+		Username:         user.Username,
+		UUID:             user.UUID,
+		ServerIP:         s.serverIP,
+		ServerPort:       cfg.Port,
+		JSON:             jsonConfig,
+		URI:              vlessURI,
+		QRCode:           qrCode,
+		ExpiresAt:        user.ExpiresAt.Format(time.RFC3339),
+		TrafficLimit:     user.TrafficLimit,
+		TrafficLimitMode: user.TrafficLimitMode,
+		TrafficUsed:      user.TrafficUsed,
+		TrafficUp:        user.TrafficUp,
+		TrafficDown:      user.TrafficDown,
+		IsActive:         user.IsActive,
+		SubscriptionURL:  s.subscriptionURL(user),
+	}
+
+	if cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled {
+		ssConfig, err := s.shadowsocksConfig(user, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate Shadowsocks config: %v", ErrGenerateConfig, err)
+		}
+		response.Shadowsocks = ssConfig
+	}
+
+	if cfg.TrojanEnabled() && user.TrojanEnabled {
+		trojanConfig, err := s.trojanConfig(user, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate Trojan config: %v", ErrGenerateConfig, err)
+		}
+		response.Trojan = trojanConfig
+	}
+
+	if cfg.VMessEnabled() && user.VmessEnabled {
+		vmessConfig, err := s.vmessConfig(user, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate VMess config: %v", ErrGenerateConfig, err)
+		}
+		response.VMess = vmessConfig
+	}
+
+	return response, nil
+}
+
+// RotateSubscriptionToken выпускает новый токен подписки, старая ссылка перестает работать
+func (s *UserService) RotateSubscriptionToken(id uint) (*SubscriptionInfo, error) {
+	user, err := s.repository.GetUserByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	token, err := utils.GenerateSecret(database.SubTokenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpdateUser, err)
+	}
+	user.SubToken = token
+
+	if err := s.repository.UpdateUser(user); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpdateUser, err)
+	}
+
+	return &SubscriptionInfo{
+		Username:        user.Username,
+		SubscriptionURL: s.subscriptionURL(user),
+	}, nil
+}
+
+// subscriptionURL возвращает публичную ссылку на подписку пользователя
+func (s *UserService) subscriptionURL(user *database.User) string {
+	return s.subscriptionBaseURL + "/sub/" + user.SubToken
+}
+
+// GetUserClashConfig возвращает YAML профиль Clash/Mihomo пользователя со всеми доступными ему серверами
+func (s *UserService) GetUserClashConfig(id uint) (string, error) {
+	user, err := s.repository.GetUserByID(id)
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to generate JSON config: %v", ErrGenerateConfig, err)
+		return "", ErrUserNotFound
 	}
 
-	vlessURI, err := xray.GenerateVlessURI(user, s.xrayConfig, s.serverIP)
+	endpoints, err := s.nodes.Endpoints(s.xrayManager.ClientView(user))
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to generate VLESS URI: %v", ErrGenerateConfig, err)
+		return "", fmt.Errorf("%w: %v", ErrGenerateConfig, err)
 	}
 
-	qrCode, err := utils.GenerateQRCode(vlessURI)
+	profile, err := clashProfile(endpoints, s.xrayConfig.Load())
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to generate QR code: %v", ErrGenerateConfig, err)
+		return "", fmt.Errorf("%w: failed to generate Clash config: %v", ErrGenerateConfig, err)
 	}
 
-	response := &UserConfigResponse{
-		Username:     user.Username,
-		UUID:         user.UUID,
-		ServerIP:     s.serverIP,
-		ServerPort:   s.xrayConfig.Port,
-		JSON:         jsonConfig,
-		URI:          vlessURI,
-		QRCode:       qrCode,
-		ExpiresAt:    user.ExpiresAt.Format(time.RFC3339),
-		TrafficLimit: user.TrafficLimit,
-		TrafficUsed:  user.TrafficUsed,
-		IsActive:     user.IsActive,
+	return profile, nil
+}
+
+// GetUserSingBoxConfig возвращает профиль sing-box пользователя со всеми доступными ему серверами
+func (s *UserService) GetUserSingBoxConfig(id uint) (string, error) {
+	user, err := s.repository.GetUserByID(id)
+	if err != nil {
+		return "", ErrUserNotFound
 	}
 
-	return response, nil
+	endpoints, err := s.nodes.Endpoints(s.xrayManager.ClientView(user))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrGenerateConfig, err)
+	}
+
+	profile, err := singBoxProfile(endpoints)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to generate sing-box config: %v", ErrGenerateConfig, err)
+	}
+
+	return profile, nil
+}
+
+// shadowsocksConfig генерирует клиентские конфигурации Shadowsocks 2022
+func (s *UserService) shadowsocksConfig(user *database.User, cfg *xray.Config) (*ProtocolConfig, error) {
+	uri, err := xray.GenerateShadowsocksURI(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonConfig, err := xray.GenerateShadowsocksJSON(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	singBox, err := xray.GenerateShadowsocksSingBox(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	qrCode, err := utils.GenerateQRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtocolConfig{
+		URI:     uri,
+		JSON:    jsonConfig,
+		SingBox: singBox,
+		QRCode:  qrCode,
+	}, nil
+}
+
+// trojanConfig генерирует клиентские конфигурации Trojan
+func (s *UserService) trojanConfig(user *database.User, cfg *xray.Config) (*ProtocolConfig, error) {
+	uri, err := xray.GenerateTrojanURI(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonConfig, err := xray.GenerateTrojanJSON(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	singBox, err := xray.GenerateTrojanSingBox(user, cfg, s.serverIP)
+	if err != nil {
+		return nil, err
+	}
+
+	qrCode, err := utils.GenerateQRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtocolConfig{
+		URI:     uri,
+		JSON:    jsonConfig,
+		SingBox: singBox,
+		QRCode:  qrCode,
+	}, nil
+}
+
+// vmessConfig генерирует клиентские конфигурации VMess
+func (s *UserService) vmessConfig(user *database.User, cfg *xray.Config) (*ProtocolConfig, error) {
+	uri, err := xray.GenerateVMessURI(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonConfig, err := xray.GenerateVMessJSON(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	singBox, err := xray.GenerateVMessSingBox(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	qrCode, err := utils.GenerateQRCode(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtocolConfig{
+		URI:     uri,
+		JSON:    jsonConfig,
+		SingBox: singBox,
+		QRCode:  qrCode,
+	}, nil
 }
 
 // ResetUserTraffic сбрасывает счетчик трафика пользователя
@@ -243,8 +716,9 @@ func (s *UserService) GetStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-// CheckHealth проверяет состояние сервиса
-func (s *UserService) CheckHealth() map[string]interface{} {
+// CheckHealth проверяет состояние сервиса. Без detailed (публичный /health) отдаются
+// только статусы и количество нод; detailed добавляет последний reload Xray и ноды.
+func (s *UserService) CheckHealth(detailed bool) map[string]interface{} {
 	status := map[string]interface{}{
 		"status":      "healthy",
 		"time":        time.Now().Format(time.RFC3339),
@@ -259,51 +733,159 @@ func (s *UserService) CheckHealth() map[string]interface{} {
 		status["database"] = "ok"
 	}
 
+	// Проверяем API Xray
+	if err := s.xrayManager.CheckAPI(); err != nil {
+		status["xray_api"] = "error"
+		status["status"] = "degraded"
+	} else {
+		status["xray_api"] = "ok"
+	}
+	// Как Xray применил последние изменения: hot, rebuild и т.д.
+	if reload := s.xrayManager.LastReload(); reload != nil && detailed {
+		status["xray_last_reload"] = reload
+	}
+
+	// Ноды кластера: публично только количество по состояниям
+	nodes, err := s.nodes.HealthSummary()
+	if err != nil {
+		status["nodes"] = "error"
+		status["status"] = "degraded"
+		return status
+	}
+	counts := map[string]int{"total": len(nodes)}
+	for _, node := range nodes {
+		state, _ := node["state"].(string)
+		counts[state]++
+		if state == NodeStateDown {
+			status["status"] = "degraded"
+		}
+	}
+	status["nodes"] = counts
+	if detailed {
+		status["node_details"] = nodes
+	}
+
 	return status
 }
 
-// syncXrayUsers синхронизирует пользователей с Xray
+// ReloadXray применяет изменившуюся конфигурацию Xray (например, ключи Reality)
+// и рассылает ее нодам
+func (s *UserService) ReloadXray() error {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	return s.syncXrayUsers()
+}
+
+// syncXrayUsers синхронизирует пользователей и текущую конфигурацию с Xray.
+// Вызывается под accessMu.
 func (s *UserService) syncXrayUsers() error {
 	users, err := s.repository.ListUsers()
 	if err != nil {
 		return fmt.Errorf("failed to list users: %v", err)
 	}
 
-	if err := s.xrayManager.UpdateUsers(users); err != nil {
+	if _, err := s.xrayManager.Apply(s.xrayConfig.Load(), users); err != nil {
 		return fmt.Errorf("failed to update Xray: %v", err)
 	}
 
+	s.resetAccessState(users)
+	s.scheduleShortIDReload()
+	s.nodes.Notify()
 	return nil
 }
 
-func (s *UserService) hotAddUserWithFallback(user *database.User) {
-	if err := s.xrayManager.AddUserHot(user); err != nil {
-		s.fallbackXraySync("hot-add user", err)
+// resetAccessState пересобирает xrayUsers по списку пользователей. Вызывается под accessMu.
+func (s *UserService) resetAccessState(users []*database.User) {
+	s.xrayUsers = make(map[uint]bool, len(users))
+	for _, user := range users {
+		if user.CanConnect() {
+			s.xrayUsers[user.ID] = true
+		}
 	}
 }
 
-func (s *UserService) hotRemoveUserWithFallback(user *database.User) {
-	if err := s.xrayManager.RemoveUserHot(user); err != nil {
-		s.fallbackXraySync("hot-remove user", err)
+// applyUserAccess приводит наличие пользователя в Xray к user.CanConnect().
+// Возвращает true, если состояние изменилось; если Xray не удалось обновить, false,
+// и следующая проверка повторит попытку.
+func (s *UserService) applyUserAccess(user *database.User) bool {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+
+	canConnect := user.CanConnect()
+	if s.xrayUsers[user.ID] == canConnect {
+		return false
+	}
+
+	if canConnect {
+		return s.hotAddUserWithFallback(user)
 	}
+	return s.hotRemoveUserWithFallback(user)
 }
 
-func (s *UserService) hotUpdateUserAccess(user *database.User, oldCanConnect bool) {
-	newCanConnect := user.CanConnect()
-	if oldCanConnect == newCanConnect {
-		return
+// removeXrayAccounts убирает пользователя из Xray с прежними протоколами и flow,
+// чтобы applyUserAccess добавил его заново уже с новыми
+func (s *UserService) removeXrayAccounts(previous *database.User) {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+
+	if s.xrayUsers[previous.ID] {
+		s.hotRemoveUserWithFallback(previous)
 	}
+}
 
-	if newCanConnect {
-		s.hotAddUserWithFallback(user)
+// hotAddUserWithFallback добавляет пользователя в Xray, а при ошибке синхронизирует
+// всех пользователей. false - не помогло ни то, ни другое. Вызывается под accessMu.
+func (s *UserService) hotAddUserWithFallback(user *database.User) bool {
+	if err := s.xrayManager.AddUserHot(user); err != nil {
+		return s.fallbackXraySync("hot-add user", err)
+	}
+	s.xrayUsers[user.ID] = true
+	s.scheduleShortIDReload()
+	s.nodes.Notify()
+	return true
+}
+
+// scheduleShortIDReload откладывает пересборку Xray, которая загрузит новые shortId Reality:
+// одна пересборка загружает shortId всех пользователей, добавленных за это время.
+// Вызывается под accessMu.
+func (s *UserService) scheduleShortIDReload() {
+	at, ok := s.xrayManager.ShortIDReloadAt()
+	if !ok || s.shortIDReload != nil {
 		return
 	}
-	s.hotRemoveUserWithFallback(user)
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		// Срок прошел, а shortId все еще не загружены - пересборка не удалась
+		wait = shortIDRetryInterval
+	}
+	s.shortIDReload = time.AfterFunc(wait, func() {
+		s.accessMu.Lock()
+		defer s.accessMu.Unlock()
+
+		s.shortIDReload = nil
+		if err := s.syncXrayUsers(); err != nil {
+			fmt.Printf("Warning: failed to load new Reality short ids: %v\n", err)
+			s.scheduleShortIDReload()
+		}
+	})
+}
+
+// hotRemoveUserWithFallback - как hotAddUserWithFallback, но удаляет пользователя
+func (s *UserService) hotRemoveUserWithFallback(user *database.User) bool {
+	if err := s.xrayManager.RemoveUserHot(user); err != nil {
+		return s.fallbackXraySync("hot-remove user", err)
+	}
+	delete(s.xrayUsers, user.ID)
+	s.nodes.Notify()
+	return true
 }
 
-func (s *UserService) fallbackXraySync(action string, err error) {
+func (s *UserService) fallbackXraySync(action string, err error) bool {
 	fmt.Printf("Warning: failed to %s: %v\n", action, err)
 	if err := s.syncXrayUsers(); err != nil {
 		fmt.Printf("Warning: failed to sync Xray users: %v\n", err)
+		return false
 	}
+	return true
 }
@@ -3,19 +3,43 @@ package xray
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 	"vpn-service/database"
 
 	handlerService "github.com/xtls/xray-core/app/proxyman/command"
+	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
 	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
+	"github.com/xtls/xray-core/proxy/trojan"
 	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vmess"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
-// APIClient provides access to Xray HandlerService.
+const userStatPrefix = "user>>>"
+
+// UserTraffic holds uplink/downlink byte counters of a single user.
+// Email matches database.User.Username.
+type UserTraffic struct {
+	Email    string
+	Uplink   int64
+	Downlink int64
+}
+
+// OnlineIP is a source address of a user currently connected to Xray.
+type OnlineIP struct {
+	IP       string    `json:"ip"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// APIClient provides access to Xray HandlerService and StatsService.
 type APIClient struct {
 	address    string
 	inboundTag string
@@ -31,19 +55,33 @@ func NewAPIClient(address, inboundTag string, timeout time.Duration) *APIClient
 	}
 }
 
-// AddUser adds a single user to the inbound via API.
-func (c *APIClient) AddUser(user *database.User) error {
+// AddUser adds a single user to the VLESS inbound via API with the given flow.
+func (c *APIClient) AddUser(user *database.User, flow string) error {
 	if user == nil {
 		return fmt.Errorf("user is nil")
 	}
-	protoUser, err := buildVlessProtocolUser(user)
+	protoUser, err := buildVlessProtocolUser(user, flow)
 	if err != nil {
 		return err
 	}
 
+	return c.AddInboundUser(c.inboundTag, protoUser)
+}
+
+// RemoveUser removes a single user from the VLESS inbound via API.
+func (c *APIClient) RemoveUser(user *database.User) error {
+	if user == nil {
+		return fmt.Errorf("user is nil")
+	}
+
+	return c.RemoveInboundUser(c.inboundTag, user.Username)
+}
+
+// AddInboundUser adds a prepared protocol user to the inbound with the given tag.
+func (c *APIClient) AddInboundUser(tag string, protoUser *protocol.User) error {
 	return c.alterInbound(func(ctx context.Context, client handlerService.HandlerServiceClient) error {
 		_, err := client.AlterInbound(ctx, &handlerService.AlterInboundRequest{
-			Tag: c.inboundTag,
+			Tag: tag,
 			Operation: serial.ToTypedMessage(&handlerService.AddUserOperation{
 				User: protoUser,
 			}),
@@ -52,26 +90,137 @@ func (c *APIClient) AddUser(user *database.User) error {
 	})
 }
 
-// RemoveUser removes a single user from the inbound via API.
-func (c *APIClient) RemoveUser(user *database.User) error {
-	if user == nil {
-		return fmt.Errorf("user is nil")
-	}
-
+// RemoveInboundUser removes a user identified by email from the inbound with the given tag.
+func (c *APIClient) RemoveInboundUser(tag, email string) error {
 	return c.alterInbound(func(ctx context.Context, client handlerService.HandlerServiceClient) error {
 		_, err := client.AlterInbound(ctx, &handlerService.AlterInboundRequest{
-			Tag: c.inboundTag,
+			Tag: tag,
 			Operation: serial.ToTypedMessage(&handlerService.RemoveUserOperation{
-				Email: user.Username,
+				Email: email,
 			}),
 		})
 		return err
 	})
 }
 
+// GetInboundUsers returns the users currently loaded into the inbound with the given tag.
+func (c *APIClient) GetInboundUsers(tag string) ([]*protocol.User, error) {
+	var users []*protocol.User
+	err := c.withConn(func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := handlerService.NewHandlerServiceClient(conn).GetInboundUsers(ctx, &handlerService.GetInboundUserRequest{
+			Tag: tag,
+		})
+		if err != nil {
+			return fmt.Errorf("xray api get inbound users failed: %w", err)
+		}
+		users = resp.GetUsers()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// QueryUserTraffic returns per-user traffic counters from StatsService.
+// With reset=true the counters are zeroed on the Xray side, so every call
+// returns only the delta since the previous one.
+func (c *APIClient) QueryUserTraffic(reset bool) (map[string]*UserTraffic, error) {
+	var resp *statsService.QueryStatsResponse
+	err := c.withConn(func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = statsService.NewStatsServiceClient(conn).QueryStats(ctx, &statsService.QueryStatsRequest{
+			Pattern: userStatPrefix,
+			Reset_:  reset,
+		})
+		if err != nil {
+			return fmt.Errorf("xray api query stats failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUserTraffic(resp.GetStat()), nil
+}
+
+// QueryOnlineIPs returns source addresses of the given users from the online
+// stats (policy statsUserOnline). Users without live connections are omitted.
+// Every user is a separate call with its own timeout, so the total time grows
+// with the number of users instead of failing on a shared deadline.
+func (c *APIClient) QueryOnlineIPs(emails []string) (map[string][]OnlineIP, error) {
+	result := make(map[string][]OnlineIP)
+	err := c.withConn(func(_ context.Context, conn *grpc.ClientConn) error {
+		client := statsService.NewStatsServiceClient(conn)
+		for _, email := range emails {
+			ips, err := c.onlineIPList(client, email)
+			if err != nil {
+				// Xray has no online map until the user's first connection
+				if status.Code(err) == codes.NotFound {
+					continue
+				}
+				return fmt.Errorf("xray api online ip list failed: %w", err)
+			}
+
+			if len(ips) == 0 {
+				continue
+			}
+			entries := make([]OnlineIP, 0, len(ips))
+			for ip, seen := range ips {
+				entries = append(entries, OnlineIP{IP: ip, LastSeen: time.Unix(seen, 0)})
+			}
+			sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+			result[email] = entries
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// onlineIPList returns the online IPs of one user with a per-call timeout.
+func (c *APIClient) onlineIPList(client statsService.StatsServiceClient, email string) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := client.GetStatsOnlineIpList(ctx, &statsService.GetStatsRequest{
+		Name: userStatPrefix + email + ">>>online",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetIps(), nil
+}
+
+// Ping checks that the Xray gRPC API answers, using a cheap stats query.
+func (c *APIClient) Ping() error {
+	return c.withConn(func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := statsService.NewStatsServiceClient(conn).QueryStats(ctx, &statsService.QueryStatsRequest{
+			Pattern: "inbound>>>" + c.inboundTag + ">>>",
+		})
+		if err != nil {
+			return fmt.Errorf("xray api ping failed: %w", err)
+		}
+		return nil
+	})
+}
+
 func (c *APIClient) alterInbound(
 	action func(ctx context.Context, client handlerService.HandlerServiceClient) error,
 ) error {
+	return c.withConn(func(ctx context.Context, conn *grpc.ClientConn) error {
+		client := handlerService.NewHandlerServiceClient(conn)
+		if err := action(ctx, client); err != nil {
+			return fmt.Errorf("xray api alter inbound failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (c *APIClient) withConn(action func(ctx context.Context, conn *grpc.ClientConn) error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
@@ -86,14 +235,37 @@ func (c *APIClient) alterInbound(
 	}
 	defer conn.Close()
 
-	client := handlerService.NewHandlerServiceClient(conn)
-	if err := action(ctx, client); err != nil {
-		return fmt.Errorf("xray api alter inbound failed: %w", err)
+	return action(ctx, conn)
+}
+
+// parseUserTraffic groups user>>>email>>>traffic>>>{uplink,downlink} counters by email.
+func parseUserTraffic(stats []*statsService.Stat) map[string]*UserTraffic {
+	result := make(map[string]*UserTraffic)
+	for _, stat := range stats {
+		// user>>>{email}>>>traffic>>>{uplink|downlink}
+		parts := strings.Split(stat.GetName(), ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+			continue
+		}
+
+		email := parts[1]
+		entry, ok := result[email]
+		if !ok {
+			entry = &UserTraffic{Email: email}
+			result[email] = entry
+		}
+
+		switch parts[3] {
+		case "uplink":
+			entry.Uplink += stat.GetValue()
+		case "downlink":
+			entry.Downlink += stat.GetValue()
+		}
 	}
-	return nil
+	return result
 }
 
-func buildVlessProtocolUser(user *database.User) (*protocol.User, error) {
+func buildVlessProtocolUser(user *database.User, flow string) (*protocol.User, error) {
 	parsedUUID, err := uuid.ParseString(user.UUID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user uuid: %w", err)
@@ -101,7 +273,60 @@ func buildVlessProtocolUser(user *database.User) (*protocol.User, error) {
 
 	account := &vless.Account{
 		Id:   parsedUUID.String(),
-		Flow: "",
+		Flow: flow,
+	}
+
+	return &protocol.User{
+		Email:   user.Username,
+		Level:   0,
+		Account: serial.ToTypedMessage(account),
+	}, nil
+}
+
+func buildVMessProtocolUser(user *database.User) (*protocol.User, error) {
+	parsedUUID, err := uuid.ParseString(user.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user uuid: %w", err)
+	}
+
+	account := &vmess.Account{
+		Id: parsedUUID.String(),
+		SecuritySettings: &protocol.SecurityConfig{
+			Type: protocol.SecurityType_AUTO,
+		},
+	}
+
+	return &protocol.User{
+		Email:   user.Username,
+		Level:   0,
+		Account: serial.ToTypedMessage(account),
+	}, nil
+}
+
+func buildShadowsocksProtocolUser(user *database.User, cfg *Config) (*protocol.User, error) {
+	key, err := ShadowsocksUserKey(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &shadowsocks_2022.Account{
+		Key: key,
+	}
+
+	return &protocol.User{
+		Email:   user.Username,
+		Level:   0,
+		Account: serial.ToTypedMessage(account),
+	}, nil
+}
+
+func buildTrojanProtocolUser(user *database.User) (*protocol.User, error) {
+	if user.TrojanPassword == "" {
+		return nil, fmt.Errorf("user %s has no trojan password", user.Username)
+	}
+
+	account := &trojan.Account{
+		Password: user.TrojanPassword,
 	}
 
 	return &protocol.User{
@@ -110,3 +335,20 @@ func buildVlessProtocolUser(user *database.User) (*protocol.User, error) {
 		Account: serial.ToTypedMessage(account),
 	}, nil
 }
+
+// buildInboundUser builds the protocol user matching the inbound with the given tag.
+// Inbounds holding the previous Reality key take the same users as their primary.
+func buildInboundUser(tag string, user *database.User, cfg *Config) (*protocol.User, error) {
+	switch strings.TrimSuffix(tag, previousKeyTagSuffix) {
+	case cfg.InboundTag:
+		return buildVlessProtocolUser(user, EffectiveFlow(user, cfg))
+	case cfg.ShadowsocksInboundTag:
+		return buildShadowsocksProtocolUser(user, cfg)
+	case cfg.TrojanInboundTag:
+		return buildTrojanProtocolUser(user)
+	case cfg.VMessInboundTag:
+		return buildVMessProtocolUser(user)
+	default:
+		return nil, fmt.Errorf("unknown inbound tag: %s", tag)
+	}
+}
@@ -0,0 +1,81 @@
+package xray
+
+import (
+	"reflect"
+	"testing"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+)
+
+func TestParseUserTraffic(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []*statsService.Stat
+		want  map[string]*UserTraffic
+	}{
+		{
+			name:  "empty",
+			stats: nil,
+			want:  map[string]*UserTraffic{},
+		},
+		{
+			name: "uplink and downlink of one user",
+			stats: []*statsService.Stat{
+				{Name: "user>>>alice>>>traffic>>>uplink", Value: 100},
+				{Name: "user>>>alice>>>traffic>>>downlink", Value: 2000},
+			},
+			want: map[string]*UserTraffic{
+				"alice": {Email: "alice", Uplink: 100, Downlink: 2000},
+			},
+		},
+		{
+			name: "several users",
+			stats: []*statsService.Stat{
+				{Name: "user>>>alice>>>traffic>>>downlink", Value: 5},
+				{Name: "user>>>bob>>>traffic>>>uplink", Value: 7},
+			},
+			want: map[string]*UserTraffic{
+				"alice": {Email: "alice", Downlink: 5},
+				"bob":   {Email: "bob", Uplink: 7},
+			},
+		},
+		{
+			name: "repeated counters are summed",
+			stats: []*statsService.Stat{
+				{Name: "user>>>alice>>>traffic>>>uplink", Value: 10},
+				{Name: "user>>>alice>>>traffic>>>uplink", Value: 15},
+			},
+			want: map[string]*UserTraffic{
+				"alice": {Email: "alice", Uplink: 25},
+			},
+		},
+		{
+			name: "inbound and malformed counters are ignored",
+			stats: []*statsService.Stat{
+				{Name: "inbound>>>vless-in>>>traffic>>>uplink", Value: 1},
+				{Name: "user>>>alice>>>traffic", Value: 2},
+				{Name: "user>>>alice>>>online>>>uplink", Value: 3},
+				{Name: "user>>>alice>>>traffic>>>uplink>>>extra", Value: 4},
+			},
+			want: map[string]*UserTraffic{},
+		},
+		{
+			name: "unknown direction keeps zero counters",
+			stats: []*statsService.Stat{
+				{Name: "user>>>alice>>>traffic>>>sideways", Value: 9},
+			},
+			want: map[string]*UserTraffic{
+				"alice": {Email: "alice"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseUserTraffic(tt.stats)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUserTraffic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package xray
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"vpn-service/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Группы прокси в профиле Clash, на них ссылаются правила
+const (
+	ClashGroupProxy = "PROXY"
+	ClashGroupAuto  = "AUTO"
+)
+
+// DefaultClashRules - правила по умолчанию: локальные сети напрямую, остальное через прокси
+var DefaultClashRules = []string{
+	"DOMAIN-SUFFIX,local,DIRECT",
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"GEOIP,private,DIRECT,no-resolve",
+}
+
+// DefaultClashDNS - DoH серверы по умолчанию
+var DefaultClashDNS = []string{
+	"https://1.1.1.1/dns-query",
+	"https://8.8.8.8/dns-query",
+}
+
+// ClashProfile - профиль Clash/Mihomo
+type ClashProfile struct {
+	MixedPort   int                      `yaml:"mixed-port"`
+	AllowLan    bool                     `yaml:"allow-lan"`
+	Mode        string                   `yaml:"mode"`
+	LogLevel    string                   `yaml:"log-level"`
+	IPv6        bool                     `yaml:"ipv6"`
+	DNS         ClashDNS                 `yaml:"dns"`
+	Proxies     []map[string]interface{} `yaml:"proxies"`
+	ProxyGroups []ClashProxyGroup        `yaml:"proxy-groups"`
+	Rules       []string                 `yaml:"rules"`
+}
+
+// ClashDNS - секция dns профиля
+type ClashDNS struct {
+	Enable            bool     `yaml:"enable"`
+	IPv6              bool     `yaml:"ipv6"`
+	EnhancedMode      string   `yaml:"enhanced-mode"`
+	FakeIPRange       string   `yaml:"fake-ip-range"`
+	DefaultNameserver []string `yaml:"default-nameserver"`
+	Nameserver        []string `yaml:"nameserver"`
+}
+
+// ClashProxyGroup - группа прокси (select или url-test)
+type ClashProxyGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// GenerateClashConfig генерирует YAML профиль Clash/Mihomo
+func GenerateClashConfig(user *database.User, cfg *Config, serverIP string) (string, error) {
+	proxies, err := ClashProxies(user, cfg, serverIP)
+	if err != nil {
+		return "", err
+	}
+
+	return MarshalClashProfile(BuildClashProfile(proxies, cfg))
+}
+
+// ClashProxies возвращает прокси Clash по всем разрешенным пользователю протоколам
+func ClashProxies(user *database.User, cfg *Config, serverIP string) ([]map[string]interface{}, error) {
+	if !user.CanConnect() {
+		return nil, fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	proxies := make([]map[string]interface{}, 0)
+	if user.VlessEnabled {
+		proxies = append(proxies, vlessClashProxy(user, cfg, serverIP))
+	}
+
+	if cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled {
+		ssProxy, err := shadowsocksClashProxy(user, cfg, serverIP)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, ssProxy)
+	}
+
+	if cfg.TrojanEnabled() && user.TrojanEnabled {
+		if err := checkTrojanUser(user); err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, trojanClashProxy(user, cfg, serverIP))
+	}
+
+	if cfg.VMessEnabled() && user.VmessEnabled {
+		proxies = append(proxies, vmessClashProxy(user, cfg))
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no protocols enabled for user %s", user.Username)
+	}
+
+	return proxies, nil
+}
+
+// BuildClashProfile собирает профиль с группами select/url-test, DNS и правилами
+func BuildClashProfile(proxies []map[string]interface{}, cfg *Config) *ClashProfile {
+	names := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		names = append(names, fmt.Sprint(proxy["name"]))
+	}
+
+	selectProxies := append([]string{ClashGroupAuto}, names...)
+	selectProxies = append(selectProxies, "DIRECT")
+
+	dnsServers := cfg.ClashDNS
+	if len(dnsServers) == 0 {
+		dnsServers = DefaultClashDNS
+	}
+
+	return &ClashProfile{
+		MixedPort: 7890,
+		AllowLan:  false,
+		Mode:      "rule",
+		LogLevel:  "info",
+		IPv6:      false,
+		DNS: ClashDNS{
+			Enable:            true,
+			IPv6:              false,
+			EnhancedMode:      "fake-ip",
+			FakeIPRange:       "198.18.0.1/16",
+			DefaultNameserver: []string{"1.1.1.1", "8.8.8.8"},
+			Nameserver:        dnsServers,
+		},
+		Proxies: proxies,
+		ProxyGroups: []ClashProxyGroup{
+			{
+				Name:    ClashGroupProxy,
+				Type:    "select",
+				Proxies: selectProxies,
+			},
+			{
+				Name:     ClashGroupAuto,
+				Type:     "url-test",
+				Proxies:  names,
+				URL:      cfg.clashTestURL(),
+				Interval: 300,
+			},
+		},
+		Rules: clashRules(cfg),
+	}
+}
+
+// MarshalClashProfile сериализует профиль в YAML
+func MarshalClashProfile(profile *ClashProfile) (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(profile); err != nil {
+		return "", fmt.Errorf("failed to marshal clash config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to marshal clash config: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// clashRules возвращает правила из конфигурации с завершающим MATCH
+func clashRules(cfg *Config) []string {
+	source := cfg.ClashRules
+	if len(source) == 0 {
+		source = DefaultClashRules
+	}
+
+	rules := make([]string, 0, len(source)+1)
+	for _, rule := range source {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || strings.HasPrefix(rule, "MATCH,") {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	return append(rules, "MATCH,"+ClashGroupProxy)
+}
+
+// clashTestURL возвращает URL проверки для группы url-test
+func (c *Config) clashTestURL() string {
+	if c.ClashTestURL == "" {
+		return "https://www.gstatic.com/generate_204"
+	}
+	return c.ClashTestURL
+}
+
+// vlessClashProxy возвращает прокси VLESS для Clash
+func vlessClashProxy(user *database.User, cfg *Config, serverIP string) map[string]interface{} {
+	proxy := map[string]interface{}{
+		"name":   cfg.profileName(user, ""),
+		"type":   "vless",
+		"server": serverIP,
+		"port":   cfg.Port,
+		"uuid":   user.UUID,
+		"udp":    true,
+	}
+	if flow := EffectiveFlow(user, cfg); flow != "" {
+		proxy["flow"] = flow
+	}
+
+	clashTransportOptions(proxy, user, cfg)
+	return proxy
+}
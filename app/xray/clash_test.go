@@ -0,0 +1,242 @@
+package xray
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"vpn-service/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testConfig возвращает конфигурацию без переменных окружения
+func testConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.RealityPrivateKey = "private-key"
+	cfg.RealityPublicKey = "public-key"
+	cfg.RealityShortIds = []string{"0123abcd"}
+	cfg.TLSDomain = "vpn.example.com"
+	return cfg
+}
+
+// testUser возвращает пользователя, которому разрешено подключение по VLESS
+func testUser() *database.User {
+	return &database.User{
+		Username:     "alice",
+		UUID:         "b831381d-6324-4d53-ad4f-8cda48b30811",
+		IsActive:     true,
+		VlessEnabled: true,
+	}
+}
+
+func TestVlessClashProxy(t *testing.T) {
+	tests := []struct {
+		name        string
+		transport   string
+		flow        string
+		wantFlow    string
+		wantSNI     string
+		wantOpts    string
+		wantReality bool
+	}{
+		{name: "tcp with vision", transport: TransportTCP, wantFlow: database.FlowVision, wantSNI: "eh.vk.com", wantReality: true},
+		{name: "tcp without flow", transport: TransportTCP, flow: database.FlowNone, wantSNI: "eh.vk.com", wantReality: true},
+		{name: "xhttp", transport: TransportXHTTP, wantSNI: "eh.vk.com", wantOpts: "xhttp-opts", wantReality: true},
+		{name: "grpc", transport: TransportGRPC, wantSNI: "eh.vk.com", wantOpts: "grpc-opts", wantReality: true},
+		{name: "ws over tls", transport: TransportWebSocket, wantSNI: "vpn.example.com", wantOpts: "ws-opts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Transport = tt.transport
+			user := testUser()
+			user.Flow = tt.flow
+
+			proxy := vlessClashProxy(user, cfg, "203.0.113.1")
+
+			if proxy["type"] != "vless" || proxy["server"] != "203.0.113.1" || proxy["uuid"] != user.UUID {
+				t.Errorf("unexpected proxy: %v", proxy)
+			}
+			if proxy["network"] != tt.transport {
+				t.Errorf("network = %v, want %s", proxy["network"], tt.transport)
+			}
+			if flow, _ := proxy["flow"].(string); flow != tt.wantFlow {
+				t.Errorf("flow = %q, want %q", flow, tt.wantFlow)
+			}
+			if proxy["servername"] != tt.wantSNI {
+				t.Errorf("servername = %v, want %s", proxy["servername"], tt.wantSNI)
+			}
+			if _, ok := proxy["reality-opts"]; ok != tt.wantReality {
+				t.Errorf("reality-opts present = %v, want %v", ok, tt.wantReality)
+			}
+			if tt.wantOpts != "" {
+				if _, ok := proxy[tt.wantOpts]; !ok {
+					t.Errorf("%s is missing", tt.wantOpts)
+				}
+			}
+		})
+	}
+}
+
+func TestClashProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      func(user *database.User)
+		cfg       func(cfg *Config)
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "vless only",
+			wantNames: []string{"alice"},
+		},
+		{
+			name:      "display name prefixes proxies",
+			cfg:       func(cfg *Config) { cfg.DisplayName = "nl-1" },
+			wantNames: []string{"nl-1-alice"},
+		},
+		{
+			name: "vless, trojan and vmess",
+			user: func(user *database.User) {
+				user.TrojanEnabled = true
+				user.TrojanPassword = "secret"
+				user.VmessEnabled = true
+			},
+			cfg: func(cfg *Config) {
+				cfg.TrojanPort = 8443
+				cfg.VMessPort = 2053
+			},
+			wantNames: []string{"alice", "alice-trojan", "alice-vmess"},
+		},
+		{
+			name:      "protocols disabled on server are skipped",
+			user:      func(user *database.User) { user.TrojanEnabled = true; user.VmessEnabled = true },
+			wantNames: []string{"alice"},
+		},
+		{
+			name:    "inactive user",
+			user:    func(user *database.User) { user.IsActive = false },
+			wantErr: true,
+		},
+		{
+			name:    "no protocols",
+			user:    func(user *database.User) { user.VlessEnabled = false },
+			wantErr: true,
+		},
+		{
+			name:    "trojan without password",
+			user:    func(user *database.User) { user.TrojanEnabled = true },
+			cfg:     func(cfg *Config) { cfg.TrojanPort = 8443 },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testUser()
+			if tt.user != nil {
+				tt.user(user)
+			}
+			cfg := testConfig()
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+
+			proxies, err := ClashProxies(user, cfg, "203.0.113.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ClashProxies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			names := make([]string, 0, len(proxies))
+			for _, proxy := range proxies {
+				names = append(names, proxy["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("proxy names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestClashRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		want  []string
+	}{
+		{
+			name:  "defaults",
+			rules: nil,
+			want:  append(append([]string{}, DefaultClashRules...), "MATCH,PROXY"),
+		},
+		{
+			name:  "custom rules",
+			rules: []string{"DOMAIN-SUFFIX,ru,DIRECT", " GEOIP,RU,DIRECT "},
+			want:  []string{"DOMAIN-SUFFIX,ru,DIRECT", "GEOIP,RU,DIRECT", "MATCH,PROXY"},
+		},
+		{
+			name:  "blank and MATCH rules are dropped",
+			rules: []string{"", "MATCH,DIRECT", "GEOIP,RU,DIRECT"},
+			want:  []string{"GEOIP,RU,DIRECT", "MATCH,PROXY"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ClashRules = tt.rules
+			if got := clashRules(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clashRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildClashProfile(t *testing.T) {
+	cfg := testConfig()
+	cfg.ClashDNS = []string{"https://dns.example.com/dns-query"}
+	proxies := []map[string]interface{}{
+		{"name": "alice", "type": "vless"},
+		{"name": "alice-ss", "type": "ss"},
+	}
+
+	profile := BuildClashProfile(proxies, cfg)
+
+	if len(profile.ProxyGroups) != 2 {
+		t.Fatalf("proxy groups = %d, want 2", len(profile.ProxyGroups))
+	}
+	selector, auto := profile.ProxyGroups[0], profile.ProxyGroups[1]
+	if selector.Name != ClashGroupProxy || selector.Type != "select" ||
+		!reflect.DeepEqual(selector.Proxies, []string{ClashGroupAuto, "alice", "alice-ss", "DIRECT"}) {
+		t.Errorf("unexpected select group: %+v", selector)
+	}
+	if auto.Name != ClashGroupAuto || auto.Type != "url-test" ||
+		!reflect.DeepEqual(auto.Proxies, []string{"alice", "alice-ss"}) ||
+		auto.URL != "https://www.gstatic.com/generate_204" {
+		t.Errorf("unexpected url-test group: %+v", auto)
+	}
+	if !reflect.DeepEqual(profile.DNS.Nameserver, cfg.ClashDNS) {
+		t.Errorf("nameserver = %v, want %v", profile.DNS.Nameserver, cfg.ClashDNS)
+	}
+
+	data, err := MarshalClashProfile(profile)
+	if err != nil {
+		t.Fatalf("MarshalClashProfile() error = %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := yaml.Unmarshal([]byte(data), &decoded); err != nil {
+		t.Fatalf("profile is not valid YAML: %v", err)
+	}
+	for _, key := range []string{"mixed-port", "dns", "proxies", "proxy-groups", "rules"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("key %s is missing in:\n%s", key, data)
+		}
+	}
+	if !strings.Contains(data, "MATCH,PROXY") {
+		t.Errorf("final rule is missing in:\n%s", data)
+	}
+}
@@ -1,9 +1,11 @@
 package xray
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 	"vpn-service/database"
 )
 
@@ -35,23 +37,13 @@ func GenerateClientJSON(user *database.User, cfg *Config, serverIP string) (stri
 						{
 							"id":         user.UUID,
 							"encryption": "none",
-							"flow":       "",
+							"flow":       EffectiveFlow(user, cfg),
 						},
 					},
 				},
 			},
 		},
-		"streamSettings": map[string]interface{}{
-			"network":  "tcp",
-			"security": "reality",
-			"realitySettings": map[string]interface{}{
-				"serverName":  cfg.RealityServerNames[0],
-				"fingerprint": "chrome",
-				"publicKey":   cfg.RealityPublicKey,
-				"shortId":     cfg.RealityShortIds[1],
-				"spiderX":     "",
-			},
-		},
+		"streamSettings": clientStreamSettings(user, cfg),
 	}
 
 	jsonBytes, err := json.MarshalIndent(clientConfig, "", "  ")
@@ -62,6 +54,22 @@ func GenerateClientJSON(user *database.User, cfg *Config, serverIP string) (stri
 	return string(jsonBytes), nil
 }
 
+// GenerateSingBoxConfig генерирует полный профиль sing-box (Hiddify, SFA/SFI):
+// tun inbound, DNS, правила маршрутизации и outbound разрешенных протоколов
+func GenerateSingBoxConfig(user *database.User, cfg *Config, serverIP string) (string, error) {
+	outbounds, err := SingBoxOutbounds(user, cfg, serverIP)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.MarshalIndent(BuildSingBoxProfile(outbounds), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
 // GenerateVlessURI генерирует VLESS URI для клиента
 func GenerateVlessURI(user *database.User, cfg *Config, serverIP string) (string, error) {
 	if !user.CanConnect() {
@@ -69,20 +77,17 @@ func GenerateVlessURI(user *database.User, cfg *Config, serverIP string) (string
 	}
 
 	// Формат: vless://UUID@SERVER:PORT?params#REMARK
-	params := url.Values{}
-	params.Set("type", "tcp")
-	params.Set("security", "reality")
-	params.Set("pbk", cfg.RealityPublicKey)
-	params.Set("fp", "chrome")
-	params.Set("sni", cfg.RealityServerNames[0])
-	params.Set("sid", cfg.RealityShortIds[1])
+	params := vlessURIParams(user, cfg)
+	if flow := EffectiveFlow(user, cfg); flow != "" {
+		params.Set("flow", flow)
+	}
 
 	uri := fmt.Sprintf("vless://%s@%s:%d?%s#%s",
 		user.UUID,
 		serverIP,
 		cfg.Port,
 		params.Encode(),
-		url.QueryEscape(user.Username),
+		url.QueryEscape(cfg.profileName(user, "")),
 	)
 
 	return uri, nil
@@ -94,38 +99,35 @@ func GenerateShadowrocketURI(user *database.User, cfg *Config, serverIP string)
 	return GenerateVlessURI(user, cfg, serverIP)
 }
 
-// GenerateClashConfig генерирует конфигурацию для Clash
-func GenerateClashConfig(user *database.User, cfg *Config, serverIP string) (string, error) {
+// GenerateVMessURI генерирует vmess:// ссылку (base64 JSON в формате v2rayN)
+func GenerateVMessURI(user *database.User, cfg *Config) (string, error) {
 	if !user.CanConnect() {
 		return "", fmt.Errorf("user cannot connect (inactive, expired or over limit)")
 	}
 
-	clashConfig := map[string]interface{}{
-		"proxies": []map[string]interface{}{
-			{
-				"name":    user.Username,
-				"type":    "vless",
-				"server":  serverIP,
-				"port":    cfg.Port,
-				"uuid":    user.UUID,
-				"network": "tcp",
-				"tls":     true,
-				"udp":     true,
-				"flow":    "",
-				"reality-opts": map[string]interface{}{
-					"public-key": cfg.RealityPublicKey,
-					"short-id":   cfg.RealityShortIds[1],
-				},
-			},
-		},
+	// Клиент подключается по домену: сертификат выписан на него
+	link := map[string]string{
+		"v":    "2",
+		"ps":   cfg.profileName(user, ""),
+		"add":  cfg.TLSDomain,
+		"port": strconv.Itoa(cfg.VMessPort),
+		"id":   user.UUID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  "ws",
+		"type": "none",
+		"host": cfg.TLSDomain,
+		"path": cfg.VMessPath,
+		"tls":  "tls",
+		"sni":  cfg.TLSDomain,
 	}
 
-	yamlBytes, err := json.MarshalIndent(clashConfig, "", "  ")
+	jsonBytes, err := json.Marshal(link)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal clash config: %w", err)
+		return "", fmt.Errorf("failed to marshal vmess link: %w", err)
 	}
 
-	return string(yamlBytes), nil
+	return "vmess://" + base64.StdEncoding.EncodeToString(jsonBytes), nil
 }
 
 // ClientConfigResponse представляет ответ с конфигурациями клиента
@@ -140,5 +142,7 @@ type ClientConfigResponse struct {
 	ExpiresAt    string `json:"expires_at"`
 	TrafficLimit int64  `json:"traffic_limit"`
 	TrafficUsed  int64  `json:"traffic_used"`
+	TrafficUp    int64  `json:"traffic_up"`
+	TrafficDown  int64  `json:"traffic_down"`
 	IsActive     bool   `json:"is_active"`
 }
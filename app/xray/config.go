@@ -17,14 +17,58 @@ type Config struct {
 	RealityPublicKey   string
 	RealityDest        string
 	RealityServerNames []string
-	RealityShortIds    []string
+	RealityShortIds    []string // общие shortId (по умолчанию нет); у пользователей свои (database.User.ShortID)
+	Transport          string   // tcp, xhttp, grpc, ws (см. transport.go)
 	XHTTPPath          string
+	XHTTPMode          string
+	XHTTPHost          string
+	GRPCServiceName    string
+	WSPath             string
 	LogLevel           string
 	AccessLogPath      string
 	ErrorLogPath       string
 	StatsPort          int
 	InboundTag         string
 	APITimeoutSeconds  int
+
+	// Ротация ключа Reality (см. reality.go): пока задан прежний ключ, соединения,
+	// не прошедшие проверку новым, передаются inbound с прежним ключом
+	// на RealityFallbackPort (Trojan - следующий порт, только 127.0.0.1)
+	RealityPreviousPrivateKey string
+	RealityFallbackPort       int
+
+	// Новые shortId пользователей загружаются в inbound только пересборкой Xray;
+	// пересборка откладывается на это время, чтобы загрузить сразу несколько
+	RealityShortIDDelaySeconds int
+
+	// Shadowsocks 2022 (multi-user). ShadowsocksPort = 0 отключает inbound
+	ShadowsocksPort       int
+	ShadowsocksMethod     string
+	ShadowsocksServerKey  string // base64, длина ключа зависит от метода
+	ShadowsocksInboundTag string
+
+	// Trojan поверх тех же Reality настроек. TrojanPort = 0 отключает inbound
+	TrojanPort       int
+	TrojanInboundTag string
+
+	// VMess поверх WebSocket + TLS для старых клиентов. VMessPort = 0 отключает inbound
+	VMessPort       int
+	VMessPath       string
+	VMessInboundTag string
+
+	// TLS сертификат для транспортов без Reality (VMess, VLESS over WebSocket)
+	TLSDomain   string // домен из сертификата, клиенты используют его как SNI
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Профиль Clash/Mihomo (см. clash.go)
+	ClashRules   []string // пусто = DefaultClashRules
+	ClashDNS     []string // пусто = DefaultClashDNS
+	ClashTestURL string
+
+	// DisplayName - имя сервера в клиентских профилях, различает подключения
+	// к разным нодам кластера (пусто = только имя пользователя)
+	DisplayName string
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
@@ -35,15 +79,37 @@ func DefaultConfig() *Config {
 		RealityPublicKey:   os.Getenv("XRAY_PUBLIC_KEY"),
 		RealityDest:        "eh.vk.com:443",
 		RealityServerNames: []string{"eh.vk.com"},
-		RealityShortIds:    []string{"", "0123456789abcdef"},
+		Transport:          TransportTCP,
 		XHTTPPath:          "/xhttp",
+		XHTTPMode:          "auto",
+		GRPCServiceName:    "vless-grpc",
+		WSPath:             "/ws",
 		LogLevel:           "info",
 		AccessLogPath:      "/var/log/xray/access.log",
 		ErrorLogPath:       "/var/log/xray/error.log",
 		StatsPort:          10085,
 		InboundTag:         "vless-in",
 		APITimeoutSeconds:  3,
+
+		RealityFallbackPort:        10443,
+		RealityShortIDDelaySeconds: 60,
+
+		ShadowsocksMethod:     ShadowsocksMethodAES128,
+		ShadowsocksInboundTag: "ss-in",
+
+		TrojanInboundTag: "trojan-in",
+
+		VMessPath:       "/vmess",
+		VMessInboundTag: "vmess-in",
+	}
+}
+
+// profileName возвращает имя подключения в клиентских профилях: "<DisplayName>-<username><suffix>"
+func (c *Config) profileName(user *database.User, suffix string) string {
+	if c.DisplayName == "" {
+		return user.Username + suffix
 	}
+	return c.DisplayName + "-" + user.Username + suffix
 }
 
 // GenerateConfig генерирует конфигурацию Xray из списка пользователей
@@ -73,6 +139,8 @@ func GenerateConfig(users []*database.User, cfg *Config) (*core.Config, error) {
 				"0": map[string]interface{}{
 					"statsUserUplink":   true,
 					"statsUserDownlink": true,
+					// IP адреса онлайн пользователей для лимита устройств
+					"statsUserOnline": true,
 				},
 			},
 			"system": map[string]interface{}{
@@ -143,40 +211,36 @@ func generateInbounds(users []*database.User, cfg *Config) []map[string]interfac
 	// Генерируем список клиентов из активных пользователей
 	clients := make([]map[string]interface{}, 0)
 	for _, user := range users {
-		if user.CanConnect() {
+		if user.CanConnect() && user.VlessEnabled {
 			clients = append(clients, map[string]interface{}{
 				"id":    user.UUID,
 				"email": user.Username,
-				"flow":  "",
+				"flow":  EffectiveFlow(user, cfg),
 			})
 		}
 	}
 
-	return []map[string]interface{}{
-		{
-			"port":     cfg.Port,
-			"protocol": "vless",
-			"tag":      cfg.InboundTag,
-			"settings": map[string]interface{}{
-				"clients":    clients,
-				"decryption": "none",
-			},
-			"streamSettings": map[string]interface{}{
-				"network":  "tcp",
-				"security": "reality",
-				"realitySettings": map[string]interface{}{
-					"show":        false,
-					"dest":        cfg.RealityDest,
-					"xver":        0,
-					"serverNames": cfg.RealityServerNames,
-					"privateKey":  cfg.RealityPrivateKey,
-					"shortIds":    cfg.RealityShortIds,
-				},
-			},
-			"sniffing": map[string]interface{}{
-				"enabled": false,
-			},
+	shortIDs := realityShortIDs(users, cfg)
+	if len(shortIDs) == 0 {
+		// Reality не принимает пустой список shortIds
+		shortIDs = []string{unassignedShortID()}
+	}
+	vlessInbound := map[string]interface{}{
+		"port":     cfg.Port,
+		"protocol": "vless",
+		"tag":      cfg.InboundTag,
+		"settings": map[string]interface{}{
+			"clients":    clients,
+			"decryption": "none",
+		},
+		"streamSettings": vlessStreamSettings(cfg, shortIDs),
+		"sniffing": map[string]interface{}{
+			"enabled": false,
 		},
+	}
+
+	inbounds := []map[string]interface{}{
+		vlessInbound,
 		{
 			"listen":   "0.0.0.0",
 			"port":     cfg.StatsPort,
@@ -187,6 +251,59 @@ func generateInbounds(users []*database.User, cfg *Config) []map[string]interfac
 			},
 		},
 	}
+
+	if cfg.VlessUsesReality() && cfg.RealityOverlapActive() {
+		inbounds = append(inbounds, previousKeyInbound(vlessInbound, cfg))
+	}
+
+	if cfg.ShadowsocksEnabled() {
+		// Без клиентов Xray поднимает single-user inbound, пускающий по одному
+		// серверному ключу, поэтому пустой inbound не создаем (для первого клиента
+		// Manager пересобирает экземпляр)
+		if ssInbound := generateShadowsocksInbound(users, cfg); ssInbound != nil {
+			inbounds = append(inbounds, ssInbound)
+		}
+	}
+
+	if cfg.TrojanEnabled() {
+		trojanInbound := generateTrojanInbound(users, cfg, shortIDs)
+		inbounds = append(inbounds, trojanInbound)
+		if cfg.RealityOverlapActive() {
+			inbounds = append(inbounds, previousKeyInbound(trojanInbound, cfg))
+		}
+	}
+
+	if cfg.VMessEnabled() {
+		inbounds = append(inbounds, generateVMessInbound(users, cfg))
+	}
+
+	return inbounds
+}
+
+// realityStreamSettings возвращает Reality настройки поверх TCP (Trojan и база для VLESS).
+// tag - inbound, для которого строятся настройки (нужен при ротации ключа).
+func realityStreamSettings(cfg *Config, tag string, shortIDs []string) map[string]interface{} {
+	reality := map[string]interface{}{
+		"show":        false,
+		"dest":        cfg.RealityDest,
+		"xver":        0,
+		"serverNames": cfg.RealityServerNames,
+		"privateKey":  cfg.RealityPrivateKey,
+		"shortIds":    shortIDs,
+	}
+	if cfg.RealityOverlapActive() {
+		// Клиенты с прежним ключом не проходят проверку, и Reality передает соединение
+		// как есть в inbound с прежним ключом, а тот - сайту RealityDest.
+		// PROXY protocol сохраняет адрес клиента (лимит устройств).
+		reality["dest"] = fmt.Sprintf("127.0.0.1:%d", cfg.realityFallbackPort(tag))
+		reality["xver"] = 1
+	}
+
+	return map[string]interface{}{
+		"network":         "tcp",
+		"security":        "reality",
+		"realitySettings": reality,
+	}
 }
 
 // ValidateConfig проверяет корректность конфигурации
@@ -203,6 +320,14 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("reality public key is required")
 	}
 
+	publicKey, err := RealityPublicKey(cfg.RealityPrivateKey)
+	if err != nil {
+		return err
+	}
+	if cfg.RealityPublicKey != publicKey {
+		return fmt.Errorf("reality public key does not match the private key")
+	}
+
 	if cfg.RealityDest == "" {
 		return fmt.Errorf("reality destination is required")
 	}
@@ -211,5 +336,56 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("at least one reality server name is required")
 	}
 
+	if err := validateRealityConfig(cfg); err != nil {
+		return err
+	}
+
+	if err := validateTransportConfig(cfg); err != nil {
+		return err
+	}
+
+	if cfg.ShadowsocksEnabled() {
+		if err := validateShadowsocksConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TrojanPort > 65535 {
+		return fmt.Errorf("invalid trojan port: %d", cfg.TrojanPort)
+	}
+
+	if cfg.VMessEnabled() {
+		if err := validateVMessConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	return validatePorts(cfg)
+}
+
+// validatePorts проверяет, что порты включенных inbound (и API) не совпадают
+func validatePorts(cfg *Config) error {
+	ports := []struct {
+		name    string
+		port    int
+		enabled bool
+	}{
+		{"vless", cfg.Port, true},
+		{"api", cfg.StatsPort, true},
+		{"shadowsocks", cfg.ShadowsocksPort, cfg.ShadowsocksEnabled()},
+		{"trojan", cfg.TrojanPort, cfg.TrojanEnabled()},
+		{"vmess", cfg.VMessPort, cfg.VMessEnabled()},
+	}
+
+	used := make(map[int]string, len(ports))
+	for _, inbound := range ports {
+		if !inbound.enabled {
+			continue
+		}
+		if other, ok := used[inbound.port]; ok {
+			return fmt.Errorf("%s port %d conflicts with %s port", inbound.name, inbound.port, other)
+		}
+		used[inbound.port] = inbound.name
+	}
 	return nil
 }
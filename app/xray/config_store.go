@@ -0,0 +1,48 @@
+package xray
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConfigStore хранит конфигурацию Xray панели, общую для Manager, нод и клиентских
+// конфигураций. Опубликованный снимок не меняется: Update меняет копию и публикует
+// ее целиком, поэтому читатель берет Load() один раз и работает с согласованным снимком
+// (например, ключ и цель Reality из одной ротации).
+type ConfigStore struct {
+	current atomic.Pointer[Config]
+	mu      sync.Mutex // сериализует Update
+}
+
+// NewConfigStore создает хранилище с начальной конфигурацией
+func NewConfigStore(cfg *Config) *ConfigStore {
+	store := &ConfigStore{}
+	store.current.Store(cfg)
+	return store
+}
+
+// Load возвращает текущий снимок конфигурации. Менять его нельзя.
+func (s *ConfigStore) Load() *Config {
+	return s.current.Load()
+}
+
+// Update публикует копию текущей конфигурации, измененную change, и возвращает
+// прежний снимок. change заменяет срезы целиком, а не меняет их элементы:
+// копия делит их с прежним снимком.
+func (s *ConfigStore) Update(change func(cfg *Config)) *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.current.Load()
+	next := *previous
+	change(&next)
+	s.current.Store(&next)
+	return previous
+}
+
+// Store публикует cfg целиком (например, прежний снимок при откате)
+func (s *ConfigStore) Store(cfg *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Store(cfg)
+}
@@ -0,0 +1,33 @@
+package xray
+
+import (
+	"fmt"
+	"vpn-service/database"
+)
+
+// visionSupported проверяет, поддерживает ли транспорт XTLS Vision.
+// Vision работает только поверх RAW TCP (с Reality или TLS).
+func (c *Config) visionSupported() bool {
+	return c.vlessTransport() == TransportTCP
+}
+
+// ValidateFlow проверяет, что flow допустим для текущего транспорта
+func ValidateFlow(flow string, cfg *Config) error {
+	if !database.IsValidFlow(flow) {
+		return fmt.Errorf("unknown flow: %q", flow)
+	}
+	if flow == database.FlowVision && !cfg.visionSupported() {
+		return fmt.Errorf("flow %s is not supported over %s transport", flow, cfg.vlessTransport())
+	}
+	return nil
+}
+
+// EffectiveFlow возвращает flow VLESS пользователя для inbound и клиентских конфигураций.
+// По умолчанию для TCP используется xtls-rprx-vision. Если транспорт сменили после
+// назначения Vision, flow сбрасывается - иначе клиент не сможет подключиться.
+func EffectiveFlow(user *database.User, cfg *Config) string {
+	if user.Flow == database.FlowNone || !cfg.visionSupported() {
+		return ""
+	}
+	return database.FlowVision
+}
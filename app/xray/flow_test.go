@@ -0,0 +1,59 @@
+package xray
+
+import (
+	"testing"
+	"vpn-service/database"
+)
+
+func TestEffectiveFlow(t *testing.T) {
+	tests := []struct {
+		name      string
+		flow      string
+		transport string
+		want      string
+	}{
+		{name: "auto over tcp", flow: database.FlowAuto, transport: TransportTCP, want: database.FlowVision},
+		{name: "auto over default transport", flow: database.FlowAuto, transport: "", want: database.FlowVision},
+		{name: "vision over tcp", flow: database.FlowVision, transport: TransportTCP, want: database.FlowVision},
+		{name: "none over tcp", flow: database.FlowNone, transport: TransportTCP, want: ""},
+		{name: "auto over xhttp", flow: database.FlowAuto, transport: TransportXHTTP, want: ""},
+		{name: "vision over grpc", flow: database.FlowVision, transport: TransportGRPC, want: ""},
+		{name: "vision over ws", flow: database.FlowVision, transport: TransportWebSocket, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &database.User{Flow: tt.flow}
+			cfg := &Config{Transport: tt.transport}
+			if got := EffectiveFlow(user, cfg); got != tt.want {
+				t.Errorf("EffectiveFlow(%q, %q) = %q, want %q", tt.flow, tt.transport, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFlow(t *testing.T) {
+	tests := []struct {
+		name      string
+		flow      string
+		transport string
+		wantErr   bool
+	}{
+		{name: "auto over tcp", flow: database.FlowAuto, transport: TransportTCP},
+		{name: "vision over tcp", flow: database.FlowVision, transport: TransportTCP},
+		{name: "none over grpc", flow: database.FlowNone, transport: TransportGRPC},
+		{name: "auto over xhttp", flow: database.FlowAuto, transport: TransportXHTTP},
+		{name: "vision over xhttp", flow: database.FlowVision, transport: TransportXHTTP, wantErr: true},
+		{name: "vision over ws", flow: database.FlowVision, transport: TransportWebSocket, wantErr: true},
+		{name: "unknown flow", flow: "xtls-rprx-direct", transport: TransportTCP, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFlow(tt.flow, &Config{Transport: tt.transport})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFlow(%q, %q) error = %v, wantErr %v", tt.flow, tt.transport, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,8 +17,28 @@ type Manager struct {
 	instance  *core.Instance
 	config    *Config
 	apiClient *APIClient
-	mu        sync.RWMutex
-	running   bool
+	// speed - ограничения скорости пользователей, действуют без перезапуска
+	speed   *speedLimiter
+	mu      sync.RWMutex
+	running bool
+
+	// Состояние работающего экземпляра для Reload (см. reload.go)
+	configHash string          // отпечаток настроек без пользователей
+	shortIDs   map[string]bool // shortId Reality, загруженные в inbound
+	// shadowsocksInbound - создан ли inbound Shadowsocks (без клиентов его нет)
+	shadowsocksInbound bool
+	// pendingShortIDs - shortId пользователей, добавленных через API до пересборки inbound
+	// (с какого момента ждут, см. ShortIDReloadAt)
+	pendingShortIDs map[string]time.Time
+	lastReload      *ReloadResult
+	reloadMu        sync.Mutex
+
+	// pendingTraffic - счетчики замененных экземпляров, еще не отданные QueryUserTraffic
+	pendingTraffic map[string]*UserTraffic
+
+	// users - пользователи, загруженные в inbound (копии, по email)
+	users   map[string]*database.User
+	usersMu sync.Mutex
 }
 
 const errXrayNotRunning = "xray is not running"
@@ -40,17 +60,25 @@ func NewManager(config *Config) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
+	return &Manager{
+		config:    config,
+		apiClient: newConfigAPIClient(config),
+		speed:     newSpeedLimiter(),
+		running:   false,
+		users:     make(map[string]*database.User),
+
+		pendingShortIDs: make(map[string]time.Time),
+	}, nil
+}
+
+// newConfigAPIClient создает клиент gRPC API для конфигурации
+func newConfigAPIClient(config *Config) *APIClient {
 	apiTimeout := time.Duration(config.APITimeoutSeconds) * time.Second
 	if apiTimeout <= 0 {
 		apiTimeout = 3 * time.Second
 	}
 	apiAddress := fmt.Sprintf("127.0.0.1:%d", config.StatsPort)
-
-	return &Manager{
-		config:    config,
-		apiClient: NewAPIClient(apiAddress, config.InboundTag, apiTimeout),
-		running:   false,
-	}, nil
+	return NewAPIClient(apiAddress, config.InboundTag, apiTimeout)
 }
 
 // Start запускает Xray сервер
@@ -69,9 +97,9 @@ func (m *Manager) Start(users []*database.User) error {
 	}
 
 	// Создаем экземпляр Xray
-	instance, err := core.New(coreConfig)
+	instance, err := m.newInstance(coreConfig, users)
 	if err != nil {
-		return fmt.Errorf("failed to create xray instance: %w", err)
+		return err
 	}
 
 	// Запускаем сервер
@@ -80,12 +108,29 @@ func (m *Manager) Start(users []*database.User) error {
 	}
 
 	m.instance = instance
+	m.configHash = configHash(m.config)
+	m.shortIDs = shortIDSet(realityShortIDs(users, m.config))
+	m.shadowsocksInbound = hasShadowsocksInbound(users, m.config)
+	m.prunePendingShortIDs()
 	m.running = true
+	m.setLoadedUsers(users)
 
 	log.Printf("Xray started successfully on port %d", m.config.Port)
 	return nil
 }
 
+// newInstance создает экземпляр Xray с ограничением скорости перед outbound direct
+func (m *Manager) newInstance(coreConfig *core.Config, users []*database.User) (*core.Instance, error) {
+	instance, err := core.New(coreConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create xray instance: %w", err)
+	}
+
+	m.speed.reset(users)
+	installSpeedLimiter(instance, m.speed)
+	return instance, nil
+}
+
 // Stop останавливает Xray сервер
 func (m *Manager) Stop() error {
 	m.mu.Lock()
@@ -103,25 +148,29 @@ func (m *Manager) Stop() error {
 
 	m.instance = nil
 	m.running = false
+	m.setLoadedUsers(nil)
 
 	log.Println("Xray stopped successfully")
 	return nil
 }
 
-// Restart перезапускает Xray с новым списком пользователей
+// Restart пересоздает экземпляр Xray с новым списком пользователей.
+// Новый экземпляр запускается до остановки старого (см. Reload).
 func (m *Manager) Restart(users []*database.User) error {
 	log.Println("Restarting Xray...")
 
-	// Останавливаем если запущен
-	if m.IsRunning() {
-		if err := m.Stop(); err != nil {
-			return fmt.Errorf("failed to stop xray: %w", err)
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if !m.IsRunning() {
+		if err := m.Start(users); err != nil {
+			return fmt.Errorf("failed to start xray: %w", err)
 		}
+		return nil
 	}
 
-	// Запускаем с новой конфигурацией
-	if err := m.Start(users); err != nil {
-		return fmt.Errorf("failed to start xray: %w", err)
+	if _, err := m.rebuild(users, "restart requested"); err != nil {
+		return err
 	}
 
 	log.Println("Xray restarted successfully")
@@ -142,45 +191,179 @@ func (m *Manager) GetConfig() *Config {
 	return m.config
 }
 
-// UpdateUsers обновляет список пользователей (перезапускает сервер)
+// api возвращает клиент gRPC API работающего Xray
+func (m *Manager) api() (*APIClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.running {
+		return nil, fmt.Errorf(errXrayNotRunning)
+	}
+	if m.apiClient == nil {
+		return nil, fmt.Errorf("xray api client is not initialized")
+	}
+	return m.apiClient, nil
+}
+
+// UpdateUsers приводит пользователей Xray к списку users.
+// Если настройки не менялись, пользователи меняются через API без перезапуска.
 func (m *Manager) UpdateUsers(users []*database.User) error {
 	log.Printf("Updating Xray users (total: %d, active: %d)",
 		len(users), countActiveUsers(users))
-	return m.Restart(users)
+	_, err := m.Reload(users)
+	return err
 }
 
-// AddUserHot добавляет пользователя через Xray API без перезапуска.
+// AddUserHot добавляет пользователя через Xray API без перезапуска
+// во все inbound разрешенных ему протоколов. Новый shortId Reality пользователя
+// через API не добавить: до пересборки inbound (см. ShortIDReloadAt) клиент
+// подключается с общим shortId (см. ClientView).
 func (m *Manager) AddUserHot(user *database.User) error {
-	if !m.IsRunning() {
-		return fmt.Errorf(errXrayNotRunning)
+	client, err := m.api()
+	if err != nil {
+		return err
 	}
-	if m.apiClient == nil {
-		return fmt.Errorf("xray api client is not initialized")
+	if user == nil {
+		return fmt.Errorf("user is nil")
+	}
+
+	cfg := m.GetConfig()
+	if m.missingShadowsocksInbound([]*database.User{user}, cfg) {
+		// Проверяется до добавления в другие inbound, чтобы не оставить пользователя
+		// загруженным наполовину
+		return fmt.Errorf("shadowsocks inbound is not loaded, rebuild required")
+	}
+	for _, tag := range userInboundTags(user, cfg) {
+		protoUser, err := buildInboundUser(tag, user, cfg)
+		if err != nil {
+			return err
+		}
+		if err := client.AddInboundUser(tag, protoUser); err != nil {
+			return fmt.Errorf("failed to add user to %s: %w", tag, err)
+		}
 	}
-	return m.apiClient.AddUser(user)
+	m.speed.set(user)
+	m.setLoadedUser(user)
+	m.markPendingShortIDs([]*database.User{user}, cfg)
+	return nil
 }
 
-// RemoveUserHot удаляет пользователя через Xray API без перезапуска.
+// RemoveUserHot удаляет пользователя через Xray API без перезапуска
+// из всех inbound разрешенных ему протоколов.
 func (m *Manager) RemoveUserHot(user *database.User) error {
-	if !m.IsRunning() {
-		return fmt.Errorf(errXrayNotRunning)
+	client, err := m.api()
+	if err != nil {
+		return err
 	}
-	if m.apiClient == nil {
-		return fmt.Errorf("xray api client is not initialized")
+	if user == nil {
+		return fmt.Errorf("user is nil")
+	}
+
+	for _, tag := range userInboundTags(user, m.GetConfig()) {
+		if err := client.RemoveInboundUser(tag, user.Username); err != nil {
+			return fmt.Errorf("failed to remove user from %s: %w", tag, err)
+		}
+	}
+	m.speed.remove(user.Username)
+	m.forgetLoadedUser(user.Username)
+	return nil
+}
+
+// userInboundTags возвращает теги inbound, в которых должен быть пользователь,
+// включая inbound с прежним ключом Reality во время ротации
+func userInboundTags(user *database.User, cfg *Config) []string {
+	tags := make([]string, 0, 4)
+	if user.VlessEnabled {
+		tags = append(tags, cfg.InboundTag)
+	}
+	if cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled {
+		tags = append(tags, cfg.ShadowsocksInboundTag)
+	}
+	if cfg.TrojanEnabled() && user.TrojanEnabled {
+		tags = append(tags, cfg.TrojanInboundTag)
+	}
+	if cfg.VMessEnabled() && user.VmessEnabled {
+		tags = append(tags, cfg.VMessInboundTag)
+	}
+	return cfg.previousKeyTags(tags)
+}
+
+// QueryUserTraffic возвращает счетчики трафика пользователей из StatsService.
+// При reset=true счетчики в Xray обнуляются после чтения, а к результату добавляется
+// трафик экземпляров, замененных пересборкой после прошлого опроса.
+func (m *Manager) QueryUserTraffic(reset bool) (map[string]*UserTraffic, error) {
+	client, err := m.api()
+	if err != nil {
+		return nil, err
+	}
+	traffic, err := client.QueryUserTraffic(reset)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for email, pending := range m.pendingTraffic {
+		entry, ok := traffic[email]
+		if !ok {
+			entry = &UserTraffic{Email: email}
+			traffic[email] = entry
+		}
+		entry.Uplink += pending.Uplink
+		entry.Downlink += pending.Downlink
+	}
+	if reset {
+		m.pendingTraffic = nil
+	}
+	return traffic, nil
+}
+
+// SetSpeedLimit применяет ограничение скорости пользователя без перезапуска.
+// Новые значения действуют и на уже открытые соединения, кроме соединений XTLS Vision,
+// открытых без ограничения (см. speedLimiter).
+func (m *Manager) SetSpeedLimit(user *database.User) {
+	if user.CanConnect() {
+		m.speed.set(user)
+	} else {
+		m.speed.remove(user.Username)
+	}
+}
+
+// SetThrottleHook задает обработчик задержек трафика по ограничению скорости (метрики)
+func (m *Manager) SetThrottleHook(hook ThrottleHook) {
+	m.speed.setHook(hook)
+}
+
+// QueryOnlineIPs возвращает IP адреса, с которых пользователи сейчас подключены
+func (m *Manager) QueryOnlineIPs(emails []string) (map[string][]OnlineIP, error) {
+	client, err := m.api()
+	if err != nil {
+		return nil, err
+	}
+	return client.QueryOnlineIPs(emails)
+}
+
+// CheckAPI проверяет, что Xray запущен и отвечает по gRPC API
+func (m *Manager) CheckAPI() error {
+	client, err := m.api()
+	if err != nil {
+		return err
 	}
-	return m.apiClient.RemoveUser(user)
+	return client.Ping()
 }
 
-// AddUser добавляет пользователя (перезапускает сервер)
+// AddUser добавляет пользователя (применяет полный список через Reload)
 func (m *Manager) AddUser(users []*database.User) error {
 	log.Printf("Adding user to Xray, total users: %d", len(users))
-	return m.Restart(users)
+	_, err := m.Reload(users)
+	return err
 }
 
-// RemoveUser удаляет пользователя (перезапускает сервер)
+// RemoveUser удаляет пользователя (применяет полный список через Reload)
 func (m *Manager) RemoveUser(users []*database.User) error {
 	log.Printf("Removing user from Xray, remaining users: %d", len(users))
-	return m.Restart(users)
+	_, err := m.Reload(users)
+	return err
 }
 
 // countActiveUsers подсчитывает количество активных пользователей
@@ -0,0 +1,179 @@
+package xray
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"vpn-service/database"
+)
+
+// previousKeyTagSuffix - суффикс тега inbound с прежним ключом Reality на время ротации
+const previousKeyTagSuffix = "-prev"
+
+// GenerateRealityKeyPair генерирует пару ключей x25519 для Reality
+// в формате xray x25519 (base64 raw URL)
+func GenerateRealityKeyPair() (privateKey, publicKey string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate x25519 key: %w", err)
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(key.Bytes()), encoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// RealityPublicKey вычисляет публичный ключ Reality по приватному
+func RealityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// RealityOverlapActive проверяет, идет ли ротация ключа Reality:
+// клиенты с прежним публичным ключом еще принимаются
+func (c *Config) RealityOverlapActive() bool {
+	return c.RealityPreviousPrivateKey != ""
+}
+
+// usesReality проверяет, есть ли в конфигурации inbound с Reality
+func (c *Config) usesReality() bool {
+	return c.VlessUsesReality() || c.TrojanEnabled()
+}
+
+// realityFallbackPort возвращает локальный порт inbound с прежним ключом для inbound tag
+func (c *Config) realityFallbackPort(tag string) int {
+	if tag == c.TrojanInboundTag {
+		return c.RealityFallbackPort + 1
+	}
+	return c.RealityFallbackPort
+}
+
+// previousKeyTags возвращает теги inbound с прежним ключом для тегов Reality inbound из tags
+func (c *Config) previousKeyTags(tags []string) []string {
+	if !c.RealityOverlapActive() {
+		return tags
+	}
+	for _, tag := range tags {
+		if (tag == c.InboundTag && c.VlessUsesReality()) || tag == c.TrojanInboundTag {
+			tags = append(tags, tag+previousKeyTagSuffix)
+		}
+	}
+	return tags
+}
+
+// clientShortID возвращает shortId Reality для клиента: собственный у пользователя,
+// общий из RealityShortIds - у пользователей без него
+func (c *Config) clientShortID(user *database.User) string {
+	if user.ShortID != "" {
+		return user.ShortID
+	}
+	for _, id := range c.RealityShortIds {
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// realityShortIDs возвращает shortId Reality inbound: общие и всех пользователей.
+// Отключенные пользователи тоже входят, чтобы их включение обходилось без пересборки.
+func realityShortIDs(users []*database.User, cfg *Config) []string {
+	seen := make(map[string]bool, len(cfg.RealityShortIds)+len(users))
+	ids := make([]string, 0, len(cfg.RealityShortIds)+len(users))
+	for _, id := range cfg.RealityShortIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	userIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.ShortID != "" && !seen[user.ShortID] {
+			seen[user.ShortID] = true
+			userIDs = append(userIDs, user.ShortID)
+		}
+	}
+	sort.Strings(userIDs)
+	return append(ids, userIDs...)
+}
+
+// unassignedShortID возвращает случайный shortId, которого нет ни у кого из клиентов:
+// inbound без пользователей и общих shortId не пускает никого
+func unassignedShortID() string {
+	id := make([]byte, database.ShortIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		// Без случайности подставляем shortId, который не выдается пользователям
+		return "00"
+	}
+	return hex.EncodeToString(id)
+}
+
+// previousKeyInbound возвращает копию Reality inbound с прежним ключом на локальном порту.
+// Основной inbound передает ему соединения, не прошедшие проверку новым ключом.
+func previousKeyInbound(inbound map[string]interface{}, cfg *Config) map[string]interface{} {
+	tag := inbound["tag"].(string)
+
+	previous := make(map[string]interface{}, len(inbound)+1)
+	for key, value := range inbound {
+		previous[key] = value
+	}
+	previous["tag"] = tag + previousKeyTagSuffix
+	previous["listen"] = "127.0.0.1"
+	previous["port"] = cfg.realityFallbackPort(tag)
+
+	streamSettings := make(map[string]interface{})
+	for key, value := range inbound["streamSettings"].(map[string]interface{}) {
+		streamSettings[key] = value
+	}
+	reality := make(map[string]interface{})
+	for key, value := range streamSettings["realitySettings"].(map[string]interface{}) {
+		reality[key] = value
+	}
+	reality["privateKey"] = cfg.RealityPreviousPrivateKey
+	reality["dest"] = cfg.RealityDest
+	reality["xver"] = 0
+	streamSettings["realitySettings"] = reality
+	// Адрес клиента приходит от основного inbound в PROXY protocol
+	streamSettings["sockopt"] = map[string]interface{}{
+		"acceptProxyProtocol": true,
+	}
+	previous["streamSettings"] = streamSettings
+
+	return previous
+}
+
+// validateRealityConfig проверяет параметры ротации ключа Reality
+func validateRealityConfig(cfg *Config) error {
+	for _, id := range cfg.RealityShortIds {
+		if len(id) > 2*database.ShortIDBytes || strings.Trim(id, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid reality short id: %q", id)
+		}
+	}
+
+	if !cfg.RealityOverlapActive() {
+		return nil
+	}
+	if _, err := RealityPublicKey(cfg.RealityPreviousPrivateKey); err != nil {
+		return fmt.Errorf("previous %w", err)
+	}
+	port := cfg.RealityFallbackPort
+	if port <= 0 || port+1 > 65535 {
+		return fmt.Errorf("invalid reality fallback port: %d", port)
+	}
+	for _, used := range []int{cfg.Port, cfg.TrojanPort, cfg.StatsPort, cfg.ShadowsocksPort, cfg.VMessPort} {
+		if used == port || used == port+1 {
+			return fmt.Errorf("reality fallback port %d conflicts with port %d", port, used)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package xray
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RealityTarget - сайт, под который маскируется Reality: dest inbound и SNI клиентов
+type RealityTarget struct {
+	Dest       string `json:"dest"` // host:port
+	ServerName string `json:"server_name"`
+}
+
+// String возвращает цель в формате XRAY_REALITY_TARGETS
+func (t RealityTarget) String() string {
+	return t.Dest + "=" + t.ServerName
+}
+
+// ParseRealityTargets разбирает список целей Reality в формате "host[:port][=sni]".
+// Порт по умолчанию 443, SNI по умолчанию - host.
+func ParseRealityTargets(items []string) ([]RealityTarget, error) {
+	targets := make([]RealityTarget, 0, len(items))
+	for _, item := range items {
+		dest, serverName, _ := strings.Cut(item, "=")
+		dest = strings.TrimSpace(dest)
+		serverName = strings.TrimSpace(serverName)
+
+		host, port, err := net.SplitHostPort(dest)
+		if err != nil {
+			host, port = dest, "443"
+		}
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("invalid reality target: %q", item)
+		}
+		if serverName == "" {
+			serverName = host
+		}
+		targets = append(targets, RealityTarget{Dest: net.JoinHostPort(host, port), ServerName: serverName})
+	}
+	return targets, nil
+}
+
+// ProbeRealityTarget проверяет, что сайт подходит для Reality: отвечает для SNI
+// по TLS 1.3 с обменом ключами X25519, согласует h2 и предъявляет валидный сертификат
+func ProbeRealityTarget(target RealityTarget, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", target.Dest, &tls.Config{
+		ServerName:       target.ServerName,
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519},
+		NextProtos:       []string{"h2"},
+	})
+	if err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	switch {
+	case state.Version != tls.VersionTLS13:
+		return fmt.Errorf("tls 1.3 is not negotiated")
+	case state.CurveID != tls.X25519:
+		return fmt.Errorf("x25519 key exchange is not negotiated (got %v)", state.CurveID)
+	case state.NegotiatedProtocol != "h2":
+		return fmt.Errorf("h2 is not negotiated (got %q)", state.NegotiatedProtocol)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package xray
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRealityTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []string
+		want    []RealityTarget
+		wantErr bool
+	}{
+		{
+			name:  "host only",
+			items: []string{"eh.vk.com"},
+			want:  []RealityTarget{{Dest: "eh.vk.com:443", ServerName: "eh.vk.com"}},
+		},
+		{
+			name:  "host and port",
+			items: []string{"www.microsoft.com:8443"},
+			want:  []RealityTarget{{Dest: "www.microsoft.com:8443", ServerName: "www.microsoft.com"}},
+		},
+		{
+			name:  "explicit sni",
+			items: []string{"1.2.3.4:443=www.example.com"},
+			want:  []RealityTarget{{Dest: "1.2.3.4:443", ServerName: "www.example.com"}},
+		},
+		{
+			name:  "spaces are trimmed",
+			items: []string{" eh.vk.com = vk.com "},
+			want:  []RealityTarget{{Dest: "eh.vk.com:443", ServerName: "vk.com"}},
+		},
+		{
+			name:  "ipv6",
+			items: []string{"[2001:db8::1]:443=www.example.com"},
+			want:  []RealityTarget{{Dest: "[2001:db8::1]:443", ServerName: "www.example.com"}},
+		},
+		{
+			name:  "order is kept",
+			items: []string{"a.example.com", "b.example.com:8443"},
+			want: []RealityTarget{
+				{Dest: "a.example.com:443", ServerName: "a.example.com"},
+				{Dest: "b.example.com:8443", ServerName: "b.example.com"},
+			},
+		},
+		{
+			name:  "empty list",
+			items: nil,
+			want:  []RealityTarget{},
+		},
+		{name: "empty item", items: []string{""}, wantErr: true},
+		{name: "only sni", items: []string{"=www.example.com"}, wantErr: true},
+		{name: "empty port", items: []string{"eh.vk.com:"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRealityTargets(tt.items)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRealityTargets(%q) error = %v, wantErr %v", tt.items, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRealityTargets(%q) = %v, want %v", tt.items, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealityTargetStringRoundTrip(t *testing.T) {
+	target := RealityTarget{Dest: "1.2.3.4:443", ServerName: "www.example.com"}
+	parsed, err := ParseRealityTargets([]string{target.String()})
+	if err != nil {
+		t.Fatalf("ParseRealityTargets() error = %v", err)
+	}
+	if parsed[0] != target {
+		t.Errorf("round trip = %v, want %v", parsed[0], target)
+	}
+}
@@ -0,0 +1,47 @@
+package xray
+
+import (
+	"reflect"
+	"testing"
+	"vpn-service/database"
+)
+
+func TestRealityShortIDs(t *testing.T) {
+	users := func(ids ...string) []*database.User {
+		result := make([]*database.User, 0, len(ids))
+		for _, id := range ids {
+			result = append(result, &database.User{ShortID: id})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		shared []string
+		users  []*database.User
+		want   []string
+	}{
+		{name: "nothing", want: []string{}},
+		{name: "shared only", shared: []string{"", "0123abcd"}, want: []string{"", "0123abcd"}},
+		{name: "user ids are sorted after shared", shared: []string{"ff"}, users: users("cc", "aa", "bb"), want: []string{"ff", "aa", "bb", "cc"}},
+		{name: "users without short id are skipped", users: users("", "aa", ""), want: []string{"aa"}},
+		{name: "duplicates are removed", shared: []string{"aa", "aa"}, users: users("aa", "bb", "bb"), want: []string{"aa", "bb"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{RealityShortIds: tt.shared}
+			if got := realityShortIDs(tt.users, cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("realityShortIDs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealityShortIDsIncludeDisabledUsers(t *testing.T) {
+	disabled := &database.User{ShortID: "aa", IsActive: false}
+	got := realityShortIDs([]*database.User{disabled}, &Config{})
+	if !reflect.DeepEqual(got, []string{"aa"}) {
+		t.Errorf("realityShortIDs() = %q, want disabled user short id", got)
+	}
+}
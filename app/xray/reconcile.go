@@ -0,0 +1,229 @@
+package xray
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+	"vpn-service/database"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vmess"
+)
+
+// Виды расхождений между БД и пользователями в inbound
+const (
+	DriftMissing = "missing" // пользователь может подключаться, но его нет в inbound
+	DriftExtra   = "extra"   // пользователь в inbound, но подключаться не должен
+	DriftChanged = "changed" // учетная запись в inbound устарела (UUID, flow, пароль)
+)
+
+// DriftEntry - одно расхождение и результат его исправления
+type DriftEntry struct {
+	Email   string `json:"email"`
+	Inbound string `json:"inbound"`
+	Kind    string `json:"kind"`
+	Fixed   bool   `json:"fixed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ReconcileReport - результат сверки пользователей Xray с БД
+type ReconcileReport struct {
+	Inbounds []string     `json:"inbounds"`
+	Expected int          `json:"expected"` // учетных записей должно быть во всех inbound
+	Loaded   int          `json:"loaded"`   // учетных записей было до исправления
+	Drift    []DriftEntry `json:"drift"`
+	At       time.Time    `json:"at"`
+}
+
+// Failed возвращает число расхождений, которые не удалось исправить
+func (r *ReconcileReport) Failed() int {
+	failed := 0
+	for _, entry := range r.Drift {
+		if !entry.Fixed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Reconcile сверяет пользователей, загруженных в inbound (HandlerService.GetInboundUsers),
+// с пользователями users, которые могут подключаться, и исправляет расхождения через API.
+// Inbound Shadowsocks без клиентов не создается: если он нужен, недостающие учетные
+// записи загружаются пересборкой Xray.
+func (m *Manager) Reconcile(users []*database.User) (*ReconcileReport, error) {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	client, err := m.api()
+	if err != nil {
+		return nil, err
+	}
+	cfg := m.GetConfig()
+	m.mu.RLock()
+	shadowsocksInbound := m.shadowsocksInbound
+	m.mu.RUnlock()
+
+	// Ожидаемые учетные записи по inbound: email -> учетная запись
+	expected := make(map[string]map[string]*protocol.User)
+	tags := inboundTags(cfg, cfg.ShadowsocksEnabled())
+	for _, tag := range tags {
+		expected[tag] = make(map[string]*protocol.User)
+	}
+	for _, user := range loadedUsers(users) {
+		for _, tag := range userInboundTags(user, cfg) {
+			protoUser, err := buildInboundUser(tag, user, cfg)
+			if err != nil {
+				log.Printf("Reconcile: skipping user %s in %s: %v", user.Username, tag, err)
+				continue
+			}
+			expected[tag][user.Username] = protoUser
+		}
+	}
+
+	// Существующие inbound сверяются через API, отсутствующие считаются пустыми
+	existing := inboundTags(cfg, shadowsocksInbound)
+	report := &ReconcileReport{Inbounds: tags, Drift: []DriftEntry{}, At: time.Now()}
+	var rebuild []DriftEntry
+	for _, tag := range tags {
+		actual := make(map[string]*protocol.User)
+		if slices.Contains(existing, tag) {
+			loaded, err := client.GetInboundUsers(tag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list users of %s: %w", tag, err)
+			}
+			for _, protoUser := range loaded {
+				actual[protoUser.GetEmail()] = protoUser
+			}
+		}
+		report.Loaded += len(actual)
+		report.Expected += len(expected[tag])
+
+		for _, entry := range inboundDrift(tag, accountKeys(expected[tag]), accountKeys(actual)) {
+			if !slices.Contains(existing, tag) {
+				rebuild = append(rebuild, entry)
+				continue
+			}
+
+			var err error
+			switch entry.Kind {
+			case DriftExtra:
+				err = client.RemoveInboundUser(tag, entry.Email)
+			case DriftChanged:
+				err = client.RemoveInboundUser(tag, entry.Email)
+				if err == nil {
+					err = client.AddInboundUser(tag, expected[tag][entry.Email])
+				}
+			case DriftMissing:
+				err = client.AddInboundUser(tag, expected[tag][entry.Email])
+			}
+			report.add(entry, err)
+		}
+	}
+
+	if len(rebuild) > 0 {
+		_, err := m.rebuild(users, "shadowsocks inbound")
+		for _, entry := range rebuild {
+			report.add(entry, err)
+		}
+	}
+
+	// После сверки inbound соответствуют users
+	if report.Failed() == 0 {
+		m.setLoadedUsers(users)
+		m.speed.reset(users)
+	}
+	return report, nil
+}
+
+func (r *ReconcileReport) add(entry DriftEntry, err error) {
+	entry.Fixed = err == nil
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.Drift = append(r.Drift, entry)
+}
+
+// inboundDrift сравнивает учетные записи inbound tag: expected и actual - email -> accountKey.
+// Возвращает расхождения без исправления: сначала лишние и устаревшие, затем недостающие.
+func inboundDrift(tag string, expected, actual map[string]string) []DriftEntry {
+	var drift []DriftEntry
+	for _, email := range sortedKeys(actual) {
+		want, ok := expected[email]
+		switch {
+		case !ok:
+			drift = append(drift, DriftEntry{Email: email, Inbound: tag, Kind: DriftExtra})
+		case actual[email] != want:
+			drift = append(drift, DriftEntry{Email: email, Inbound: tag, Kind: DriftChanged})
+		}
+	}
+	for _, email := range sortedKeys(expected) {
+		if _, ok := actual[email]; !ok {
+			drift = append(drift, DriftEntry{Email: email, Inbound: tag, Kind: DriftMissing})
+		}
+	}
+	return drift
+}
+
+// inboundTags возвращает теги inbound с пользователями для конфигурации.
+// shadowsocksInbound - есть ли inbound Shadowsocks (без клиентов он не создается).
+func inboundTags(cfg *Config, shadowsocksInbound bool) []string {
+	tags := []string{cfg.InboundTag}
+	if cfg.ShadowsocksEnabled() && shadowsocksInbound {
+		tags = append(tags, cfg.ShadowsocksInboundTag)
+	}
+	if cfg.TrojanEnabled() {
+		tags = append(tags, cfg.TrojanInboundTag)
+	}
+	if cfg.VMessEnabled() {
+		tags = append(tags, cfg.VMessInboundTag)
+	}
+	return cfg.previousKeyTags(tags)
+}
+
+// accountKeys возвращает отпечатки учетных записей: email -> accountKey
+func accountKeys(users map[string]*protocol.User) map[string]string {
+	keys := make(map[string]string, len(users))
+	for email, user := range users {
+		keys[email] = accountKey(user)
+	}
+	return keys
+}
+
+// accountKey возвращает отпечаток учетной записи: то, что нужно сравнить,
+// чтобы понять, пустит ли inbound пользователя с текущими данными
+func accountKey(user *protocol.User) string {
+	if user.GetAccount() == nil {
+		return ""
+	}
+	account, err := user.GetAccount().GetInstance()
+	if err != nil {
+		return ""
+	}
+
+	switch account := account.(type) {
+	case *vless.Account:
+		return "vless:" + account.Id + ":" + account.Flow
+	case *vmess.Account:
+		return "vmess:" + account.Id
+	case *trojan.Account:
+		return "trojan:" + account.Password
+	case *shadowsocks_2022.Account:
+		return "ss2022:" + account.Key
+	default:
+		return ""
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package xray
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInboundTags(t *testing.T) {
+	tests := []struct {
+		name               string
+		cfg                func(cfg *Config)
+		shadowsocksInbound bool
+		want               []string
+	}{
+		{
+			name: "vless only",
+			want: []string{"vless-in"},
+		},
+		{
+			name:               "shadowsocks with inbound",
+			cfg:                func(cfg *Config) { cfg.ShadowsocksPort = 8388 },
+			shadowsocksInbound: true,
+			want:               []string{"vless-in", "ss-in"},
+		},
+		{
+			name: "shadowsocks without clients has no inbound",
+			cfg:  func(cfg *Config) { cfg.ShadowsocksPort = 8388 },
+			want: []string{"vless-in"},
+		},
+		{
+			name:               "shadowsocks disabled",
+			shadowsocksInbound: true,
+			want:               []string{"vless-in"},
+		},
+		{
+			name: "all protocols",
+			cfg: func(cfg *Config) {
+				cfg.ShadowsocksPort = 8388
+				cfg.TrojanPort = 8443
+				cfg.VMessPort = 2053
+			},
+			shadowsocksInbound: true,
+			want:               []string{"vless-in", "ss-in", "trojan-in", "vmess-in"},
+		},
+		{
+			name: "reality key overlap",
+			cfg: func(cfg *Config) {
+				cfg.TrojanPort = 8443
+				cfg.RealityPreviousPrivateKey = "previous-key"
+			},
+			want: []string{"vless-in", "trojan-in", "vless-in" + previousKeyTagSuffix, "trojan-in" + previousKeyTagSuffix},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+			if got := inboundTags(cfg, tt.shadowsocksInbound); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inboundTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInboundDrift(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		actual   map[string]string
+		want     []DriftEntry
+	}{
+		{
+			name:     "in sync",
+			expected: map[string]string{"alice": "vless:1", "bob": "vless:2"},
+			actual:   map[string]string{"alice": "vless:1", "bob": "vless:2"},
+			want:     nil,
+		},
+		{
+			name:     "extra, changed and missing",
+			expected: map[string]string{"alice": "vless:1", "carol": "vless:3"},
+			actual:   map[string]string{"alice": "vless:old", "bob": "vless:2"},
+			want: []DriftEntry{
+				{Email: "alice", Inbound: "vless-in", Kind: DriftChanged},
+				{Email: "bob", Inbound: "vless-in", Kind: DriftExtra},
+				{Email: "carol", Inbound: "vless-in", Kind: DriftMissing},
+			},
+		},
+		{
+			name:     "missing users are sorted",
+			expected: map[string]string{"dave": "vless:4", "bob": "vless:2"},
+			actual:   map[string]string{},
+			want: []DriftEntry{
+				{Email: "bob", Inbound: "vless-in", Kind: DriftMissing},
+				{Email: "dave", Inbound: "vless-in", Kind: DriftMissing},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inboundDrift("vless-in", tt.expected, tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inboundDrift() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Пока у Shadowsocks нет клиентов, его inbound нет: сверка не запрашивает его
+// через API, а первые клиенты считаются недостающими (их загружает пересборка)
+func TestInboundDriftWithoutShadowsocksInbound(t *testing.T) {
+	cfg := testConfig()
+	cfg.ShadowsocksPort = 8388
+
+	existing := inboundTags(cfg, false)
+	expectedTags := inboundTags(cfg, true)
+	if reflect.DeepEqual(existing, expectedTags) {
+		t.Fatalf("shadowsocks inbound must not be listed while it does not exist: %v", existing)
+	}
+
+	drift := inboundDrift(cfg.ShadowsocksInboundTag, map[string]string{"alice": "ss2022:key"}, map[string]string{})
+	want := []DriftEntry{{Email: "alice", Inbound: "ss-in", Kind: DriftMissing}}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("inboundDrift() = %+v, want %+v", drift, want)
+	}
+}
@@ -0,0 +1,468 @@
+package xray
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+	"vpn-service/database"
+
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/stats"
+)
+
+// Пути, которыми Reload применяет изменения
+const (
+	ReloadPathStart   = "start"   // Xray не был запущен
+	ReloadPathNone    = "none"    // изменений нет
+	ReloadPathHot     = "hot"     // пользователи изменены через HandlerService, соединения сохранены
+	ReloadPathRebuild = "rebuild" // изменились настройки помимо пользователей, экземпляр пересоздан
+)
+
+// ReloadResult описывает, как Manager применил изменения
+type ReloadResult struct {
+	Path    string    `json:"path"`
+	Added   int       `json:"added"`
+	Removed int       `json:"removed"`
+	Updated int       `json:"updated"`
+	Reason  string    `json:"reason,omitempty"` // причина пересборки
+	At      time.Time `json:"at"`
+}
+
+// Reload приводит работающий Xray к списку users и текущей конфигурации.
+// Если изменились только пользователи, разница применяется через HandlerService
+// и подключенные пользователи не теряют соединения. Если изменились другие настройки
+// (ключи Reality, порты, транспорт) или API не справился, новый экземпляр Xray
+// запускается до остановки старого; при ошибке запуска работает прежний.
+func (m *Manager) Reload(users []*database.User) (*ReloadResult, error) {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if !m.IsRunning() {
+		if err := m.Start(users); err != nil {
+			return nil, err
+		}
+		return m.reported(&ReloadResult{Path: ReloadPathStart, Added: countActiveUsers(users)}), nil
+	}
+
+	m.mu.RLock()
+	changed := configHash(m.config) != m.configHash
+	m.mu.RUnlock()
+	if changed {
+		return m.rebuild(users, "config changed")
+	}
+	// shortId Reality задаются на весь inbound, через API их не добавить. Пользователи
+	// с новыми shortId добавляются через API сразу, а shortId копятся и загружаются
+	// одной пересборкой, когда самый старый прождал RealityShortIDDelaySeconds
+	if m.markPendingShortIDs(users, m.GetConfig()) {
+		return m.rebuild(users, "new reality short ids")
+	}
+	// Inbound Shadowsocks без клиентов не создается, первого клиента через API не добавить
+	if m.missingShadowsocksInbound(users, m.GetConfig()) {
+		return m.rebuild(users, "shadowsocks inbound")
+	}
+
+	result, err := m.reloadUsers(users)
+	if err != nil {
+		log.Printf("Warning: hot reload failed, rebuilding Xray: %v", err)
+		return m.rebuild(users, "hot reload failed: "+err.Error())
+	}
+	return m.reported(result), nil
+}
+
+// Apply заменяет конфигурацию и применяет ее через Reload.
+// При ошибке прежняя конфигурация восстанавливается.
+func (m *Manager) Apply(config *Config, users []*database.User) (*ReloadResult, error) {
+	if err := ValidateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(config.AccessLogPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	m.mu.Lock()
+	previous, previousClient := m.config, m.apiClient
+	m.config = config
+	m.apiClient = newConfigAPIClient(config)
+	m.mu.Unlock()
+
+	result, err := m.Reload(users)
+	if err != nil {
+		m.mu.Lock()
+		m.config, m.apiClient = previous, previousClient
+		m.mu.Unlock()
+		return nil, err
+	}
+	return result, nil
+}
+
+// LastReload возвращает результат последнего Reload (nil - еще не было)
+func (m *Manager) LastReload() *ReloadResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.lastReload == nil {
+		return nil
+	}
+	result := *m.lastReload
+	return &result
+}
+
+// reloadUsers применяет разницу пользователей через API. Вызывается под reloadMu.
+func (m *Manager) reloadUsers(users []*database.User) (*ReloadResult, error) {
+	cfg := m.GetConfig()
+	desired := loadedUsers(users)
+
+	m.usersMu.Lock()
+	current := make(map[string]*database.User, len(m.users))
+	for email, user := range m.users {
+		current[email] = user
+	}
+	m.usersMu.Unlock()
+
+	result := &ReloadResult{Path: ReloadPathHot}
+	for email, user := range current {
+		next, ok := desired[email]
+		if ok && sameInboundAccount(user, next, cfg) {
+			continue
+		}
+		if err := m.RemoveUserHot(user); err != nil {
+			return nil, err
+		}
+		if ok {
+			result.Updated++
+		} else {
+			result.Removed++
+		}
+	}
+
+	for email, next := range desired {
+		user, ok := current[email]
+		if ok && sameInboundAccount(user, next, cfg) {
+			// Учетная запись не изменилась, но скорость могла
+			m.speed.set(next)
+			m.setLoadedUser(next)
+			continue
+		}
+		if err := m.AddUserHot(next); err != nil {
+			return nil, err
+		}
+		if !ok {
+			result.Added++
+		}
+	}
+
+	if result.Added == 0 && result.Removed == 0 && result.Updated == 0 {
+		result.Path = ReloadPathNone
+	}
+	return result, nil
+}
+
+// rebuild собирает новый экземпляр Xray и заменяет им работающий.
+// Вызывается под reloadMu.
+func (m *Manager) rebuild(users []*database.User, reason string) (*ReloadResult, error) {
+	cfg := m.GetConfig()
+	coreConfig, err := GenerateConfig(users, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate config: %w", err)
+	}
+
+	instance, err := m.newInstance(coreConfig, users)
+	if err != nil {
+		m.speed.reset(m.loadedUserList())
+		return nil, err
+	}
+
+	// Новый экземпляр запускается до остановки старого: Xray открывает порты
+	// с SO_REUSEPORT, и оба экземпляра слушают их одновременно.
+	// Если новый не запустился, пользователи остаются на прежнем.
+	if err := instance.Start(); err != nil {
+		if closeErr := instance.Close(); closeErr != nil {
+			log.Printf("Warning: failed to close failed Xray instance: %v", closeErr)
+		}
+		m.speed.reset(m.loadedUserList())
+		return nil, fmt.Errorf("failed to start xray: %w", err)
+	}
+
+	previousUsers := m.loadedUserList()
+
+	m.mu.Lock()
+	previous := m.instance
+	m.instance = instance
+	m.configHash = configHash(cfg)
+	m.shortIDs = shortIDSet(realityShortIDs(users, cfg))
+	m.shadowsocksInbound = hasShadowsocksInbound(users, cfg)
+	m.prunePendingShortIDs()
+	m.running = true
+	m.mu.Unlock()
+	m.setLoadedUsers(users)
+
+	if previous != nil {
+		// Трафик старого экземпляра с прошлого опроса забирается до его остановки
+		// и отдается следующим QueryUserTraffic
+		m.keepTraffic(drainUserTraffic(previous, previousUsers))
+		if err := previous.Close(); err != nil {
+			log.Printf("Warning: failed to stop previous Xray instance: %v", err)
+		}
+	}
+
+	result := &ReloadResult{Path: ReloadPathRebuild, Reason: reason}
+	result.Added, result.Removed = countDiff(previousUsers, users)
+
+	m.mu.Lock()
+	m.lastReload = stamped(result)
+	m.mu.Unlock()
+
+	log.Printf("Xray reload: %s (%s), +%d -%d users", result.Path, reason, result.Added, result.Removed)
+	return result, nil
+}
+
+// drainUserTraffic читает и обнуляет счетчики трафика пользователей users в экземпляре
+func drainUserTraffic(instance *core.Instance, users []*database.User) map[string]*UserTraffic {
+	manager, ok := instance.GetFeature(stats.ManagerType()).(stats.Manager)
+	if !ok {
+		return nil
+	}
+
+	drain := func(name string) int64 {
+		counter := manager.GetCounter(name)
+		if counter == nil {
+			return 0
+		}
+		return counter.Set(0)
+	}
+
+	traffic := make(map[string]*UserTraffic, len(users))
+	for _, user := range users {
+		prefix := userStatPrefix + user.Username + ">>>traffic>>>"
+		entry := &UserTraffic{
+			Email:    user.Username,
+			Uplink:   drain(prefix + "uplink"),
+			Downlink: drain(prefix + "downlink"),
+		}
+		if entry.Uplink != 0 || entry.Downlink != 0 {
+			traffic[user.Username] = entry
+		}
+	}
+	return traffic
+}
+
+// keepTraffic добавляет трафик замененного экземпляра к еще не отданному
+func (m *Manager) keepTraffic(traffic map[string]*UserTraffic) {
+	if len(traffic) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pendingTraffic == nil {
+		m.pendingTraffic = make(map[string]*UserTraffic, len(traffic))
+	}
+	for email, drained := range traffic {
+		entry, ok := m.pendingTraffic[email]
+		if !ok {
+			entry = &UserTraffic{Email: email}
+			m.pendingTraffic[email] = entry
+		}
+		entry.Uplink += drained.Uplink
+		entry.Downlink += drained.Downlink
+	}
+}
+
+// reported запоминает и логирует результат Reload
+func (m *Manager) reported(result *ReloadResult) *ReloadResult {
+	stamped(result)
+
+	m.mu.Lock()
+	m.lastReload = result
+	m.mu.Unlock()
+
+	if result.Path != ReloadPathNone {
+		log.Printf("Xray reload: %s, +%d -%d ~%d users", result.Path, result.Added, result.Removed, result.Updated)
+	}
+	return result
+}
+
+func stamped(result *ReloadResult) *ReloadResult {
+	result.At = time.Now()
+	return result
+}
+
+// markPendingShortIDs запоминает shortId Reality пользователей, которых нет в inbound,
+// и проверяет, пора ли загрузить их пересборкой
+func (m *Manager) markPendingShortIDs(users []*database.User, cfg *Config) bool {
+	if !cfg.usesReality() {
+		return false
+	}
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, user := range users {
+		if user.ShortID == "" || m.shortIDs[user.ShortID] {
+			continue
+		}
+		if _, ok := m.pendingShortIDs[user.ShortID]; !ok {
+			m.pendingShortIDs[user.ShortID] = now
+		}
+	}
+
+	at, ok := m.shortIDReloadAtLocked(cfg)
+	return ok && !now.Before(at)
+}
+
+// prunePendingShortIDs забывает shortId, загруженные в inbound. Вызывается под mu.
+func (m *Manager) prunePendingShortIDs() {
+	for id := range m.pendingShortIDs {
+		if m.shortIDs[id] {
+			delete(m.pendingShortIDs, id)
+		}
+	}
+}
+
+// ShortIDReloadAt возвращает, когда новые shortId Reality пора загрузить пересборкой
+// (Reload в это время или позже). false - новых shortId нет.
+func (m *Manager) ShortIDReloadAt() (time.Time, bool) {
+	cfg := m.GetConfig()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.shortIDReloadAtLocked(cfg)
+}
+
+func (m *Manager) shortIDReloadAtLocked(cfg *Config) (time.Time, bool) {
+	var oldest time.Time
+	for _, since := range m.pendingShortIDs {
+		if oldest.IsZero() || since.Before(oldest) {
+			oldest = since
+		}
+	}
+	if oldest.IsZero() {
+		return time.Time{}, false
+	}
+	return oldest.Add(time.Duration(cfg.RealityShortIDDelaySeconds) * time.Second), true
+}
+
+// ClientView возвращает пользователя для клиентских конфигураций. Пока shortId
+// пользователя ждет пересборки inbound, клиенту выдается общий shortId, если он задан.
+func (m *Manager) ClientView(user *database.User) *database.User {
+	if len(m.GetConfig().RealityShortIds) == 0 {
+		return user
+	}
+
+	m.mu.RLock()
+	_, pending := m.pendingShortIDs[user.ShortID]
+	m.mu.RUnlock()
+	if !pending {
+		return user
+	}
+
+	view := *user
+	view.ShortID = ""
+	return &view
+}
+
+// missingShadowsocksInbound проверяет, нужен ли users inbound Shadowsocks, которого
+// в работающем экземпляре нет
+func (m *Manager) missingShadowsocksInbound(users []*database.User, cfg *Config) bool {
+	m.mu.RLock()
+	loaded := m.shadowsocksInbound
+	m.mu.RUnlock()
+	return !loaded && hasShadowsocksInbound(users, cfg)
+}
+
+func shortIDSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// setLoadedUsers запоминает пользователей, загруженных в inbound
+func (m *Manager) setLoadedUsers(users []*database.User) {
+	loaded := loadedUsers(users)
+
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+	m.users = loaded
+}
+
+func (m *Manager) setLoadedUser(user *database.User) {
+	copied := *user
+
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+	m.users[user.Username] = &copied
+}
+
+func (m *Manager) forgetLoadedUser(email string) {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+	delete(m.users, email)
+}
+
+func (m *Manager) loadedUserList() []*database.User {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	users := make([]*database.User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// loadedUsers возвращает копии пользователей, которые попадают в inbound, по email
+func loadedUsers(users []*database.User) map[string]*database.User {
+	loaded := make(map[string]*database.User, len(users))
+	for _, user := range users {
+		if !user.CanConnect() {
+			continue
+		}
+		copied := *user
+		loaded[user.Username] = &copied
+	}
+	return loaded
+}
+
+// sameInboundAccount проверяет, совпадают ли учетные записи пользователя в inbound
+func sameInboundAccount(a, b *database.User, cfg *Config) bool {
+	return a.UUID == b.UUID &&
+		a.Secret == b.Secret &&
+		a.TrojanPassword == b.TrojanPassword &&
+		EffectiveFlow(a, cfg) == EffectiveFlow(b, cfg) &&
+		slices.Equal(userInboundTags(a, cfg), userInboundTags(b, cfg))
+}
+
+// countDiff считает добавленных и удаленных пользователей между списками
+func countDiff(previous, next []*database.User) (added, removed int) {
+	before := loadedUsers(previous)
+	after := loadedUsers(next)
+	for email := range after {
+		if _, ok := before[email]; !ok {
+			added++
+		}
+	}
+	for email := range before {
+		if _, ok := after[email]; !ok {
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// configHash возвращает отпечаток настроек Xray без пользователей
+func configHash(cfg *Config) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package xray
+
+import (
+	"testing"
+	"vpn-service/database"
+)
+
+func TestSameInboundAccount(t *testing.T) {
+	base := func() *database.User {
+		return &database.User{
+			Username:       "alice",
+			UUID:           "b831381d-6324-4d53-ad4f-8cda48b30811",
+			Secret:         "secret",
+			TrojanPassword: "trojan",
+			IsActive:       true,
+			VlessEnabled:   true,
+			TrojanEnabled:  true,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		change    func(user *database.User)
+		transport string
+		want      bool
+	}{
+		{name: "same user", change: func(user *database.User) {}, want: true},
+		{name: "traffic does not matter", change: func(user *database.User) { user.TrafficUsed = 100 }, want: true},
+		{name: "uuid changed", change: func(user *database.User) { user.UUID = "0d2c5b0c-3f4a-4d7b-9a8e-1c2b3d4e5f60" }},
+		{name: "secret changed", change: func(user *database.User) { user.Secret = "other" }},
+		{name: "trojan password changed", change: func(user *database.User) { user.TrojanPassword = "other" }},
+		{name: "flow changed over tcp", change: func(user *database.User) { user.Flow = database.FlowNone }},
+		{name: "flow changed over grpc", change: func(user *database.User) { user.Flow = database.FlowNone }, transport: TransportGRPC, want: true},
+		{name: "protocol disabled", change: func(user *database.User) { user.VlessEnabled = false }},
+		{name: "protocol without server inbound", change: func(user *database.User) { user.VmessEnabled = true }, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Transport = tt.transport
+			cfg.TrojanPort = 8443
+
+			changed := base()
+			tt.change(changed)
+			if got := sameInboundAccount(base(), changed, cfg); got != tt.want {
+				t.Errorf("sameInboundAccount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
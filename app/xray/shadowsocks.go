@@ -0,0 +1,237 @@
+package xray
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"vpn-service/database"
+)
+
+// Методы Shadowsocks 2022 с поддержкой нескольких пользователей на одном inbound
+const (
+	ShadowsocksMethodAES128 = "2022-blake3-aes-128-gcm"
+	ShadowsocksMethodAES256 = "2022-blake3-aes-256-gcm"
+)
+
+// ShadowsocksEnabled проверяет, включен ли Shadowsocks inbound
+func (c *Config) ShadowsocksEnabled() bool {
+	return c.ShadowsocksPort > 0
+}
+
+// shadowsocksKeySize возвращает длину ключа метода в байтах
+func shadowsocksKeySize(method string) (int, error) {
+	switch method {
+	case ShadowsocksMethodAES128:
+		return 16, nil
+	case ShadowsocksMethodAES256:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported shadowsocks method: %s", method)
+	}
+}
+
+// validateShadowsocksConfig проверяет метод и серверный ключ
+func validateShadowsocksConfig(cfg *Config) error {
+	if cfg.ShadowsocksPort > 65535 {
+		return fmt.Errorf("invalid shadowsocks port: %d", cfg.ShadowsocksPort)
+	}
+
+	size, err := shadowsocksKeySize(cfg.ShadowsocksMethod)
+	if err != nil {
+		return err
+	}
+
+	if cfg.ShadowsocksServerKey == "" {
+		return fmt.Errorf("shadowsocks server key is required")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.ShadowsocksServerKey)
+	if err != nil || len(key) != size {
+		return fmt.Errorf("shadowsocks server key must be %d bytes in base64 for %s", size, cfg.ShadowsocksMethod)
+	}
+
+	return nil
+}
+
+// ShadowsocksUserKey возвращает base64 ключ пользователя для метода из конфигурации.
+// User.Secret хранит случайные байты в hex, ключ берется из их начала.
+func ShadowsocksUserKey(user *database.User, cfg *Config) (string, error) {
+	size, err := shadowsocksKeySize(cfg.ShadowsocksMethod)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := hex.DecodeString(user.Secret)
+	if err != nil || len(raw) < size {
+		return "", fmt.Errorf("user %s has no valid shadowsocks secret", user.Username)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw[:size]), nil
+}
+
+// shadowsocksPassword возвращает пароль клиента в формате "серверный_ключ:ключ_пользователя"
+func shadowsocksPassword(user *database.User, cfg *Config) (string, error) {
+	userKey, err := ShadowsocksUserKey(user, cfg)
+	if err != nil {
+		return "", err
+	}
+	return cfg.ShadowsocksServerKey + ":" + userKey, nil
+}
+
+// generateShadowsocksInbound генерирует multi-user inbound Shadowsocks 2022.
+// Возвращает nil, если нет ни одного пользователя с доступом.
+func generateShadowsocksInbound(users []*database.User, cfg *Config) map[string]interface{} {
+	clients := shadowsocksClients(users, cfg)
+	if len(clients) == 0 {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"port":     cfg.ShadowsocksPort,
+		"protocol": "shadowsocks",
+		"tag":      cfg.ShadowsocksInboundTag,
+		"settings": map[string]interface{}{
+			"method":   cfg.ShadowsocksMethod,
+			"password": cfg.ShadowsocksServerKey,
+			"network":  "tcp,udp",
+			"clients":  clients,
+		},
+	}
+}
+
+// shadowsocksClients возвращает клиентов inbound Shadowsocks
+func shadowsocksClients(users []*database.User, cfg *Config) []map[string]interface{} {
+	clients := make([]map[string]interface{}, 0)
+	for _, user := range users {
+		if !user.CanConnect() || !user.ShadowsocksEnabled {
+			continue
+		}
+		key, err := ShadowsocksUserKey(user, cfg)
+		if err != nil {
+			continue
+		}
+		clients = append(clients, map[string]interface{}{
+			"password": key,
+			"email":    user.Username,
+		})
+	}
+	return clients
+}
+
+// hasShadowsocksInbound проверяет, создаст ли GenerateConfig inbound Shadowsocks для users
+func hasShadowsocksInbound(users []*database.User, cfg *Config) bool {
+	return cfg.ShadowsocksEnabled() && len(shadowsocksClients(users, cfg)) > 0
+}
+
+// GenerateShadowsocksURI генерирует ss:// URI (SIP002) для клиента
+func GenerateShadowsocksURI(user *database.User, cfg *Config, serverIP string) (string, error) {
+	if !user.CanConnect() {
+		return "", fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	password, err := shadowsocksPassword(user, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	// Для методов 2022 userinfo передается в percent-encoding, а не в base64
+	userInfo := url.UserPassword(cfg.ShadowsocksMethod, password).String()
+
+	uri := fmt.Sprintf("ss://%s@%s:%d#%s",
+		userInfo,
+		serverIP,
+		cfg.ShadowsocksPort,
+		url.QueryEscape(cfg.profileName(user, "")),
+	)
+
+	return uri, nil
+}
+
+// GenerateShadowsocksJSON генерирует outbound Xray для клиента
+func GenerateShadowsocksJSON(user *database.User, cfg *Config, serverIP string) (string, error) {
+	if !user.CanConnect() {
+		return "", fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	password, err := shadowsocksPassword(user, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	outbound := map[string]interface{}{
+		"protocol": "shadowsocks",
+		"settings": map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"address":  serverIP,
+					"port":     cfg.ShadowsocksPort,
+					"method":   cfg.ShadowsocksMethod,
+					"password": password,
+				},
+			},
+		},
+	}
+
+	jsonBytes, err := json.MarshalIndent(outbound, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal shadowsocks config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GenerateShadowsocksSingBox генерирует outbound sing-box для клиента
+func GenerateShadowsocksSingBox(user *database.User, cfg *Config, serverIP string) (string, error) {
+	outbound, err := shadowsocksSingBoxOutbound(user, cfg, serverIP)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.MarshalIndent(outbound, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// shadowsocksClashProxy возвращает прокси Shadowsocks для Clash
+func shadowsocksClashProxy(user *database.User, cfg *Config, serverIP string) (map[string]interface{}, error) {
+	password, err := shadowsocksPassword(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"name":     cfg.profileName(user, "-ss"),
+		"type":     "ss",
+		"server":   serverIP,
+		"port":     cfg.ShadowsocksPort,
+		"cipher":   cfg.ShadowsocksMethod,
+		"password": password,
+		"udp":      true,
+	}, nil
+}
+
+// shadowsocksSingBoxOutbound возвращает outbound Shadowsocks для sing-box
+func shadowsocksSingBoxOutbound(user *database.User, cfg *Config, serverIP string) (map[string]interface{}, error) {
+	if !user.CanConnect() {
+		return nil, fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	password, err := shadowsocksPassword(user, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"type":        "shadowsocks",
+		"tag":         cfg.profileName(user, "-ss"),
+		"server":      serverIP,
+		"server_port": cfg.ShadowsocksPort,
+		"method":      cfg.ShadowsocksMethod,
+		"password":    password,
+	}, nil
+}
@@ -0,0 +1,191 @@
+package xray
+
+import (
+	"fmt"
+	"vpn-service/database"
+)
+
+// Теги outbound в профиле sing-box
+const (
+	singBoxTagProxy  = "proxy"
+	singBoxTagAuto   = "auto"
+	singBoxTagDirect = "direct"
+)
+
+// SingBoxOutbounds возвращает outbound sing-box по всем разрешенным пользователю протоколам.
+// sing-box не поддерживает xhttp, поэтому при этом транспорте VLESS пропускается.
+func SingBoxOutbounds(user *database.User, cfg *Config, serverIP string) ([]map[string]interface{}, error) {
+	if !user.CanConnect() {
+		return nil, fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	outbounds := make([]map[string]interface{}, 0)
+	if user.VlessEnabled && cfg.vlessTransport() != TransportXHTTP {
+		outbounds = append(outbounds, vlessSingBoxOutbound(user, cfg, serverIP))
+	}
+
+	if cfg.ShadowsocksEnabled() && user.ShadowsocksEnabled {
+		ssOutbound, err := shadowsocksSingBoxOutbound(user, cfg, serverIP)
+		if err != nil {
+			return nil, err
+		}
+		outbounds = append(outbounds, ssOutbound)
+	}
+
+	if cfg.TrojanEnabled() && user.TrojanEnabled {
+		if err := checkTrojanUser(user); err != nil {
+			return nil, err
+		}
+		outbounds = append(outbounds, trojanSingBoxOutbound(user, cfg, serverIP))
+	}
+
+	if cfg.VMessEnabled() && user.VmessEnabled {
+		outbounds = append(outbounds, vmessSingBoxOutbound(user, cfg))
+	}
+
+	if len(outbounds) == 0 {
+		return nil, fmt.Errorf("no sing-box compatible protocols enabled for user %s", user.Username)
+	}
+
+	return outbounds, nil
+}
+
+// BuildSingBoxProfile собирает профиль sing-box: tun inbound, DNS, маршрутизация
+// и группы selector/urltest поверх переданных outbound
+func BuildSingBoxProfile(outbounds []map[string]interface{}) map[string]interface{} {
+	tags := make([]string, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		tags = append(tags, fmt.Sprint(outbound["tag"]))
+	}
+
+	allOutbounds := []map[string]interface{}{
+		{
+			"type":      "selector",
+			"tag":       singBoxTagProxy,
+			"outbounds": append([]string{singBoxTagAuto}, tags...),
+			"default":   singBoxTagAuto,
+		},
+		{
+			"type":      "urltest",
+			"tag":       singBoxTagAuto,
+			"outbounds": tags,
+			"url":       "https://www.gstatic.com/generate_204",
+			"interval":  "5m",
+		},
+	}
+	allOutbounds = append(allOutbounds, outbounds...)
+	allOutbounds = append(allOutbounds, map[string]interface{}{
+		"type": "direct",
+		"tag":  singBoxTagDirect,
+	})
+
+	return map[string]interface{}{
+		"log": map[string]interface{}{
+			"level":     "info",
+			"timestamp": true,
+		},
+		"dns": map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"tag":     "remote",
+					"address": "https://1.1.1.1/dns-query",
+					"detour":  singBoxTagProxy,
+				},
+				{
+					"tag":     "local",
+					"address": "local",
+					"detour":  singBoxTagDirect,
+				},
+			},
+			"rules": []map[string]interface{}{
+				{
+					"outbound": "any",
+					"server":   "local",
+				},
+			},
+			"final":    "remote",
+			"strategy": "ipv4_only",
+		},
+		"inbounds": []map[string]interface{}{
+			{
+				"type":         "tun",
+				"tag":          "tun-in",
+				"address":      []string{"172.19.0.1/30"},
+				"mtu":          9000,
+				"auto_route":   true,
+				"strict_route": true,
+				"stack":        "mixed",
+			},
+		},
+		"outbounds": allOutbounds,
+		"route": map[string]interface{}{
+			"rules": []map[string]interface{}{
+				{
+					"action": "sniff",
+				},
+				{
+					"protocol": "dns",
+					"action":   "hijack-dns",
+				},
+				{
+					"ip_is_private": true,
+					"outbound":      singBoxTagDirect,
+				},
+			},
+			"final":                 singBoxTagProxy,
+			"auto_detect_interface": true,
+		},
+	}
+}
+
+// vlessSingBoxOutbound возвращает outbound VLESS для sing-box с учетом транспорта и flow
+func vlessSingBoxOutbound(user *database.User, cfg *Config, serverIP string) map[string]interface{} {
+	outbound := map[string]interface{}{
+		"type":            "vless",
+		"tag":             cfg.profileName(user, ""),
+		"server":          serverIP,
+		"server_port":     cfg.Port,
+		"uuid":            user.UUID,
+		"packet_encoding": "xudp",
+	}
+	if flow := EffectiveFlow(user, cfg); flow != "" {
+		outbound["flow"] = flow
+	}
+
+	tls := map[string]interface{}{
+		"enabled": true,
+		"utls": map[string]interface{}{
+			"enabled":     true,
+			"fingerprint": "chrome",
+		},
+	}
+	if cfg.VlessUsesReality() {
+		tls["server_name"] = cfg.RealityServerNames[0]
+		tls["reality"] = map[string]interface{}{
+			"enabled":    true,
+			"public_key": cfg.RealityPublicKey,
+			"short_id":   cfg.clientShortID(user),
+		}
+	} else {
+		tls["server_name"] = cfg.TLSDomain
+	}
+	outbound["tls"] = tls
+
+	switch cfg.vlessTransport() {
+	case TransportGRPC:
+		outbound["transport"] = map[string]interface{}{
+			"type":         "grpc",
+			"service_name": cfg.GRPCServiceName,
+		}
+	case TransportWebSocket:
+		outbound["transport"] = map[string]interface{}{
+			"type": "ws",
+			"path": cfg.WSPath,
+			"headers": map[string]interface{}{
+				"Host": cfg.TLSDomain,
+			},
+		}
+	}
+
+	return outbound
+}
@@ -0,0 +1,153 @@
+package xray
+
+import (
+	"reflect"
+	"testing"
+	"vpn-service/database"
+)
+
+func TestVlessSingBoxOutbound(t *testing.T) {
+	tests := []struct {
+		name          string
+		transport     string
+		wantFlow      string
+		wantSNI       string
+		wantReality   bool
+		wantTransport string
+	}{
+		{name: "tcp", transport: TransportTCP, wantFlow: database.FlowVision, wantSNI: "eh.vk.com", wantReality: true},
+		{name: "grpc", transport: TransportGRPC, wantSNI: "eh.vk.com", wantReality: true, wantTransport: "grpc"},
+		{name: "ws over tls", transport: TransportWebSocket, wantSNI: "vpn.example.com", wantTransport: "ws"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Transport = tt.transport
+
+			outbound := vlessSingBoxOutbound(testUser(), cfg, "203.0.113.1")
+
+			if outbound["type"] != "vless" || outbound["server"] != "203.0.113.1" || outbound["server_port"] != cfg.Port {
+				t.Errorf("unexpected outbound: %v", outbound)
+			}
+			if flow, _ := outbound["flow"].(string); flow != tt.wantFlow {
+				t.Errorf("flow = %q, want %q", flow, tt.wantFlow)
+			}
+
+			tls := outbound["tls"].(map[string]interface{})
+			if tls["server_name"] != tt.wantSNI {
+				t.Errorf("server_name = %v, want %s", tls["server_name"], tt.wantSNI)
+			}
+			reality, ok := tls["reality"].(map[string]interface{})
+			if ok != tt.wantReality {
+				t.Fatalf("reality present = %v, want %v", ok, tt.wantReality)
+			}
+			if ok && (reality["public_key"] != "public-key" || reality["short_id"] != "0123abcd") {
+				t.Errorf("unexpected reality options: %v", reality)
+			}
+
+			transport, _ := outbound["transport"].(map[string]interface{})
+			if got, _ := transport["type"].(string); got != tt.wantTransport {
+				t.Errorf("transport = %q, want %q", got, tt.wantTransport)
+			}
+		})
+	}
+}
+
+func TestSingBoxOutbounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     func(user *database.User)
+		cfg      func(cfg *Config)
+		wantTags []string
+		wantErr  bool
+	}{
+		{
+			name:     "vless only",
+			wantTags: []string{"alice"},
+		},
+		{
+			name:     "vless and vmess",
+			user:     func(user *database.User) { user.VmessEnabled = true },
+			cfg:      func(cfg *Config) { cfg.VMessPort = 2053 },
+			wantTags: []string{"alice", "alice-vmess"},
+		},
+		{
+			name:     "xhttp vless is skipped",
+			user:     func(user *database.User) { user.VmessEnabled = true },
+			cfg:      func(cfg *Config) { cfg.Transport = TransportXHTTP; cfg.VMessPort = 2053 },
+			wantTags: []string{"alice-vmess"},
+		},
+		{
+			name:    "only xhttp vless",
+			cfg:     func(cfg *Config) { cfg.Transport = TransportXHTTP },
+			wantErr: true,
+		},
+		{
+			name:    "inactive user",
+			user:    func(user *database.User) { user.IsActive = false },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := testUser()
+			if tt.user != nil {
+				tt.user(user)
+			}
+			cfg := testConfig()
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+
+			outbounds, err := SingBoxOutbounds(user, cfg, "203.0.113.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SingBoxOutbounds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			tags := make([]string, 0, len(outbounds))
+			for _, outbound := range outbounds {
+				tags = append(tags, outbound["tag"].(string))
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("outbound tags = %v, want %v", tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestBuildSingBoxProfile(t *testing.T) {
+	profile := BuildSingBoxProfile([]map[string]interface{}{
+		{"type": "vless", "tag": "alice"},
+		{"type": "vmess", "tag": "alice-vmess"},
+	})
+
+	outbounds := profile["outbounds"].([]map[string]interface{})
+	tags := make([]string, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		tags = append(tags, outbound["tag"].(string))
+	}
+	wantTags := []string{singBoxTagProxy, singBoxTagAuto, "alice", "alice-vmess", singBoxTagDirect}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("outbound tags = %v, want %v", tags, wantTags)
+	}
+
+	selector := outbounds[0]
+	if selector["type"] != "selector" || selector["default"] != singBoxTagAuto ||
+		!reflect.DeepEqual(selector["outbounds"], []string{singBoxTagAuto, "alice", "alice-vmess"}) {
+		t.Errorf("unexpected selector: %v", selector)
+	}
+	urltest := outbounds[1]
+	if urltest["type"] != "urltest" || !reflect.DeepEqual(urltest["outbounds"], []string{"alice", "alice-vmess"}) {
+		t.Errorf("unexpected urltest: %v", urltest)
+	}
+
+	route := profile["route"].(map[string]interface{})
+	if route["final"] != singBoxTagProxy {
+		t.Errorf("route final = %v, want %s", route["final"], singBoxTagProxy)
+	}
+}
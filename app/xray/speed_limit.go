@@ -0,0 +1,260 @@
+package xray
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"vpn-service/database"
+
+	"github.com/xtls/xray-core/common/buf"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/transport"
+	"golang.org/x/time/rate"
+)
+
+// Направления трафика для ограничения скорости
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// directOutboundTag - outbound freedom, перед которым стоит ограничение скорости
+const directOutboundTag = "direct"
+
+// minSpeedBurst - минимальный размер корзины токенов: один вызов чтения
+// отдает до нескольких буферов Xray, слишком маленькая корзина дробит их
+const minSpeedBurst = 64 << 10
+
+// spliceCopyDisabled - значение session.Inbound.CanSpliceCopy, запрещающее splice.
+// При splice данные идут между сокетами напрямую, минуя link и ограничение.
+const spliceCopyDisabled = 3
+
+// ThrottleHook вызывается, когда трафик пользователя (по ID) задержан ограничением скорости
+type ThrottleHook func(userID uint, direction string, delay time.Duration)
+
+// userSpeed - корзины токенов пользователя (nil - направление без ограничения)
+type userSpeed struct {
+	id   uint
+	up   *rate.Limiter
+	down *rate.Limiter
+}
+
+// speedLimiter хранит ограничения скорости пользователей по email.
+// Корзины меняются на месте, поэтому новые лимиты действуют на открытые соединения,
+// кроме соединений, открытых без ограничения с XTLS Vision: они могли перейти на splice,
+// при котором данные идут мимо ограничения. Такие соединения ограничиваются после
+// переподключения.
+type speedLimiter struct {
+	mu    sync.RWMutex
+	users map[string]*userSpeed
+	hook  ThrottleHook
+}
+
+func newSpeedLimiter() *speedLimiter {
+	return &speedLimiter{users: make(map[string]*userSpeed)}
+}
+
+// set применяет ограничения пользователя (SpeedLimitUp/SpeedLimitDown, 0 - без ограничения)
+func (l *speedLimiter) set(user *database.User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	email := user.Username
+	if user.SpeedLimitUp <= 0 && user.SpeedLimitDown <= 0 {
+		delete(l.users, email)
+		return
+	}
+
+	speed, ok := l.users[email]
+	if !ok {
+		speed = &userSpeed{}
+		l.users[email] = speed
+	}
+	speed.id = user.ID
+	speed.up = updateBucket(speed.up, user.SpeedLimitUp)
+	speed.down = updateBucket(speed.down, user.SpeedLimitDown)
+}
+
+// remove снимает ограничения пользователя
+func (l *speedLimiter) remove(email string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, email)
+}
+
+// reset заменяет ограничения всех пользователей
+func (l *speedLimiter) reset(users []*database.User) {
+	l.mu.Lock()
+	l.users = make(map[string]*userSpeed)
+	l.mu.Unlock()
+
+	for _, user := range users {
+		if user.CanConnect() {
+			l.set(user)
+		}
+	}
+}
+
+func (l *speedLimiter) setHook(hook ThrottleHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hook = hook
+}
+
+// bucket возвращает корзину пользователя для направления и ID пользователя
+func (l *speedLimiter) bucket(email, direction string) (*rate.Limiter, uint, ThrottleHook) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	speed, ok := l.users[email]
+	if !ok {
+		return nil, 0, l.hook
+	}
+	if direction == DirectionUpload {
+		return speed.up, speed.id, l.hook
+	}
+	return speed.down, speed.id, l.hook
+}
+
+func (l *speedLimiter) limited(email string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.users[email]
+	return ok
+}
+
+// wait блокирует, пока корзина пользователя не наберет n байт
+func (l *speedLimiter) wait(ctx context.Context, email, direction string, n int) {
+	var (
+		delay time.Duration
+		id    uint
+		hook  ThrottleHook
+	)
+	for n > 0 {
+		var bucket *rate.Limiter
+		bucket, id, hook = l.bucket(email, direction)
+		if bucket == nil {
+			break
+		}
+
+		chunk := n
+		if burst := bucket.Burst(); chunk > burst {
+			chunk = burst
+		}
+		start := time.Now()
+		if err := bucket.WaitN(ctx, chunk); err != nil {
+			// Соединение закрыто
+			break
+		}
+		delay += time.Since(start)
+		n -= chunk
+	}
+
+	if hook != nil && delay > time.Millisecond {
+		hook(id, direction, delay)
+	}
+}
+
+// updateBucket создает или перенастраивает корзину под лимит bytesPerSecond
+func updateBucket(bucket *rate.Limiter, bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond)
+	if burst < minSpeedBurst {
+		burst = minSpeedBurst
+	}
+	if bucket == nil {
+		return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+	}
+	bucket.SetLimit(rate.Limit(bytesPerSecond))
+	bucket.SetBurst(burst)
+	return bucket
+}
+
+// throttledHandler - outbound, который ограничивает скорость пользователя
+// перед передачей соединения исходному обработчику (freedom)
+type throttledHandler struct {
+	outbound.Handler
+	limiter *speedLimiter
+}
+
+// Dispatch оборачивает link соединения: чтение - upload, запись - download
+func (h *throttledHandler) Dispatch(ctx context.Context, link *transport.Link) {
+	inbound := session.InboundFromContext(ctx)
+	if inbound == nil || inbound.User == nil || inbound.User.Email == "" {
+		h.Handler.Dispatch(ctx, link)
+		return
+	}
+
+	email := inbound.User.Email
+	if h.limiter.limited(email) {
+		inbound.CanSpliceCopy = spliceCopyDisabled
+	}
+
+	h.Handler.Dispatch(ctx, &transport.Link{
+		Reader: &throttledReader{Reader: link.Reader, ctx: ctx, limiter: h.limiter, email: email},
+		Writer: &throttledWriter{Writer: link.Writer, ctx: ctx, limiter: h.limiter, email: email},
+	})
+}
+
+type throttledReader struct {
+	buf.Reader
+	ctx     context.Context
+	limiter *speedLimiter
+	email   string
+}
+
+func (r *throttledReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	mb, err := r.Reader.ReadMultiBuffer()
+	if size := int(mb.Len()); size > 0 {
+		r.limiter.wait(r.ctx, r.email, DirectionUpload, size)
+	}
+	return mb, err
+}
+
+type throttledWriter struct {
+	buf.Writer
+	ctx     context.Context
+	limiter *speedLimiter
+	email   string
+}
+
+func (w *throttledWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	if size := int(mb.Len()); size > 0 {
+		w.limiter.wait(w.ctx, w.email, DirectionDownload, size)
+	}
+	return w.Writer.WriteMultiBuffer(mb)
+}
+
+// installSpeedLimiter подменяет outbound direct на обертку с ограничением скорости.
+// Вызывается до instance.Start(): менеджер outbound запустит обертку сам.
+func installSpeedLimiter(instance *core.Instance, limiter *speedLimiter) {
+	manager, ok := instance.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if !ok {
+		log.Println("Warning: xray outbound manager is not available, speed limits are disabled")
+		return
+	}
+
+	direct := manager.GetHandler(directOutboundTag)
+	if direct == nil {
+		log.Printf("Warning: outbound %q not found, speed limits are disabled", directOutboundTag)
+		return
+	}
+
+	ctx := context.Background()
+	if err := manager.RemoveHandler(ctx, directOutboundTag); err != nil {
+		log.Printf("Warning: failed to wrap outbound %q, speed limits are disabled: %v", directOutboundTag, err)
+		return
+	}
+	if err := manager.AddHandler(ctx, &throttledHandler{Handler: direct, limiter: limiter}); err != nil {
+		log.Printf("Warning: failed to install speed limiter, restoring outbound %q: %v", directOutboundTag, err)
+		if err := manager.AddHandler(ctx, direct); err != nil {
+			log.Printf("Warning: failed to restore outbound %q: %v", directOutboundTag, err)
+		}
+	}
+}
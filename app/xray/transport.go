@@ -0,0 +1,258 @@
+package xray
+
+import (
+	"fmt"
+	"net/url"
+	"vpn-service/database"
+)
+
+// Транспорты VLESS inbound
+const (
+	TransportTCP       = "tcp"
+	TransportXHTTP     = "xhttp"
+	TransportGRPC      = "grpc"
+	TransportWebSocket = "ws"
+)
+
+// Режимы XHTTP
+var xhttpModes = []string{"auto", "packet-up", "stream-up", "stream-one"}
+
+// IsValidTransport проверяет название транспорта
+func IsValidTransport(transport string) bool {
+	switch transport {
+	case TransportTCP, TransportXHTTP, TransportGRPC, TransportWebSocket:
+		return true
+	}
+	return false
+}
+
+// vlessTransport возвращает транспорт VLESS (tcp, если не задан)
+func (c *Config) vlessTransport() string {
+	if c.Transport == "" {
+		return TransportTCP
+	}
+	return c.Transport
+}
+
+// VlessUsesReality проверяет, работает ли VLESS через Reality.
+// WebSocket Reality не поддерживает, для него используется обычный TLS.
+func (c *Config) VlessUsesReality() bool {
+	return c.vlessTransport() != TransportWebSocket
+}
+
+// validateTransportConfig проверяет настройки транспорта VLESS
+func validateTransportConfig(cfg *Config) error {
+	transport := cfg.vlessTransport()
+	if !IsValidTransport(transport) {
+		return fmt.Errorf("invalid transport: %s (expected tcp, xhttp, grpc or ws)", transport)
+	}
+
+	switch transport {
+	case TransportXHTTP:
+		if cfg.XHTTPPath == "" || cfg.XHTTPPath[0] != '/' {
+			return fmt.Errorf("invalid xhttp path: %q", cfg.XHTTPPath)
+		}
+		if cfg.XHTTPMode != "" && !containsString(xhttpModes, cfg.XHTTPMode) {
+			return fmt.Errorf("invalid xhttp mode: %s", cfg.XHTTPMode)
+		}
+	case TransportGRPC:
+		if cfg.GRPCServiceName == "" {
+			return fmt.Errorf("grpc service name is required")
+		}
+	case TransportWebSocket:
+		if cfg.WSPath == "" || cfg.WSPath[0] != '/' {
+			return fmt.Errorf("invalid websocket path: %q", cfg.WSPath)
+		}
+		if err := validateTLSConfig(cfg); err != nil {
+			return fmt.Errorf("websocket: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateTLSConfig проверяет сертификат для транспортов без Reality
+func validateTLSConfig(cfg *Config) error {
+	if cfg.TLSDomain == "" {
+		return fmt.Errorf("tls domain is required")
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return fmt.Errorf("tls certificate and key files are required")
+	}
+	return nil
+}
+
+// xhttpMode возвращает режим XHTTP (auto, если не задан)
+func (c *Config) xhttpMode() string {
+	if c.XHTTPMode == "" {
+		return "auto"
+	}
+	return c.XHTTPMode
+}
+
+// vlessStreamSettings возвращает streamSettings VLESS inbound для выбранного транспорта
+func vlessStreamSettings(cfg *Config, shortIDs []string) map[string]interface{} {
+	var settings map[string]interface{}
+	if cfg.VlessUsesReality() {
+		settings = realityStreamSettings(cfg, cfg.InboundTag, shortIDs)
+	} else {
+		settings = map[string]interface{}{
+			"security": "tls",
+			"tlsSettings": map[string]interface{}{
+				"serverName": cfg.TLSDomain,
+				"certificates": []map[string]interface{}{
+					{
+						"certificateFile": cfg.TLSCertFile,
+						"keyFile":         cfg.TLSKeyFile,
+					},
+				},
+			},
+		}
+	}
+
+	addTransportSettings(settings, cfg)
+	return settings
+}
+
+// clientStreamSettings возвращает streamSettings VLESS outbound для клиента
+func clientStreamSettings(user *database.User, cfg *Config) map[string]interface{} {
+	var settings map[string]interface{}
+	if cfg.VlessUsesReality() {
+		settings = map[string]interface{}{
+			"security": "reality",
+			"realitySettings": map[string]interface{}{
+				"serverName":  cfg.RealityServerNames[0],
+				"fingerprint": "chrome",
+				"publicKey":   cfg.RealityPublicKey,
+				"shortId":     cfg.clientShortID(user),
+				"spiderX":     "",
+			},
+		}
+	} else {
+		settings = map[string]interface{}{
+			"security": "tls",
+			"tlsSettings": map[string]interface{}{
+				"serverName":  cfg.TLSDomain,
+				"fingerprint": "chrome",
+			},
+		}
+	}
+
+	addTransportSettings(settings, cfg)
+	return settings
+}
+
+// addTransportSettings дополняет streamSettings параметрами транспорта.
+// Параметры одинаковы для сервера и клиента.
+func addTransportSettings(settings map[string]interface{}, cfg *Config) {
+	transport := cfg.vlessTransport()
+	settings["network"] = transport
+
+	switch transport {
+	case TransportXHTTP:
+		xhttp := map[string]interface{}{
+			"path": cfg.XHTTPPath,
+			"mode": cfg.xhttpMode(),
+		}
+		if cfg.XHTTPHost != "" {
+			xhttp["host"] = cfg.XHTTPHost
+		}
+		settings["xhttpSettings"] = xhttp
+	case TransportGRPC:
+		settings["grpcSettings"] = map[string]interface{}{
+			"serviceName": cfg.GRPCServiceName,
+		}
+	case TransportWebSocket:
+		settings["wsSettings"] = map[string]interface{}{
+			"path": cfg.WSPath,
+			"headers": map[string]interface{}{
+				"Host": cfg.TLSDomain,
+			},
+		}
+	}
+}
+
+// vlessURIParams возвращает параметры VLESS URI для транспорта и безопасности
+func vlessURIParams(user *database.User, cfg *Config) url.Values {
+	params := url.Values{}
+	transport := cfg.vlessTransport()
+	params.Set("type", transport)
+	params.Set("fp", "chrome")
+
+	if cfg.VlessUsesReality() {
+		params.Set("security", "reality")
+		params.Set("pbk", cfg.RealityPublicKey)
+		params.Set("sni", cfg.RealityServerNames[0])
+		params.Set("sid", cfg.clientShortID(user))
+	} else {
+		params.Set("security", "tls")
+		params.Set("sni", cfg.TLSDomain)
+	}
+
+	switch transport {
+	case TransportXHTTP:
+		params.Set("path", cfg.XHTTPPath)
+		params.Set("mode", cfg.xhttpMode())
+		if cfg.XHTTPHost != "" {
+			params.Set("host", cfg.XHTTPHost)
+		}
+	case TransportGRPC:
+		params.Set("serviceName", cfg.GRPCServiceName)
+		params.Set("mode", "gun")
+	case TransportWebSocket:
+		params.Set("path", cfg.WSPath)
+		params.Set("host", cfg.TLSDomain)
+	}
+
+	return params
+}
+
+// clashTransportOptions дополняет прокси Clash параметрами транспорта и безопасности
+func clashTransportOptions(proxy map[string]interface{}, user *database.User, cfg *Config) {
+	transport := cfg.vlessTransport()
+	proxy["network"] = transport
+	proxy["tls"] = true
+	proxy["client-fingerprint"] = "chrome"
+
+	if cfg.VlessUsesReality() {
+		proxy["servername"] = cfg.RealityServerNames[0]
+		proxy["reality-opts"] = map[string]interface{}{
+			"public-key": cfg.RealityPublicKey,
+			"short-id":   cfg.clientShortID(user),
+		}
+	} else {
+		proxy["servername"] = cfg.TLSDomain
+	}
+
+	switch transport {
+	case TransportXHTTP:
+		opts := map[string]interface{}{
+			"path": cfg.XHTTPPath,
+			"mode": cfg.xhttpMode(),
+		}
+		if cfg.XHTTPHost != "" {
+			opts["host"] = cfg.XHTTPHost
+		}
+		proxy["xhttp-opts"] = opts
+	case TransportGRPC:
+		proxy["grpc-opts"] = map[string]interface{}{
+			"grpc-service-name": cfg.GRPCServiceName,
+		}
+	case TransportWebSocket:
+		proxy["ws-opts"] = map[string]interface{}{
+			"path": cfg.WSPath,
+			"headers": map[string]interface{}{
+				"Host": cfg.TLSDomain,
+			},
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
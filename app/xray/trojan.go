@@ -0,0 +1,171 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"vpn-service/database"
+)
+
+// TrojanEnabled проверяет, включен ли Trojan inbound
+func (c *Config) TrojanEnabled() bool {
+	return c.TrojanPort > 0
+}
+
+// generateTrojanInbound генерирует Trojan inbound с теми же Reality настройками, что и VLESS
+func generateTrojanInbound(users []*database.User, cfg *Config, shortIDs []string) map[string]interface{} {
+	clients := make([]map[string]interface{}, 0)
+	for _, user := range users {
+		if !user.CanConnect() || !user.TrojanEnabled || user.TrojanPassword == "" {
+			continue
+		}
+		clients = append(clients, map[string]interface{}{
+			"password": user.TrojanPassword,
+			"email":    user.Username,
+		})
+	}
+
+	return map[string]interface{}{
+		"port":     cfg.TrojanPort,
+		"protocol": "trojan",
+		"tag":      cfg.TrojanInboundTag,
+		"settings": map[string]interface{}{
+			"clients": clients,
+		},
+		"streamSettings": realityStreamSettings(cfg, cfg.TrojanInboundTag, shortIDs),
+		"sniffing": map[string]interface{}{
+			"enabled": false,
+		},
+	}
+}
+
+// checkTrojanUser проверяет, можно ли выдать пользователю Trojan конфигурацию
+func checkTrojanUser(user *database.User) error {
+	if !user.CanConnect() {
+		return fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+	if user.TrojanPassword == "" {
+		return fmt.Errorf("user %s has no trojan password", user.Username)
+	}
+	return nil
+}
+
+// GenerateTrojanURI генерирует trojan:// URI для клиента
+func GenerateTrojanURI(user *database.User, cfg *Config, serverIP string) (string, error) {
+	if err := checkTrojanUser(user); err != nil {
+		return "", err
+	}
+
+	// Формат: trojan://PASSWORD@SERVER:PORT?params#REMARK
+	params := url.Values{}
+	params.Set("type", "tcp")
+	params.Set("security", "reality")
+	params.Set("pbk", cfg.RealityPublicKey)
+	params.Set("fp", "chrome")
+	params.Set("sni", cfg.RealityServerNames[0])
+	params.Set("sid", cfg.clientShortID(user))
+
+	uri := fmt.Sprintf("trojan://%s@%s:%d?%s#%s",
+		url.PathEscape(user.TrojanPassword),
+		serverIP,
+		cfg.TrojanPort,
+		params.Encode(),
+		url.QueryEscape(cfg.profileName(user, "")),
+	)
+
+	return uri, nil
+}
+
+// GenerateTrojanJSON генерирует outbound Xray для клиента
+func GenerateTrojanJSON(user *database.User, cfg *Config, serverIP string) (string, error) {
+	if err := checkTrojanUser(user); err != nil {
+		return "", err
+	}
+
+	outbound := map[string]interface{}{
+		"protocol": "trojan",
+		"settings": map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"address":  serverIP,
+					"port":     cfg.TrojanPort,
+					"password": user.TrojanPassword,
+				},
+			},
+		},
+		"streamSettings": map[string]interface{}{
+			"network":  "tcp",
+			"security": "reality",
+			"realitySettings": map[string]interface{}{
+				"serverName":  cfg.RealityServerNames[0],
+				"fingerprint": "chrome",
+				"publicKey":   cfg.RealityPublicKey,
+				"shortId":     cfg.clientShortID(user),
+			},
+		},
+	}
+
+	jsonBytes, err := json.MarshalIndent(outbound, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal trojan config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GenerateTrojanSingBox генерирует outbound sing-box для клиента
+func GenerateTrojanSingBox(user *database.User, cfg *Config, serverIP string) (string, error) {
+	if err := checkTrojanUser(user); err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.MarshalIndent(trojanSingBoxOutbound(user, cfg, serverIP), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// trojanSingBoxOutbound возвращает outbound Trojan для sing-box
+func trojanSingBoxOutbound(user *database.User, cfg *Config, serverIP string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "trojan",
+		"tag":         cfg.profileName(user, "-trojan"),
+		"server":      serverIP,
+		"server_port": cfg.TrojanPort,
+		"password":    user.TrojanPassword,
+		"tls": map[string]interface{}{
+			"enabled":     true,
+			"server_name": cfg.RealityServerNames[0],
+			"utls": map[string]interface{}{
+				"enabled":     true,
+				"fingerprint": "chrome",
+			},
+			"reality": map[string]interface{}{
+				"enabled":    true,
+				"public_key": cfg.RealityPublicKey,
+				"short_id":   cfg.clientShortID(user),
+			},
+		},
+	}
+}
+
+// trojanClashProxy возвращает прокси Trojan для Clash
+func trojanClashProxy(user *database.User, cfg *Config, serverIP string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               cfg.profileName(user, "-trojan"),
+		"type":               "trojan",
+		"server":             serverIP,
+		"port":               cfg.TrojanPort,
+		"password":           user.TrojanPassword,
+		"network":            "tcp",
+		"sni":                cfg.RealityServerNames[0],
+		"client-fingerprint": "chrome",
+		"udp":                true,
+		"reality-opts": map[string]interface{}{
+			"public-key": cfg.RealityPublicKey,
+			"short-id":   cfg.clientShortID(user),
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"vpn-service/database"
+)
+
+// VMessEnabled проверяет, включен ли VMess inbound
+func (c *Config) VMessEnabled() bool {
+	return c.VMessPort > 0
+}
+
+// validateVMessConfig проверяет параметры VMess WebSocket + TLS
+func validateVMessConfig(cfg *Config) error {
+	if cfg.VMessPort > 65535 {
+		return fmt.Errorf("invalid vmess port: %d", cfg.VMessPort)
+	}
+	if err := validateTLSConfig(cfg); err != nil {
+		return fmt.Errorf("vmess: %w", err)
+	}
+	if cfg.VMessPath == "" || cfg.VMessPath[0] != '/' {
+		return fmt.Errorf("invalid vmess path: %q", cfg.VMessPath)
+	}
+	return nil
+}
+
+// generateVMessInbound генерирует VMess inbound (WebSocket + TLS)
+func generateVMessInbound(users []*database.User, cfg *Config) map[string]interface{} {
+	clients := make([]map[string]interface{}, 0)
+	for _, user := range users {
+		if !user.CanConnect() || !user.VmessEnabled {
+			continue
+		}
+		clients = append(clients, map[string]interface{}{
+			"id":    user.UUID,
+			"email": user.Username,
+		})
+	}
+
+	return map[string]interface{}{
+		"port":     cfg.VMessPort,
+		"protocol": "vmess",
+		"tag":      cfg.VMessInboundTag,
+		"settings": map[string]interface{}{
+			"clients": clients,
+		},
+		"streamSettings": map[string]interface{}{
+			"network":  "ws",
+			"security": "tls",
+			"tlsSettings": map[string]interface{}{
+				"serverName": cfg.TLSDomain,
+				"certificates": []map[string]interface{}{
+					{
+						"certificateFile": cfg.TLSCertFile,
+						"keyFile":         cfg.TLSKeyFile,
+					},
+				},
+			},
+			"wsSettings": map[string]interface{}{
+				"path": cfg.VMessPath,
+			},
+		},
+		"sniffing": map[string]interface{}{
+			"enabled": false,
+		},
+	}
+}
+
+// GenerateVMessJSON генерирует outbound Xray для клиента
+func GenerateVMessJSON(user *database.User, cfg *Config) (string, error) {
+	if !user.CanConnect() {
+		return "", fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	outbound := map[string]interface{}{
+		"protocol": "vmess",
+		"settings": map[string]interface{}{
+			"vnext": []map[string]interface{}{
+				{
+					"address": cfg.TLSDomain,
+					"port":    cfg.VMessPort,
+					"users": []map[string]interface{}{
+						{
+							"id":       user.UUID,
+							"security": "auto",
+						},
+					},
+				},
+			},
+		},
+		"streamSettings": map[string]interface{}{
+			"network":  "ws",
+			"security": "tls",
+			"tlsSettings": map[string]interface{}{
+				"serverName": cfg.TLSDomain,
+			},
+			"wsSettings": map[string]interface{}{
+				"path": cfg.VMessPath,
+				"headers": map[string]interface{}{
+					"Host": cfg.TLSDomain,
+				},
+			},
+		},
+	}
+
+	jsonBytes, err := json.MarshalIndent(outbound, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal vmess config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GenerateVMessSingBox генерирует outbound sing-box для клиента
+func GenerateVMessSingBox(user *database.User, cfg *Config) (string, error) {
+	if !user.CanConnect() {
+		return "", fmt.Errorf("user cannot connect (inactive, expired or over limit)")
+	}
+
+	jsonBytes, err := json.MarshalIndent(vmessSingBoxOutbound(user, cfg), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sing-box config: %w", err)
+	}
+
+	return string(jsonBytes), nil
+}
+
+// vmessSingBoxOutbound возвращает outbound VMess для sing-box
+func vmessSingBoxOutbound(user *database.User, cfg *Config) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "vmess",
+		"tag":         cfg.profileName(user, "-vmess"),
+		"server":      cfg.TLSDomain,
+		"server_port": cfg.VMessPort,
+		"uuid":        user.UUID,
+		"security":    "auto",
+		"alter_id":    0,
+		"tls": map[string]interface{}{
+			"enabled":     true,
+			"server_name": cfg.TLSDomain,
+		},
+		"transport": map[string]interface{}{
+			"type": "ws",
+			"path": cfg.VMessPath,
+			"headers": map[string]interface{}{
+				"Host": cfg.TLSDomain,
+			},
+		},
+	}
+}
+
+// vmessClashProxy возвращает прокси VMess для Clash
+func vmessClashProxy(user *database.User, cfg *Config) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       cfg.profileName(user, "-vmess"),
+		"type":       "vmess",
+		"server":     cfg.TLSDomain,
+		"port":       cfg.VMessPort,
+		"uuid":       user.UUID,
+		"alterId":    0,
+		"cipher":     "auto",
+		"network":    "ws",
+		"tls":        true,
+		"servername": cfg.TLSDomain,
+		"udp":        true,
+		"ws-opts": map[string]interface{}{
+			"path": cfg.VMessPath,
+			"headers": map[string]interface{}{
+				"Host": cfg.TLSDomain,
+			},
+		},
+	}
+}